@@ -1,7 +1,9 @@
 package gosh
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 )
@@ -45,14 +47,74 @@ func ExpandAlias(command string) string {
 	if len(parts) == 0 {
 		return command
 	}
+	return strings.Join(expandAliases(parts), " ")
+}
 
-	expanded, exists := GetAlias(parts[0])
-	if !exists {
-		return command
+// expandAliases expands an alias in the command word of words. The
+// expansion's own command word is expanded in turn, but an alias is never
+// expanded inside itself, so alias ls='ls --color' terminates. As in bash,
+// an alias whose value ends in a space also expands the word after it.
+func expandAliases(words []string) []string {
+	return expandAliasWords(words, map[string]bool{})
+}
+
+func expandAliasWords(words []string, seen map[string]bool) []string {
+	if len(words) == 0 {
+		return words
+	}
+	value, ok := GetAlias(words[0])
+	if !ok || seen[words[0]] {
+		return words
+	}
+
+	nested := make(map[string]bool, len(seen)+1)
+	for name := range seen {
+		nested[name] = true
+	}
+	nested[words[0]] = true
+
+	result := expandAliasWords(strings.Fields(value), nested)
+	rest := words[1:]
+	if strings.HasSuffix(value, " ") {
+		rest = expandAliases(rest)
+	}
+	return append(result, rest...)
+}
+
+// aliasesEnvVar carries the alias table to child gosh processes when the
+// exportaliases option is set.
+const aliasesEnvVar = "GOSH_ALIASES"
+
+// ExportAliases serializes the alias table for GOSH_ALIASES.
+func ExportAliases() string {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	data, _ := json.Marshal(aliases)
+	return string(data)
+}
+
+// ImportAliases installs the aliases serialized by ExportAliases. An empty
+// string is not an error, so the value of an unset variable can be passed.
+func ImportAliases(data string) error {
+	if data == "" {
+		return nil
+	}
+	var imported map[string]string
+	if err := json.Unmarshal([]byte(data), &imported); err != nil {
+		return fmt.Errorf("invalid %s: %v", aliasesEnvVar, err)
 	}
+	for name, command := range imported {
+		SetAlias(name, command)
+	}
+	return nil
+}
 
-	if len(parts) > 1 {
-		expanded += " " + strings.Join(parts[1:], " ")
+// childEnvironment returns the environment for an external command,
+// adding the alias table when aliases are exported.
+func childEnvironment() []string {
+	env := os.Environ()
+	if GetGlobalState().GetOption("exportaliases") {
+		env = append(env, aliasesEnvVar+"="+ExportAliases())
 	}
-	return expanded
+	return env
 }
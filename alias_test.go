@@ -0,0 +1,144 @@
+package gosh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAliasesExportedToChildGosh(t *testing.T) {
+	useTempCWD(t)
+	defer GetGlobalState().SetOption("exportaliases", false)
+	defer RemoveAlias("ll")
+
+	SetAlias("ll", "ls -l")
+
+	childEnv := func() string {
+		cmd, err := NewCommand("printenv GOSH_ALIASES", NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Run()
+		return strings.TrimSpace(stdout.String())
+	}
+
+	if value := childEnv(); value != "" {
+		t.Errorf("GOSH_ALIASES = %q before set -o exportaliases, want it unset", value)
+	}
+
+	runEcho(t, "set -o exportaliases")
+	value := childEnv()
+	if value == "" {
+		t.Fatalf("GOSH_ALIASES was not passed to the child")
+	}
+
+	// Simulate the child gosh importing the table at startup.
+	RemoveAlias("ll")
+	if err := ImportAliases(value); err != nil {
+		t.Fatalf("ImportAliases(%q) error = %v", value, err)
+	}
+	if command, ok := GetAlias("ll"); !ok || command != "ls -l" {
+		t.Errorf("GetAlias(%q) = (%q, %v), want %q", "ll", command, ok, "ls -l")
+	}
+
+	if err := ImportAliases("not json"); err == nil {
+		t.Errorf("ImportAliases of invalid data succeeded, want an error")
+	}
+}
+
+func TestAliasExpansion(t *testing.T) {
+	for _, name := range []string{"greet", "hi", "say", "loop", "e", "word"} {
+		defer RemoveAlias(name)
+	}
+	SetAlias("greet", "hi there")
+	SetAlias("hi", "echo hello")
+	SetAlias("loop", "loop --again")
+	SetAlias("e", "echo ")
+	SetAlias("word", "expanded")
+
+	tests := []struct {
+		input    []string
+		expected []string
+	}{
+		{[]string{"hi", "world"}, []string{"echo", "hello", "world"}},
+		{[]string{"greet"}, []string{"echo", "hello", "there"}},
+		{[]string{"loop", "x"}, []string{"loop", "--again", "x"}},
+		{[]string{"echo", "hi"}, []string{"echo", "hi"}},
+		{[]string{"e", "word", "word"}, []string{"echo", "expanded", "word"}},
+		{[]string{"'hi'"}, []string{"'hi'"}},
+	}
+
+	for _, tt := range tests {
+		if result := expandAliases(tt.input); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("expandAliases(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestAliasRunsUnderlyingCommand(t *testing.T) {
+	useTempCWD(t)
+	defer RemoveAlias("say")
+	defer RemoveAlias("count")
+
+	runEcho(t, "alias say='echo said'")
+	if output := runEcho(t, "say it"); output != "said it\n" {
+		t.Errorf("say it = %q, want %q", output, "said it\n")
+	}
+
+	runEcho(t, "alias count='wc -l'")
+	if output := runEcho(t, "printf 'a\\nb\\n' | count"); strings.TrimSpace(output) != "2" {
+		t.Errorf("printf | count = %q, want 2", output)
+	}
+}
+
+func TestRawCommandSkipsAliases(t *testing.T) {
+	dir := useTempCWD(t)
+	defer RemoveAlias("echo")
+	SetAlias("echo", "printf aliased")
+	script := filepath.Join(dir, "raw.sh")
+	if err := os.WriteFile(script, []byte("echo sourced\n"), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	gs := GetGlobalState()
+	defer gs.ClearChpwdHooks()
+	gs.AddChpwdHook("echo hooked")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo plain", "plain\n"},
+		{"echo piped | cat", "piped\n"},
+		{"echo $(echo substituted)", "substituted\n"},
+		{"(echo subshell)", "subshell\n"},
+		{"source " + script, "sourced\n"},
+		{"cd sub; cd ..", "hooked\nhooked\n"},
+	}
+	for _, tt := range tests {
+		cmd, err := NewCommandRaw(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Run()
+		if stdout.String() != tt.expected {
+			t.Errorf("raw %q = %q, want %q", tt.input, stdout.String(), tt.expected)
+		}
+	}
+
+	if output := runEcho(t, "echo plain"); output != "aliased" {
+		t.Errorf("echo plain outside raw mode = %q, want the alias to apply", output)
+	}
+	if output := runEcho(t, "eval echo evaluated"); output != "evaluated\n" {
+		t.Errorf("eval echo evaluated = %q, want eval to run raw", output)
+	}
+}
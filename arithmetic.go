@@ -0,0 +1,287 @@
+package gosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arithmeticEnd returns the index of the "))" closing an arithmetic
+// expansion whose expression starts at start, or -1 if there is none.
+func arithmeticEnd(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				if strings.HasPrefix(s[i:], "))") {
+					return i
+				}
+				return -1
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// EvalArithmetic evaluates a shell arithmetic expression such as
+// "x * (2 + 3)". Variables may be named with or without a leading $; an
+// unset or empty variable counts as 0.
+func EvalArithmetic(expr string) (int64, error) {
+	tokens, err := tokenizeArithmetic(expr)
+	if err != nil {
+		return 0, err
+	}
+	p := &arithmeticParser{tokens: tokens}
+	value, err := p.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.tokens) {
+		return 0, fmt.Errorf("syntax error in expression (error token is %q)", p.tokens[p.pos])
+	}
+	return value, nil
+}
+
+var arithmeticOperators = []string{
+	"**", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+", "-", "*", "/", "%", "(", ")", "<", ">", "&", "|", "^", "!", "~",
+}
+
+func tokenizeArithmetic(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case isArithmeticWordChar(c) || c == '$':
+			j := i + 1
+			for j < len(expr) && isArithmeticWordChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, strings.TrimPrefix(expr[i:j], "$"))
+			i = j
+		default:
+			operator := ""
+			for _, op := range arithmeticOperators {
+				if strings.HasPrefix(expr[i:], op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("syntax error in expression (error token is %q)", expr[i:])
+			}
+			tokens = append(tokens, operator)
+			i += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+func isArithmeticWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// arithmeticPrecedence lists the binary operators from loosest to
+// tightest binding, as in bash.
+var arithmeticPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"**"},
+}
+
+type arithmeticParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *arithmeticParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseBinary parses operators at the given precedence level and above.
+func (p *arithmeticParser) parseBinary(level int) (int64, error) {
+	if level == len(arithmeticPrecedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if !containsOperator(arithmeticPrecedence[level], op) {
+			return left, nil
+		}
+		p.pos++
+
+		var right int64
+		if op == "**" {
+			// Exponentiation is right associative.
+			right, err = p.parseBinary(level)
+		} else {
+			right, err = p.parseBinary(level + 1)
+		}
+		if err != nil {
+			return 0, err
+		}
+		left, err = applyArithmetic(op, left, right)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *arithmeticParser) parseUnary() (int64, error) {
+	switch op := p.peek(); op {
+	case "+", "-", "!", "~":
+		p.pos++
+		value, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return -value, nil
+		case "!":
+			return boolToInt(value == 0), nil
+		case "~":
+			return ^value, nil
+		}
+		return value, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *arithmeticParser) parsePrimary() (int64, error) {
+	token := p.peek()
+	if token == "" {
+		return 0, fmt.Errorf("syntax error: operand expected")
+	}
+	p.pos++
+
+	if token == "(" {
+		value, err := p.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ")" {
+			return 0, fmt.Errorf("syntax error: missing )")
+		}
+		p.pos++
+		return value, nil
+	}
+
+	if token[0] >= '0' && token[0] <= '9' {
+		value, err := strconv.ParseInt(token, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid number", token)
+		}
+		return value, nil
+	}
+	if isArithmeticWordChar(token[0]) {
+		value := strings.TrimSpace(lookupVariable(token))
+		if value == "" {
+			return 0, nil
+		}
+		number, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q is not a number", token, value)
+		}
+		return number, nil
+	}
+	return 0, fmt.Errorf("syntax error: operand expected (error token is %q)", token)
+}
+
+func applyArithmetic(op string, left, right int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolToInt(left != 0 || right != 0), nil
+	case "&&":
+		return boolToInt(left != 0 && right != 0), nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&":
+		return left & right, nil
+	case "==":
+		return boolToInt(left == right), nil
+	case "!=":
+		return boolToInt(left != right), nil
+	case "<":
+		return boolToInt(left < right), nil
+	case "<=":
+		return boolToInt(left <= right), nil
+	case ">":
+		return boolToInt(left > right), nil
+	case ">=":
+		return boolToInt(left >= right), nil
+	case "<<":
+		return left << uint64(right), nil
+	case ">>":
+		return left >> uint64(right), nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by 0")
+		}
+		if op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	case "**":
+		if right < 0 {
+			return 0, fmt.Errorf("exponent less than 0")
+		}
+		// Square and multiply, so huge exponents take a few dozen steps;
+		// the result wraps like every other overflow.
+		result := int64(1)
+		for ; right > 0; right >>= 1 {
+			if right&1 == 1 {
+				result *= left
+			}
+			left *= left
+		}
+		return result, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+func containsOperator(operators []string, op string) bool {
+	for _, candidate := range operators {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package gosh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEvalArithmetic(t *testing.T) {
+	GetGlobalState().SetVar("arith_x", "5")
+	defer GetGlobalState().UnsetVar("arith_x")
+
+	tests := []struct {
+		expr     string
+		expected int64
+	}{
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"10 - 4 - 3", 3},
+		{"17 / 5", 3},
+		{"17 % 5", 2},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", 4},
+		{"3 ** 5", 243},
+		{"7 ** 0", 1},
+		{"2 ** 9999999999", 0},
+		{"-1 ** 9999999999", -1},
+		{"1 + 2 < 4", 1},
+		{"3 == 4", 0},
+		{"3 != 4", 1},
+		{"6 & 3 | 8", 10},
+		{"6 ^ 3", 5},
+		{"1 << 4 >> 2", 4},
+		{"!0 && ~0", 1},
+		{"0x10 + 010", 24},
+		{"arith_x + 1", 6},
+		{"$arith_x * arith_x", 25},
+		{"arith_unset + 1", 1},
+		{"((1 + 2) * (3 + 4))", 21},
+	}
+
+	for _, tt := range tests {
+		result, err := EvalArithmetic(tt.expr)
+		if err != nil {
+			t.Errorf("EvalArithmetic(%q) returned error: %v", tt.expr, err)
+		} else if result != tt.expected {
+			t.Errorf("EvalArithmetic(%q) = %d, want %d", tt.expr, result, tt.expected)
+		}
+	}
+}
+
+func TestEvalArithmeticErrors(t *testing.T) {
+	for _, expr := range []string{"1 / 0", "5 % 0", "2 ** -1", "1 +", "(1 + 2", "1 2", "3 @ 4"} {
+		if result, err := EvalArithmetic(expr); err == nil {
+			t.Errorf("EvalArithmetic(%q) = %d, want error", expr, result)
+		}
+	}
+}
+
+func TestArithmeticExpansion(t *testing.T) {
+	defer GetGlobalState().UnsetVar("arith_i")
+
+	if output := runEcho(t, "echo $((2 + 3 * 4))"); output != "14\n" {
+		t.Errorf("echo $((2 + 3 * 4)) = %q, want %q", output, "14\n")
+	}
+	if output := runEcho(t, "echo '$((1 + 1))' $((1 + 1))"); output != "$((1 + 1)) 2\n" {
+		t.Errorf("echo '$((1 + 1))' $((1 + 1)) = %q, want only the unquoted expansion evaluated", output)
+	}
+
+	runEcho(t, "arith_i=1")
+	runEcho(t, "arith_i=$((arith_i+1))")
+	runEcho(t, "arith_i=$(( arith_i << 2 ))")
+	if value := lookupVariable("arith_i"); value != "8" {
+		t.Errorf("arith_i = %q, want %q", value, "8")
+	}
+
+	cmd, err := NewCommand("echo $((1 / 0))", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if cmd.ReturnCode == 0 || stdout.Len() != 0 || !strings.Contains(stderr.String(), "division by 0") {
+		t.Errorf("echo $((1 / 0)) = (%d, %q, %q), want a division error", cmd.ReturnCode, stdout.String(), stderr.String())
+	}
+}
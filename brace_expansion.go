@@ -0,0 +1,148 @@
+package gosh
+
+import (
+	"strconv"
+	"strings"
+)
+
+// expandBraceWords applies brace expansion to every word of a command.
+// Brace expansion is a bash extension, so it is skipped in posix mode.
+func expandBraceWords(words []string) []string {
+	if GetGlobalState().GetOption("posix") {
+		return words
+	}
+	var result []string
+	for _, word := range words {
+		result = append(result, expandBraces(word)...)
+	}
+	return result
+}
+
+// expandBraces expands the first brace expression in word, either a comma
+// list like a{b,c}d or a sequence like {1..5} or {a..e}, and recursively
+// expands the results. Quoted words and words without a valid brace
+// expression are returned unchanged.
+func expandBraces(word string) []string {
+	if word == "" || word[0] == '\'' || word[0] == '"' {
+		return []string{word}
+	}
+
+	start, end := findBraces(word)
+	if start < 0 {
+		return []string{word}
+	}
+	prefix, body, suffix := word[:start], word[start+1:end], word[end+1:]
+
+	alternatives := splitBraceBody(body)
+	if len(alternatives) < 2 {
+		sequence, ok := braceSequence(body)
+		if !ok {
+			// Leave this brace pair alone but keep expanding the rest.
+			var result []string
+			for _, rest := range expandBraces(suffix) {
+				result = append(result, word[:end+1]+rest)
+			}
+			return result
+		}
+		alternatives = sequence
+	}
+
+	var result []string
+	for _, alternative := range alternatives {
+		result = append(result, expandBraces(prefix+alternative+suffix)...)
+	}
+	return result
+}
+
+// findBraces returns the positions of the first '{' and its matching '}'.
+// The braces of a ${...} parameter expansion are skipped.
+func findBraces(word string) (int, int) {
+	open := -1
+	depth := 0
+	for i := 0; i < len(word); i++ {
+		switch word[i] {
+		case '{':
+			if open < 0 && i > 0 && word[i-1] == '$' {
+				end := parameterEnd(word, i+1)
+				if end < 0 {
+					return -1, -1
+				}
+				i = end
+				continue
+			}
+			if open < 0 {
+				open = i
+			}
+			depth++
+		case '}':
+			if open < 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				return open, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// splitBraceBody splits a brace body on its top-level commas.
+func splitBraceBody(body string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, body[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, body[start:])
+}
+
+func braceSequence(body string) ([]string, bool) {
+	bounds := strings.Split(body, "..")
+	if len(bounds) != 2 {
+		return nil, false
+	}
+
+	if start, err := strconv.Atoi(bounds[0]); err == nil {
+		end, err := strconv.Atoi(bounds[1])
+		if err != nil {
+			return nil, false
+		}
+		var result []string
+		for _, n := range braceRange(start, end) {
+			result = append(result, strconv.Itoa(n))
+		}
+		return result, true
+	}
+
+	if len(bounds[0]) == 1 && len(bounds[1]) == 1 {
+		var result []string
+		for _, c := range braceRange(int(bounds[0][0]), int(bounds[1][0])) {
+			result = append(result, string(rune(c)))
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func braceRange(start, end int) []int {
+	step := 1
+	if end < start {
+		step = -1
+	}
+	var result []int
+	for n := start; n != end+step; n += step {
+		result = append(result, n)
+	}
+	return result
+}
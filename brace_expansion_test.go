@@ -0,0 +1,66 @@
+package gosh
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"plain", []string{"plain"}},
+		{"a{b,c}d", []string{"abd", "acd"}},
+		{"{a,b}{1,2}", []string{"a1", "a2", "b1", "b2"}},
+		{"x{a,{b,c}}", []string{"xa", "xb", "xc"}},
+		{"{1..3}", []string{"1", "2", "3"}},
+		{"{3..1}", []string{"3", "2", "1"}},
+		{"{a..c}", []string{"a", "b", "c"}},
+		{"{single}", []string{"{single}"}},
+		{"{}", []string{"{}"}},
+		{"{a,b", []string{"{a,b"}},
+		{"'{a,b}'", []string{"'{a,b}'"}},
+	}
+
+	for _, tt := range tests {
+		if result := expandBraces(tt.input); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("expandBraces(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func runEcho(t *testing.T, input string) string {
+	t.Helper()
+	cmd, err := NewCommand(input, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command %q: %v", input, err)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Run()
+	return stdout.String()
+}
+
+func TestBraceExpansionStaysLiteralInPosixMode(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.SetOption("posix", false)
+
+	if output := runEcho(t, "echo pre{a,b}"); output != "prea preb\n" {
+		t.Errorf("echo pre{a,b} = %q, want %q", output, "prea preb\n")
+	}
+
+	runEcho(t, "set -o posix")
+	if !gs.GetOption("posix") {
+		t.Fatalf("set -o posix did not enable posix mode")
+	}
+	if output := runEcho(t, "echo pre{a,b}"); output != "pre{a,b}\n" {
+		t.Errorf("echo pre{a,b} in posix mode = %q, want %q", output, "pre{a,b}\n")
+	}
+
+	runEcho(t, "set +o posix")
+	if gs.GetOption("posix") {
+		t.Errorf("set +o posix did not disable posix mode")
+	}
+}
@@ -2,14 +2,22 @@ package gosh
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"gosh/parser"
 )
 
 var builtins map[string]func(cmd *Command) error
+var builtinsMu sync.RWMutex
+
+// disabledBuiltins holds the builtins turned off with enable -n, which run
+// from PATH instead. It is guarded by builtinsMu.
+var disabledBuiltins = make(map[string]bool)
 
 func init() {
 	builtins = make(map[string]func(cmd *Command) error)
@@ -28,6 +36,33 @@ func init() {
 	builtins["bg"] = bg
 	builtins["prompt"] = prompt
 	builtins["gosh-lisp"] = goshLisp
+	builtins["source"] = source
+	builtins["."] = source
+	builtins["test"] = testCommand
+	builtins["["] = testCommand
+	builtins["complete"] = complete
+	builtins["read"] = readCommand
+	builtins["set"] = setCommand
+	builtins["timeout"] = timeoutCommand
+	builtins["true"] = trueCommand
+	builtins["false"] = falseCommand
+	builtins["set-chpwd-hook"] = setChpwdHook
+	builtins["printf"] = printfCommand
+	builtins["m28"] = runM28
+	builtins["type"] = typeCommand
+	builtins["which"] = which
+	builtins["bindkey"] = bindkey
+	builtins["clear"] = clearCommand
+	builtins["enable"] = enable
+	builtins["declare"] = declare
+	builtins["typeset"] = declare
+	builtins["getopts"] = getopts
+	builtins["trap"] = trap
+	builtins["eval"] = eval
+	builtins["exec"] = execCommand
+	builtins["wait"] = wait
+	builtins["disown"] = disown
+	builtins["guard"] = guard
 }
 
 func cd(cmd *Command) error {
@@ -43,8 +78,13 @@ func cd(cmd *Command) error {
 
 	currentDir := gs.GetCWD()
 
+	// Every error is returned before anything is changed, so a failed cd
+	// leaves the directory, OLDPWD and PWD as they were.
 	if targetDir == "" {
 		targetDir = os.Getenv("HOME") // Default to HOME if no argument given
+		if targetDir == "" {
+			return fmt.Errorf("cd: HOME not set")
+		}
 	} else if targetDir == "-" {
 		targetDir = gs.GetPreviousDir()
 		if targetDir == "" {
@@ -52,12 +92,10 @@ func cd(cmd *Command) error {
 		}
 	}
 
-	err := os.Chdir(targetDir)
-	if err != nil {
-		return fmt.Errorf("cd: %v", err)
-	}
-
-	newDir, err := os.Getwd()
+	// Relative paths are taken from the shell's directory, which is not
+	// necessarily the process's.
+	newDir := resolvePath(currentDir, targetDir)
+	err := os.Chdir(newDir)
 	if err != nil {
 		return fmt.Errorf("cd: %v", err)
 	}
@@ -69,6 +107,7 @@ func cd(cmd *Command) error {
 	// Update the global state
 	gs.UpdateCWD(newDir)
 
+	runChpwdHooks(cmd)
 	return nil
 }
 
@@ -78,35 +117,124 @@ func pwd(cmd *Command) error {
 	return err
 }
 
+// trueCommand and falseCommand ignore their arguments, as in bash.
+func trueCommand(cmd *Command) error {
+	cmd.ReturnCode = 0
+	return nil
+}
+
+func falseCommand(cmd *Command) error {
+	cmd.ReturnCode = 1
+	return nil
+}
+
+// clearScreenSequence moves the cursor home and clears the terminal.
+const clearScreenSequence = "\033[H\033[2J"
+
+// clearCommand clears the screen. Output redirected to a file or pipe is
+// left alone, so clear in a redirected script does nothing.
+func clearCommand(cmd *Command) error {
+	cmd.ReturnCode = 0
+	if f, ok := cmd.Stdout.(*os.File); ok && !isTerminal(f) {
+		return nil
+	}
+	_, err := fmt.Fprint(cmd.Stdout, clearScreenSequence)
+	return err
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// echo writes its arguments separated by spaces. Leading -n, -e and -E
+// words, which may be combined as in -ne, drop the trailing newline and
+// turn backslash escapes on or off; escapes are off by default.
 func echo(cmd *Command) error {
 	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
 		return nil
 	}
-	_, args, _, _, _, _ := parser.ProcessCommand(cmd.AndCommands[0].Pipelines[0].Commands[0])
+	_, args, _ := parser.ProcessCommand2(cmd.AndCommands[0].Pipelines[0].Commands[0])
 
-	// Remove quotes and expand environment variables
+	// The words are already expanded; only their quotes remain.
 	for i, arg := range args {
-		arg = strings.Trim(arg, "'\"")
-		if strings.HasPrefix(arg, "$") {
-			varName := strings.TrimPrefix(arg, "$")
-			args[i] = os.Getenv(varName)
-		} else {
-			args[i] = arg
+		args[i] = removeQuotes(arg)
+	}
+
+	newline, escapes := true, false
+	for len(args) > 0 && isEchoFlag(args[0]) {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
 		}
+		args = args[1:]
 	}
 
-	output := strings.Join(args, " ") + "\n"
+	output := strings.Join(args, " ")
+	if escapes {
+		// \c also suppresses the trailing newline.
+		var stop bool
+		if output, stop = expandEscapes(output); stop {
+			newline = false
+		}
+	}
+	if newline {
+		output += "\n"
+	}
 	_, err := fmt.Fprint(cmd.Stdout, output)
 	return err
 }
 
-func help(cmd *Command) error {
-	_, err := fmt.Fprintln(cmd.Stdout, "Built-in commands:")
+// isEchoFlag reports whether arg is an echo option word. Anything else,
+// including "-" and "-x", is printed.
+func isEchoFlag(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-' && strings.Trim(arg[1:], "neE") == ""
+}
+
+// historyStatsCount is how many entries each `history --stats` table shows.
+const historyStatsCount = 10
+
+func history(cmd *Command) error {
+	store, err := openHistoryStore()
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to open history database: %v", err)
 	}
-	for name := range builtins {
-		_, err = fmt.Fprintf(cmd.Stdout, "  %s\n", name)
+	defer store.Close()
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		args = cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	}
+
+	var records []string
+	switch {
+	case len(args) > 0 && args[0] == "--stats":
+		return writeHistoryStats(cmd.Stdout, store, historyStatsCount)
+	case len(args) > 0 && args[0] == "--cwd":
+		if len(args) > 2 {
+			return fmt.Errorf("Usage: history --cwd [dir]")
+		}
+		// Commands are recorded with the shell's directory at the time.
+		dir := GetGlobalState().GetCWD()
+		if len(args) == 2 {
+			dir = resolvePath(dir, args[1])
+		}
+		records, err = store.HistoryByCWD(dir, 0)
+	case len(args) > 0 && args[0] == "--session":
+		records, err = store.HistoryBySession(GetGlobalState().GetSessionID(), 0)
+	default:
+		records, err = store.Dump()
+	}
+	if err != nil {
+		return fmt.Errorf("Error retrieving history: %v", err)
+	}
+	for _, record := range records {
+		_, err = fmt.Fprintln(cmd.Stdout, record)
 		if err != nil {
 			return err
 		}
@@ -114,18 +242,24 @@ func help(cmd *Command) error {
 	return nil
 }
 
-func history(cmd *Command) error {
-	historyManager, err := NewHistoryManager("")
+// writeHistoryStats prints the n most used commands and command lines.
+func writeHistoryStats(w io.Writer, store HistoryStore, n int) error {
+	commands, err := TopCommands(store, n)
 	if err != nil {
-		return fmt.Errorf("Failed to open history database: %v", err)
+		return fmt.Errorf("Error retrieving history: %v", err)
 	}
-	records, err := historyManager.Dump()
+	lines, err := store.TopCommandLines(n)
 	if err != nil {
 		return fmt.Errorf("Error retrieving history: %v", err)
 	}
-	for _, record := range records {
-		_, err = fmt.Fprintln(cmd.Stdout, record)
-		if err != nil {
+
+	fmt.Fprintln(w, "Most used commands:")
+	for _, entry := range commands {
+		fmt.Fprintf(w, "%6d  %s\n", entry.Count, entry.Name)
+	}
+	fmt.Fprintln(w, "Most used command lines:")
+	for _, entry := range lines {
+		if _, err := fmt.Fprintf(w, "%6d  %s\n", entry.Count, entry.Name); err != nil {
 			return err
 		}
 	}
@@ -153,8 +287,12 @@ func export(cmd *Command) error {
 		return fmt.Errorf("Invalid export syntax. Usage: export NAME=VALUE")
 	}
 
-	name, value := parts[0], parts[1]
-	err := os.Setenv(name, value)
+	name, value := parts[0], removeQuotes(parts[1])
+	value, err := attributeValue(name, value)
+	if err != nil {
+		return fmt.Errorf("export: %v", err)
+	}
+	err = os.Setenv(name, value)
 	if err != nil {
 		return fmt.Errorf("export: %v", err)
 	}
@@ -205,7 +343,7 @@ func unalias(cmd *Command) error {
 func jobs(cmd *Command) error {
 	jobList := cmd.JobManager.ListJobs()
 	for _, job := range jobList {
-		_, err := fmt.Fprintf(cmd.Stdout, "[%d] %s %s\n", job.ID, job.Status, job.Command)
+		_, err := fmt.Fprintf(cmd.Stdout, "[%d] %s %s\n", job.ID, cmd.JobManager.JobStatus(job), job.Command)
 		if err != nil {
 			return err
 		}
@@ -217,9 +355,9 @@ func fg(cmd *Command) error {
 	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts) < 2 {
 		return fmt.Errorf("Usage: fg <job_id>")
 	}
-	jobID, err := strconv.Atoi(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1])
+	jobID, err := cmd.JobManager.ResolveJobSpec(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1])
 	if err != nil {
-		return fmt.Errorf("Invalid job ID")
+		return err
 	}
 	return cmd.JobManager.ForegroundJob(jobID)
 }
@@ -228,15 +366,107 @@ func bg(cmd *Command) error {
 	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts) < 2 {
 		return fmt.Errorf("Usage: bg <job_id>")
 	}
-	jobID, err := strconv.Atoi(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1])
+	jobID, err := cmd.JobManager.ResolveJobSpec(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1])
 	if err != nil {
-		return fmt.Errorf("Invalid job ID")
+		return err
 	}
 	return cmd.JobManager.BackgroundJob(jobID)
 }
 
+// completionFlags maps the completion types to the complete options that
+// register them.
+var completionFlags = map[CompletionType]string{
+	CompleteDirectories: "-d",
+	CompleteFiles:       "-f",
+}
+
+func complete(cmd *Command) error {
+	const usage = "Usage: complete -d|-f <command>... | complete -p|-r [command]..."
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts) < 2 {
+		return fmt.Errorf(usage)
+	}
+
+	parts := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts
+	var completionType CompletionType
+	switch parts[1] {
+	case "-p":
+		return printCompletions(cmd, parts[2:])
+	case "-r":
+		return removeCompletions(cmd, parts[2:])
+	case "-d":
+		completionType = CompleteDirectories
+	case "-f":
+		completionType = CompleteFiles
+	default:
+		return fmt.Errorf("invalid option: %s", parts[1])
+	}
+	if len(parts) < 3 {
+		return fmt.Errorf(usage)
+	}
+
+	for _, name := range parts[2:] {
+		SetCompletionType(name, completionType)
+	}
+	return nil
+}
+
+// printCompletions writes the registrations of names, or of every command
+// when there are none, as complete commands that recreate them.
+func printCompletions(cmd *Command, names []string) error {
+	types := CompletionTypes()
+	if len(names) == 0 {
+		for name, completionType := range types {
+			if completionFlags[completionType] != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	var missing []string
+	for _, name := range names {
+		flag := completionFlags[types[name]]
+		if flag == "" {
+			missing = append(missing, name)
+			continue
+		}
+		if _, err := fmt.Fprintf(cmd.Stdout, "complete %s %s\n", flag, name); err != nil {
+			return err
+		}
+	}
+	return missingCompletions(cmd, missing)
+}
+
+// removeCompletions forgets the registrations of names, or of every
+// command when there are none.
+func removeCompletions(cmd *Command, names []string) error {
+	if len(names) == 0 {
+		ClearCompletionTypes()
+		return nil
+	}
+	var missing []string
+	for _, name := range names {
+		if !RemoveCompletionType(name) {
+			missing = append(missing, name)
+		}
+	}
+	return missingCompletions(cmd, missing)
+}
+
+// missingCompletions reports names that have no registration and fails
+// the command if there are any.
+func missingCompletions(cmd *Command, names []string) error {
+	for _, name := range names {
+		cmd.printError("complete: %s: no completion specification\n", name)
+		cmd.ReturnCode = 1
+	}
+	return nil
+}
+
 // Builtins returns a copy of the builtins map
 func Builtins() map[string]func(cmd *Command) error {
+	builtinsMu.RLock()
+	defer builtinsMu.RUnlock()
 	copy := make(map[string]func(cmd *Command) error)
 	for k, v := range builtins {
 		copy[k] = v
@@ -244,17 +474,96 @@ func Builtins() map[string]func(cmd *Command) error {
 	return copy
 }
 
+// RegisterBuiltin adds a builtin command, replacing any existing builtin
+// with the same name. It lets programs embedding gosh provide their own
+// commands.
+func RegisterBuiltin(name string, fn func(cmd *Command) error) {
+	builtinsMu.Lock()
+	defer builtinsMu.Unlock()
+	builtins[name] = fn
+}
+
+// UnregisterBuiltin removes a builtin command.
+func UnregisterBuiltin(name string) {
+	builtinsMu.Lock()
+	defer builtinsMu.Unlock()
+	delete(builtins, name)
+}
+
+// lookupBuiltin returns the builtin that runs name, unless it has been
+// disabled or GOSH_PREFER_EXTERNAL asks for the executable in PATH.
+func lookupBuiltin(name string) (func(cmd *Command) error, bool) {
+	builtinsMu.RLock()
+	fn, ok := builtins[name]
+	disabled := disabledBuiltins[name]
+	builtinsMu.RUnlock()
+	if !ok || disabled || prefersExternal(name) {
+		return nil, false
+	}
+	return fn, true
+}
+
+// prefersExternal reports whether name is listed in GOSH_PREFER_EXTERNAL
+// and an executable of that name is in PATH to run instead.
+func prefersExternal(name string) bool {
+	for _, preferred := range strings.Fields(lookupVariable("GOSH_PREFER_EXTERNAL")) {
+		if preferred == name {
+			_, found := findExecutable(name)
+			return found
+		}
+	}
+	return false
+}
+
+// setBuiltinEnabled turns the builtin name on or off, reporting whether
+// there is such a builtin.
+func setBuiltinEnabled(name string, enabled bool) bool {
+	builtinsMu.Lock()
+	defer builtinsMu.Unlock()
+	if _, ok := builtins[name]; !ok {
+		return false
+	}
+	if enabled {
+		delete(disabledBuiltins, name)
+	} else {
+		disabledBuiltins[name] = true
+	}
+	return true
+}
+
+// exitProcess is replaced in tests so exit can be run without ending them.
+var exitProcess = os.Exit
+
+// exitShell runs the EXIT trap and then ends the shell. Inside a subshell
+// it only ends the subshell, which runSubshell recovers from.
 func exitShell(cmd *Command) error {
-	os.Exit(0)
+	status := exitStatus(cmd)
+	if cmd.subshell {
+		panic(subshellExit{status})
+	}
+	runTrap("EXIT", cmd.JobManager, cmd.Stdin, cmd.Stdout, cmd.Stderr)
+	exitProcess(status)
 	return nil
 }
 
+// exitStatus returns the status exit should terminate with: its argument,
+// or the status of the last command when there is none.
+func exitStatus(cmd *Command) int {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts) < 2 {
+		return GetGlobalState().GetLastExitStatus()
+	}
+
+	status, err := strconv.Atoi(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1])
+	if err != nil {
+		fmt.Fprintln(cmd.Stderr, "exit: numeric argument required")
+		return 2
+	}
+	return status
+}
+
 func prompt(cmd *Command) error {
 	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
-		currentPrompt := os.Getenv("GOSH_PROMPT")
-		if currentPrompt == "" {
-			currentPrompt = defaultPrompt
-		}
+		currentPrompt := promptTemplate()
 		fmt.Fprintf(cmd.Stdout, "Current prompt: %s\n", currentPrompt)
 		fmt.Fprintf(cmd.Stdout, "Usage: prompt <new_prompt>\n")
 		fmt.Fprintf(cmd.Stdout, "Available variables: %%u (username), %%h (hostname), %%w (working directory), %%W (shortened working directory), %%d (date), %%t (time), %%$ ($ symbol)\n")
@@ -266,7 +575,7 @@ func prompt(cmd *Command) error {
 	if err != nil {
 		return fmt.Errorf("Failed to set new prompt: %v", err)
 	}
-	fmt.Fprintf(cmd.Stdout, "Prompt updated successfully. New prompt: %s\n", expandPromptVariables(newPrompt))
+	fmt.Fprintf(cmd.Stdout, "Prompt updated successfully. New prompt: %s\n", expandPromptVariables(newPrompt, 0))
 	return nil
 }
 
@@ -0,0 +1,186 @@
+package gosh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterBuiltin(t *testing.T) {
+	RegisterBuiltin("greet", func(cmd *Command) error {
+		args := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+		_, err := fmt.Fprintf(cmd.Stdout, "hello %s\n", strings.Join(args, " "))
+		return err
+	})
+	defer UnregisterBuiltin("greet")
+
+	if _, ok := Builtins()["greet"]; !ok {
+		t.Fatalf("Builtins() does not include a registered builtin")
+	}
+	if output := runEcho(t, "greet gosh"); output != "hello gosh\n" {
+		t.Errorf("greet gosh = %q, want %q", output, "hello gosh\n")
+	}
+
+	UnregisterBuiltin("greet")
+	if _, ok := Builtins()["greet"]; ok {
+		t.Errorf("Builtins() still includes an unregistered builtin")
+	}
+}
+
+func TestTrueAndFalseIgnoreArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+		output   string
+	}{
+		{"true", 0, ""},
+		{"true ignored args", 0, ""},
+		{"false", 1, ""},
+		{"false --help ignored", 1, ""},
+		{"true x && echo yes", 0, "yes\n"},
+		{"false x && echo no", 1, ""},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		if cmd.ReturnCode != tt.expected {
+			t.Errorf("%q ReturnCode = %d, want %d", tt.input, cmd.ReturnCode, tt.expected)
+		}
+		if stdout.String() != tt.output || stderr.Len() != 0 {
+			t.Errorf("%q output = (%q, %q), want (%q, \"\")", tt.input, stdout.String(), stderr.String(), tt.output)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	var exited []int
+	exitProcess = func(code int) { exited = append(exited, code) }
+	defer func() { exitProcess = os.Exit }()
+	defer GetGlobalState().SetPipeStatus(nil)
+
+	tests := []struct {
+		input      string
+		lastStatus int
+		expected   int
+		stderr     string
+	}{
+		{"exit 3", 0, 3, ""},
+		{"exit 0", 1, 0, ""},
+		{"exit", 0, 0, ""},
+		{"exit", 7, 7, ""},
+		{"exit abc", 0, 2, "exit: numeric argument required\n"},
+	}
+
+	for _, tt := range tests {
+		GetGlobalState().SetPipeStatus([]int{tt.lastStatus})
+		exited = nil
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		if len(exited) != 1 || exited[0] != tt.expected {
+			t.Errorf("%q with last status %d exited with %v, want %d", tt.input, tt.lastStatus, exited, tt.expected)
+		}
+		if stderr.String() != tt.stderr {
+			t.Errorf("%q stderr = %q, want %q", tt.input, stderr.String(), tt.stderr)
+		}
+	}
+}
+
+func TestCdErrorsLeaveStateUnchanged(t *testing.T) {
+	dir := useTempCWD(t)
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("Failed to create %s: %v", file, err)
+	}
+	gs := GetGlobalState()
+	t.Setenv("OLDPWD", "/old")
+	t.Setenv("PWD", dir)
+
+	snapshot := func() []string {
+		wd, _ := os.Getwd()
+		return []string{gs.GetCWD(), gs.GetPreviousDir(), os.Getenv("OLDPWD"), os.Getenv("PWD"), wd}
+	}
+
+	tests := []struct {
+		name, input, home, previous, err string
+	}{
+		{"HOME unset", "cd", "", "/prev", "HOME not set"},
+		{"missing dir", "cd gosh-no-such-dir", "/", "/prev", "no such file or directory"},
+		{"not a dir", "cd file", "/", "/prev", "not a directory"},
+		{"OLDPWD unset", "cd -", "/", "", "OLDPWD not set"},
+	}
+	for _, tt := range tests {
+		t.Setenv("HOME", tt.home)
+		gs.mu.Lock()
+		gs.PreviousDir = tt.previous
+		gs.mu.Unlock()
+		before := snapshot()
+
+		_, stderr, code := runWithOptions(t, tt.input)
+		if code == 0 || !strings.Contains(stderr, tt.err) {
+			t.Errorf("%s: %q = (%d, %q), want an error containing %q", tt.name, tt.input, code, stderr, tt.err)
+		}
+		if after := snapshot(); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: %q changed (CWD, PreviousDir, OLDPWD, PWD, process dir) from %q to %q", tt.name, tt.input, before, after)
+		}
+	}
+}
+
+func TestEchoFlags(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo -n hi", "hi"},
+		{"echo -e 'a\\tb'", "a\tb\n"},
+		{"echo 'a\\tb'", "a\\tb\n"},
+		{"echo -E 'a\\tb'", "a\\tb\n"},
+		{"echo -e -E 'a\\nb'", "a\\nb\n"},
+		{"echo -n -e 'a\\nb'", "a\nb"},
+		{"echo -ne 'x\\0101'", "xA"},
+		{"echo -e 'a\\cb' c", "a"},
+		{"echo -e 'a\\\\cb'", "a\\cb\n"},
+		{"echo -e '\\x41\\x4a2\\x7'", "AJ2\a\n"},
+		{"echo -e '\\xg'", "\\xg\n"},
+		{"echo -x -n", "-x -n\n"},
+		{"echo - hi", "- hi\n"},
+		{"echo hi -n", "hi -n\n"},
+		{"echo -n", ""},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+}
+
+func TestClearBuiltin(t *testing.T) {
+	stdout, _, code := runWithOptions(t, "clear")
+	if stdout != "\033[H\033[2J" || code != 0 {
+		t.Errorf("clear = (%q, %d), want (%q, 0)", stdout, code, "\033[H\033[2J")
+	}
+
+	dir := useTempCWD(t)
+	if _, _, code := runWithOptions(t, "clear > cleared"); code != 0 {
+		t.Errorf("clear > cleared returned %d, want 0", code)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "cleared")); err != nil || len(data) != 0 {
+		t.Errorf("clear > cleared wrote (%q, %v), want an empty file", data, err)
+	}
+}
@@ -0,0 +1,60 @@
+package gosh
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// inChpwdHook stops a hook that changes directory from triggering the
+// hooks again.
+var inChpwdHook atomic.Bool
+
+// runChpwdHooks runs every registered chpwd hook with the stdio of the
+// command that changed directory.
+func runChpwdHooks(cmd *Command) {
+	if !inChpwdHook.CompareAndSwap(false, true) {
+		return
+	}
+	defer inChpwdHook.Store(false)
+
+	for _, hook := range GetGlobalState().GetChpwdHooks() {
+		hookCmd, err := NewCommand(hook, cmd.JobManager)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "chpwd hook '%s': %v\n", hook, err)
+			continue
+		}
+		hookCmd.Stdin = cmd.Stdin
+		hookCmd.Stdout = cmd.Stdout
+		hookCmd.Stderr = cmd.Stderr
+		hookCmd.Raw = cmd.Raw
+		hookCmd.Run()
+	}
+}
+
+// setChpwdHook implements `set-chpwd-hook COMMAND` to add a hook,
+// `set-chpwd-hook -c` to remove all hooks and `set-chpwd-hook` to list them.
+func setChpwdHook(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		args = cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	}
+	gs := GetGlobalState()
+
+	switch {
+	case len(args) == 0:
+		for _, hook := range gs.GetChpwdHooks() {
+			if _, err := fmt.Fprintln(cmd.Stdout, hook); err != nil {
+				return err
+			}
+		}
+	case len(args) == 1 && args[0] == "-c":
+		gs.ClearChpwdHooks()
+	default:
+		for i, arg := range args {
+			args[i] = strings.Trim(arg, "'\"")
+		}
+		gs.AddChpwdHook(strings.Join(args, " "))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package gosh
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestChpwdHookRunsOnCd(t *testing.T) {
+	useTempCWD(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	chdirForTest(t, dir)
+	defer GetGlobalState().ClearChpwdHooks()
+
+	runEcho(t, "set-chpwd-hook 'echo changed'")
+	runEcho(t, "set-chpwd-hook pwd")
+	if output := runEcho(t, "set-chpwd-hook"); output != "echo changed\npwd\n" {
+		t.Errorf("set-chpwd-hook = %q, want the registered hooks", output)
+	}
+
+	if output := runEcho(t, "cd "+filepath.Dir(dir)); output != "changed\n"+filepath.Dir(dir)+"\n" {
+		t.Errorf("cd output = %q, want the hooks to run in the new directory", output)
+	}
+
+	runEcho(t, "set-chpwd-hook -c")
+	if output := runEcho(t, "cd "+dir); output != "" {
+		t.Errorf("cd after clearing hooks = %q, want no output", output)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
@@ -16,72 +19,187 @@ import (
 )
 
 func main() {
+	posix := flag.Bool("posix", false, "disable bash extensions for portability testing")
+	initFile := flag.String("init-file", "", "source `PATH` at startup instead of ~/.goshrc")
+	norc := flag.Bool("norc", false, "do not read $GOSHRC or ~/.goshrc in an interactive shell")
+	commandTimeout := flag.String("command-timeout", "", "stop every external command after `DURATION`, as GOSH_COMMAND_TIMEOUT does")
+	profile := flag.Bool("profile", false, "print how long startup and each command take to stderr")
+	profileFile := flag.String("profile-file", "", "with --profile, append the timings to `PATH` instead")
+	flag.Parse()
+
 	log.SetFlags(0)
 	log.SetPrefix("")
 
+	startup := time.Now()
+	if *profile {
+		var profileOutput io.Writer = os.Stderr
+		if *profileFile != "" {
+			file, err := os.OpenFile(*profileFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+			if err != nil {
+				log.Fatalf("Failed to open profile file: %v", err)
+			}
+			defer file.Close()
+			profileOutput = file
+		}
+		gosh.SetProfileOutput(profileOutput)
+	}
+
 	log.Printf("Session started at %s by user %d (%s)", time.Now(), os.Geteuid(), os.Getenv("USER"))
 
-	fmt.Println("Welcome to gosh Shell")
+	fmt.Fprintln(gosh.ShellOutput, "Welcome to gosh Shell")
 
+	if *commandTimeout != "" {
+		os.Setenv("GOSH_COMMAND_TIMEOUT", *commandTimeout)
+	}
+	if *posix {
+		gosh.GetGlobalState().SetOption("posix", true)
+	}
+	if err := gosh.ImportAliases(os.Getenv("GOSH_ALIASES")); err != nil {
+		log.Printf("Failed to import aliases: %v", err)
+	}
+
+	interactive := readline.IsTerminal(int(os.Stdin.Fd()))
 	jobManager := gosh.NewJobManager()
+	start := time.Now()
 	completer := gosh.NewCompleter(gosh.Builtins())
+	completer.SetJobManager(jobManager)
+	gosh.ProfilePhase("startup.completer", start)
 
-	rl, err := readline.NewEx(&readline.Config{
-		Prompt:            gosh.GetPrompt(),
+	start = time.Now()
+	if *initFile != "" {
+		if err := gosh.RunInitFile(*initFile, jobManager); err != nil {
+			log.Printf("Failed to run init file: %v", err)
+		}
+	} else if interactive && !*norc {
+		if rcFile, err := gosh.RCFile(); err == nil {
+			err := gosh.RunInitFile(rcFile, jobManager)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Failed to run init file: %v", err)
+			}
+		}
+	}
+	gosh.ProfilePhase("startup.init_file", start)
+
+	// history stays nil when the database cannot be opened, and the shell
+	// runs without recording commands.
+	var history gosh.HistoryStore
+	start = time.Now()
+	historyManager, err := gosh.NewHistoryManager("")
+	gosh.ProfilePhase("startup.history_db", start)
+	if err != nil {
+		log.Printf("Failed to create history manager: %v", err)
+	} else {
+		history = historyManager
+		defer history.Close()
+		if sessionID, err := history.NewSessionID(); err != nil {
+			log.Printf("Failed to start a history session: %v", err)
+		} else {
+			gosh.GetGlobalState().SetSessionID(sessionID)
+		}
+		if counts, err := gosh.CommandUseCounts(history); err != nil {
+			log.Printf("Failed to load command usage counts: %v", err)
+		} else {
+			completer.SetCommandFrequencies(counts)
+		}
+	}
+
+	config := &readline.Config{
+		Prompt:            gosh.RenderPrompt(jobManager),
 		HistoryFile:       "/tmp/gosh_readline_history",
 		InterruptPrompt:   "^C",
 		EOFPrompt:         "exit",
 		AutoComplete:      completer,
+		Stdout:            gosh.ShellOutput,
 		HistorySearchFold: true,
-	})
+		// Keys bound with bindkey are handled by the listener.
+		FuncFilterInputRune: gosh.KeyBindingFilter,
+	}
+	config.SetListener(gosh.KeyBindingListener(func() string {
+		if history == nil {
+			return ""
+		}
+		previous, _ := history.LastCommand()
+		return previous
+	}))
+	rl, err := readline.NewEx(config)
 	if err != nil {
 		panic(err)
 	}
 	defer rl.Close()
-
-	historyManager, err := gosh.NewHistoryManager("")
-	if err != nil {
-		log.Printf("Failed to create history manager: %v", err)
-	}
+	gosh.ProfilePhase("startup", startup)
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTSTP, syscall.SIGINT, syscall.SIGCHLD)
+	signal.Notify(sigChan, syscall.SIGTSTP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGCHLD,
+		syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
 
 	go func() {
 		for sig := range sigChan {
 			switch sig {
 			case syscall.SIGTSTP:
-				fmt.Println("\nReceived SIGTSTP")
+				fmt.Fprintln(gosh.ShellOutput, "\nReceived SIGTSTP")
 				jobManager.StopForegroundJob()
 			case syscall.SIGINT:
-				fmt.Println("\nReceived SIGINT")
+				fmt.Fprintln(gosh.ShellOutput, "\nReceived SIGINT")
 				jobManager.StopForegroundJob()
+				gosh.RunSignalTrap(sig, jobManager)
+			case syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2:
+				// Without a handler these end the shell, as they did
+				// before they were caught, but the EXIT trap still runs.
+				if !gosh.RunSignalTrap(sig, jobManager) {
+					gosh.RunExitTrap(jobManager)
+					os.Exit(128 + int(sig.(syscall.Signal)))
+				}
+			case syscall.SIGQUIT:
+				// Like an interactive bash, the shell ignores SIGQUIT
+				// unless it is trapped.
+				gosh.RunSignalTrap(sig, jobManager)
 			case syscall.SIGCHLD:
 				jobManager.ReapChildren()
 			}
 		}
 	}()
 
-	fmt.Println("Tab completion is being initialized in the background. It will be fully functional shortly.")
+	fmt.Fprintln(gosh.ShellOutput, "Tab completion is being initialized in the background. It will be fully functional shortly.")
 
 	for {
-		rl.SetPrompt(gosh.GetPrompt()) // Update the prompt before each readline
+		jobManager.ReapChildren()                   // Report background jobs that finished
+		rl.SetPrompt(gosh.RenderPrompt(jobManager)) // Update the prompt before each readline
 		line, err := rl.Readline()
 		if err != nil {
 			if err == readline.ErrInterrupt {
+				// Ctrl-C at the prompt reaches readline, not the
+				// signal handler.
+				gosh.RunSignalTrap(syscall.SIGINT, jobManager)
 				continue
 			} else if err == io.EOF {
 				break
 			}
-			fmt.Println("Error reading input:", err)
+			fmt.Fprintln(gosh.ShellOutput, "Error reading input:", err)
 			continue
 		}
 
+		// A trailing backslash continues the command on the next line,
+		// read under the continuation prompt; Ctrl-C drops the command.
+		for gosh.ContinuesLine(line) {
+			rl.SetPrompt(gosh.GetContinuationPrompt())
+			next, err := rl.Readline()
+			if err == readline.ErrInterrupt {
+				line = ""
+				break
+			} else if err != nil {
+				break
+			}
+			line = line[:len(line)-1] + next
+		}
+		if gosh.ContinuesLine(line) {
+			line = line[:len(line)-1]
+		}
+
 		line = strings.TrimSpace(line)
 
-		if line == "exit" || line == "quit" {
-			fmt.Println("Exiting gosh Shell...")
+		if line == "quit" {
+			fmt.Fprintln(gosh.ShellOutput, "Exiting gosh Shell...")
 			break
 		}
 
@@ -89,24 +207,67 @@ func main() {
 			continue
 		}
 
-		command, err := gosh.NewCommand(line, jobManager)
-		if err != nil {
-			log.Printf("Error creating command: %v", err)
-			continue
-		}
+		// A panic while handling the line is reported and the shell goes
+		// back to the prompt.
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					gosh.ReportPanic(os.Stderr, r)
+				}
+			}()
 
-		command.Stdin = os.Stdin
-		command.Stdout = os.Stdout
-		command.Stderr = os.Stderr
-		command.Run()
+			if history != nil && strings.Contains(line, "!") {
+				previous, err := history.LastCommand()
+				if err != nil {
+					log.Printf("Failed to read previous command: %v", err)
+				}
+				expanded, err := gosh.ExpandHistoryDesignators(line, previous)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "gosh: %v\n", err)
+					return
+				}
+				if expanded != line {
+					fmt.Fprintln(gosh.ShellOutput, expanded)
+					line = expanded
+				}
+			}
 
-		if historyManager != nil {
-			err = historyManager.Insert(command, 0) // Replace 0 with actual session ID
+			command, err := gosh.NewCommand(line, jobManager)
 			if err != nil {
-				log.Printf("Failed to insert command into history: %v", err)
+				log.Printf("Error creating command: %v", err)
+				return
 			}
-		}
 
-		rl.SaveHistory(line)
+			command.Stdin = os.Stdin
+			command.Stdout = os.Stdout
+			command.Stderr = os.Stderr
+			command.Run()
+			completer.RecordCommandUsage(line)
+			if interactive {
+				if report := gosh.CommandDurationReport(command.Duration); report != "" {
+					fmt.Fprintln(os.Stderr, report)
+				}
+			}
+
+			if history != nil {
+				id, err := history.Insert(command, gosh.GetGlobalState().GetSessionID())
+				if err != nil {
+					log.Printf("Failed to insert command into history: %v", err)
+				} else {
+					// Background jobs update their entry once they finish.
+					for _, job := range command.BackgroundJobs {
+						jobManager.WhenDone(job, func(job *gosh.Job) {
+							if err := history.UpdateResult(id, job.ExitCode, job.EndTime); err != nil {
+								log.Printf("Failed to record job result in history: %v", err)
+							}
+						})
+					}
+				}
+			}
+
+			rl.SaveHistory(line)
+		}()
 	}
+
+	gosh.RunExitTrap(jobManager)
 }
@@ -2,12 +2,15 @@ package gosh
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"gosh/parser"
@@ -25,6 +28,25 @@ type Command struct {
 	EUID       int
 	ReturnCode int
 	JobManager *JobManager
+	// BackgroundJobs are the jobs this command started with &.
+	BackgroundJobs []*Job
+	// Raw runs the command exactly as written, without expanding aliases.
+	// Subshells, command substitutions and builtins run from a raw
+	// command are raw too.
+	Raw bool
+
+	// conditions counts the if and while conditions being run, where a
+	// failure does not trigger errexit.
+	conditions int
+	// aborted is set once errexit has stopped the command.
+	aborted bool
+	// subshell is set for commands run inside ( ... ) or $( ... ), which
+	// share the shell's process.
+	subshell bool
+	// background is set for a command running an && chain in the
+	// background. Its exit statuses leave $? alone, and it cannot run
+	// subshells, whose state would be restored under the running shell.
+	background bool
 }
 
 var globalLispEnv *Environment
@@ -34,7 +56,9 @@ func init() {
 }
 
 func NewCommand(input string, jobManager *JobManager) (*Command, error) {
+	start := time.Now()
 	parsedCmd, err := parser.Parse(input)
+	ProfilePhase("parse", start)
 	if err != nil {
 		return nil, err
 	}
@@ -47,43 +71,258 @@ func NewCommand(input string, jobManager *JobManager) (*Command, error) {
 	}, nil
 }
 
+// NewCommandRaw is NewCommand for a command that runs in raw mode, for
+// scripts and programs embedding gosh that must not be affected by the
+// user's aliases. Like every Command it never touches the history.
+func NewCommandRaw(input string, jobManager *JobManager) (*Command, error) {
+	cmd, err := NewCommand(input, jobManager)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Raw = true
+	return cmd, nil
+}
+
 func (cmd *Command) Run() {
 	cmd.StartTime = time.Now()
 	cmd.TTY = os.Getenv("TTY")
 	cmd.EUID = os.Geteuid()
 
-	for _, andCommand := range cmd.AndCommands {
-		success := true
-		for _, pipeline := range andCommand.Pipelines {
-			success = cmd.executePipeline(pipeline)
+	cmd.runList(cmd.AndCommands)
+	cmd.flushOutput()
+
+	cmd.EndTime = time.Now()
+	cmd.Duration = cmd.EndTime.Sub(cmd.StartTime)
+	ProfilePhase("execute", cmd.StartTime)
+}
+
+// flushOutput flushes cmd.Stdout if it is buffered, such as a *bufio.Writer
+// supplied by a program embedding gosh, so that the output of a command is
+// all written by the time Run returns.
+func (cmd *Command) flushOutput() {
+	f, ok := cmd.Stdout.(flusher)
+	if !ok {
+		return
+	}
+	if err := f.Flush(); err != nil && !isBrokenPipe(err) {
+		cmd.printError("gosh: %v\n", err)
+	}
+}
+
+// runList runs each command of a list in turn, stopping an && chain at its
+// first failure, and reports whether the last command run succeeded. With
+// errexit set, a failing command that ends an && chain outside a condition
+// aborts the whole command.
+func (cmd *Command) runList(andCommands []*parser.AndCommand) bool {
+	success := true
+	for _, andCommand := range andCommands {
+		if andCommand.Background {
+			if cmd.aborted {
+				return false
+			}
+			success = cmd.runInBackground(andCommand)
+			continue
+		}
+		for i, pipeline := range andCommand.Pipelines {
+			if cmd.aborted {
+				return false
+			}
+			success = cmd.runPipeline(pipeline, false)
 			if !success {
+				if i == len(andCommand.Pipelines)-1 && cmd.conditions == 0 && GetGlobalState().GetOption("errexit") {
+					cmd.aborted = true
+				}
 				break
 			}
 		}
-		if !success {
-			break
+	}
+	return success && !cmd.aborted
+}
+
+// runInBackground starts an && chain ended by '&' as a job and returns
+// without waiting for it. A pipeline of simple commands runs as the job's
+// processes, so $! is the PID of its last command and fg, bg and Ctrl-Z
+// can signal it. A longer chain, or one with compound commands, runs on
+// its own goroutine like a subshell, but any builtins in it act on the
+// shell itself; it leaves $! alone. Subshells are refused.
+func (cmd *Command) runInBackground(andCommand *parser.AndCommand) bool {
+	pipelines := andCommand.Pipelines
+	for _, pipeline := range pipelines {
+		for _, stage := range pipeline.Commands {
+			if stage.Subshell != nil && !IsLispExpression(stage.Subshell.Text) {
+				cmd.printError("gosh: subshells are not supported in the background\n")
+				cmd.ReturnCode = 1
+				return false
+			}
 		}
 	}
+	if len(pipelines) == 1 && !hasCompoundStage(pipelines[0]) {
+		return cmd.runPipeline(pipelines[0], true)
+	}
 
-	cmd.EndTime = time.Now()
-	cmd.Duration = cmd.EndTime.Sub(cmd.StartTime)
+	chain := &Command{
+		Command:    &parser.Command{AndCommands: []*parser.AndCommand{{Pipelines: pipelines}}},
+		Stdin:      cmd.Stdin,
+		Stdout:     cmd.Stdout,
+		Stderr:     cmd.Stderr,
+		JobManager: cmd.JobManager,
+		Raw:        cmd.Raw,
+		subshell:   true,
+		background: true,
+	}
+	cmd.ReturnCode = 0
+	if cmd.JobManager == nil {
+		go chain.runUntilExit()
+		return true
+	}
+	job := cmd.JobManager.AddJob(parser.FormatCommand(&parser.Command{AndCommands: []*parser.AndCommand{andCommand}}), nil)
+	cmd.BackgroundJobs = append(cmd.BackgroundJobs, job)
+	cmd.JobManager.WaitInBackground(job, chain.runUntilExit)
+	fmt.Fprintf(cmd.Stderr, "[%d]\n", job.ID)
+	return true
+}
+
+// hasCompoundStage reports whether any stage of a pipeline is a compound
+// command or subshell, which the shell runs itself.
+func hasCompoundStage(pipeline *parser.Pipeline) bool {
+	for _, stage := range pipeline.Commands {
+		if stage.IsCompound() {
+			return true
+		}
+	}
+	return false
+}
+
+// runCondition runs the condition of an if or while clause.
+func (cmd *Command) runCondition(list *parser.List) bool {
+	cmd.conditions++
+	defer func() { cmd.conditions-- }()
+	return cmd.runList(list.AndCommands)
+}
+
+// executeIf runs the branch of an if clause selected by the exit status of
+// its conditions. Like bash, it succeeds when no branch is taken.
+func (cmd *Command) executeIf(clause *parser.IfClause) bool {
+	if cmd.runCondition(clause.Condition) {
+		return cmd.runList(clause.Then.AndCommands)
+	}
+	for _, elif := range clause.Elifs {
+		if cmd.runCondition(elif.Condition) {
+			return cmd.runList(elif.Then.AndCommands)
+		}
+	}
+	if clause.Else != nil {
+		return cmd.runList(clause.Else.AndCommands)
+	}
+	cmd.ReturnCode = 0
+	return true
+}
+
+// executeFor runs the body of a for loop once for each field its words
+// expand to. A loop without `in` runs over the positional parameters.
+func (cmd *Command) executeFor(clause *parser.ForClause) bool {
+	if !isVariableName(clause.Variable) {
+		cmd.printError("for: `%s': not a valid identifier\n", clause.Variable)
+		cmd.ReturnCode = 1
+		return false
+	}
+
+	var words []string
+	if !clause.In {
+		words = GetGlobalState().GetPositionalParams()
+	}
+	for _, word := range clause.Words {
+		for _, braced := range expandBraceWords([]string{word}) {
+			fields, err := cmd.expandWordFields(braced, true)
+			if err != nil {
+				cmd.printError("gosh: %v\n", err)
+				cmd.ReturnCode = 1
+				return false
+			}
+			words = append(words, fields...)
+		}
+	}
+
+	cmd.ReturnCode = 0
+	success := true
+	for _, word := range words {
+		if cmd.aborted {
+			return false
+		}
+		if err := setVariable(clause.Variable, word); err != nil {
+			cmd.printError("for: %v\n", err)
+			cmd.ReturnCode = 1
+			return false
+		}
+		success = cmd.runList(clause.Body.AndCommands)
+	}
+	return success
+}
+
+// runCompound runs a compound command or subshell reading from stdin and
+// writing to stdout, and returns its exit status.
+func (cmd *Command) runCompound(stage *parser.SimpleCommand, stdin io.Reader, stdout io.Writer) int {
+	if stage.Subshell != nil {
+		if stage.Subshell.Err != nil {
+			cmd.printError("gosh: %v\n", stage.Subshell.Err)
+			return 2
+		}
+		return cmd.runSubshell(stage.Subshell.Body, stdin, stdout)
+	}
+
+	savedStdin, savedStdout := cmd.Stdin, cmd.Stdout
+	defer func() { cmd.Stdin, cmd.Stdout = savedStdin, savedStdout }()
+	cmd.Stdin, cmd.Stdout = stdin, stdout
+	switch {
+	case stage.If != nil:
+		cmd.executeIf(stage.If)
+	case stage.For != nil:
+		cmd.executeFor(stage.For)
+	case stage.While != nil:
+		cmd.executeWhile(stage.While)
+	}
+	return cmd.ReturnCode
 }
 
-func (cmd *Command) executePipeline(pipeline *parser.Pipeline) bool {
+// executeWhile runs the body of a while loop for as long as its condition
+// succeeds, or of an until loop for as long as it fails. The loop's status
+// is that of the last body command, or 0 if it never ran.
+func (cmd *Command) executeWhile(clause *parser.WhileClause) bool {
+	status := 0
+	for cmd.runCondition(clause.Condition) != clause.Until && !cmd.aborted {
+		cmd.runList(clause.Body.AndCommands)
+		status = cmd.ReturnCode
+	}
+	if cmd.aborted {
+		return false
+	}
+	cmd.ReturnCode = status
+	return status == 0
+}
+
+// executePipeline runs a pipeline and reports whether it succeeded, along
+// with the exit status of each stage if it ran to the end. With background
+// set, its external commands are left running as a job.
+func (cmd *Command) executePipeline(pipeline *parser.Pipeline, background bool) (bool, []int) {
 	var cmds []*exec.Cmd
+	var contexts []context.Context
+	var cancels []context.CancelFunc
 	var pipes []*io.PipeWriter
+	var stages []int
+	var redirectFiles []*os.File
+	stageStatus := make([]int, len(pipeline.Commands))
+	stageCmds := make([]*parser.SimpleCommand, len(pipeline.Commands))
 	lastOutput := cmd.Stdin
 
 	for i, simpleCmd := range pipeline.Commands {
-		cmdString := strings.Join(simpleCmd.Parts, " ")
-
 		// Check if the command is a Lisp expression
-		if IsLispExpression(cmdString) {
+		if simpleCmd.Subshell != nil && IsLispExpression(simpleCmd.Subshell.Text) {
+			cmdString := simpleCmd.Subshell.Text
 			result, err := ExecuteGoshLisp(cmdString)
 			if err != nil {
-				fmt.Fprintf(cmd.Stderr, "Lisp error in '%s': %v\n", cmdString, err)
+				cmd.printError("Lisp error in '%s': %v\n", cmdString, err)
 				cmd.ReturnCode = 1
-				return false
+				return false, nil
 			}
 			output := fmt.Sprintf("%v\n", result)
 			if i < len(pipeline.Commands)-1 {
@@ -94,117 +333,490 @@ func (cmd *Command) executePipeline(pipeline *parser.Pipeline) bool {
 			continue
 		}
 
-		// Evaluate any embedded Lisp expressions
-		evaluatedCmd, err := evaluateLispInCommand(cmdString)
+		// Compound commands and subshells run in-process, like builtins,
+		// with their redirections applied to every command inside them.
+		if simpleCmd.IsCompound() {
+			stdin, stdout, files, err := cmd.openRedirections(parser.RedirectionsOf(simpleCmd.Redirects))
+			redirectFiles = append(redirectFiles, files...)
+			if err != nil {
+				cmd.printError("gosh: %v\n", err)
+				stageStatus[i] = 1
+				lastOutput = strings.NewReader("")
+				continue
+			}
+			if stdin != nil {
+				lastOutput = stdin
+			}
+			var output bytes.Buffer
+			var stageOutput io.Writer = &output
+			if stdout != nil {
+				stageOutput = stdout
+			} else if i == len(pipeline.Commands)-1 {
+				stageOutput = cmd.Stdout
+			}
+			stageStatus[i] = cmd.runCompound(simpleCmd, lastOutput, stageOutput)
+			if cmd.aborted {
+				closeFiles(redirectFiles)
+				return false, nil
+			}
+			lastOutput = &output
+			continue
+		}
+
+		words := simpleCmd.Parts
+		if !cmd.Raw {
+			words = expandAliases(words)
+		}
+		typed := words
+		cmdString := strings.Join(words, " ")
+
+		// Evaluate any embedded Lisp expressions. The arguments of m28
+		// are M28 code for its own interpreter, so they are left alone.
+		start := time.Now()
+		words, err := evaluateLispWords(words)
+		ProfilePhase("lisp", start)
 		if err != nil {
-			fmt.Fprintf(cmd.Stderr, "Lisp error in '%s': %v\n", cmdString, err)
+			var errs lispErrors
+			errors.As(err, &errs)
+			for _, e := range errs {
+				cmd.printError("Lisp error at column %d in '%s': %s: %v\n", e.Column, cmdString, e.Expr, e.Err)
+			}
 			cmd.ReturnCode = 1
-			return false
+			return false, nil
 		}
 
-		// Re-parse the command after Lisp evaluation
-		parsedCmd, err := parser.Parse(evaluatedCmd)
+		start = time.Now()
+		words, err = cmd.expandWords(words, words[0] != "m28")
+		var redirects []*parser.Redirect
+		if err == nil {
+			redirects, err = cmd.expandRedirects(simpleCmd.Redirects)
+		}
+		ProfilePhase("expand", start)
 		if err != nil {
-			fmt.Fprintf(cmd.Stderr, "Parse error: %v\n", err)
+			cmd.printError("gosh: %v\n", err)
 			cmd.ReturnCode = 1
-			return false
+			return false, nil
+		}
+		if len(words) == 0 {
+			// Nothing is left of a command such as $EMPTY.
+			lastOutput = strings.NewReader("")
+			continue
+		}
+		if !cmd.confirmGuarded(typed, words) {
+			cmd.ReturnCode = 1
+			return false, nil
+		}
+		simpleCmd = &parser.SimpleCommand{Parts: words, Redirects: redirects}
+		stageCmds[i] = simpleCmd
+		runBeforeHooks(simpleCmd)
+
+		cmdName, args, redirections := parser.ProcessCommand2(simpleCmd)
+		if GetGlobalState().GetOption("xtrace") {
+			fmt.Fprintf(cmd.Stderr, "%s%s\n", xtracePrefix(), strings.Join(simpleCmd.Parts, " "))
 		}
-		simpleCmd = parsedCmd.AndCommands[0].Pipelines[0].Commands[0]
 
-		cmdName, args, _, _, _, _ := parser.ProcessCommand(simpleCmd)
+		stdin, stdout, files, err := cmd.openRedirections(redirections)
+		redirectFiles = append(redirectFiles, files...)
+		if err != nil {
+			cmd.printError("gosh: %v\n", err)
+			stageStatus[i] = 1
+			lastOutput = strings.NewReader("")
+			runAfterHooks(simpleCmd, stageStatus[i])
+			continue
+		}
+		if stdin != nil {
+			lastOutput = stdin
+		}
 
-		if builtin, ok := builtins[cmdName]; ok {
-			// Handle builtin commands
+		if name, value, ok := parseAssignment(cmdName); ok && len(args) == 0 {
+			if err := setVariable(name, value); err != nil {
+				cmd.printError("%v\n", err)
+				stageStatus[i] = 1
+			}
+			runAfterHooks(simpleCmd, stageStatus[i])
+			continue
+		}
+
+		if builtin, ok := lookupBuiltin(cmdName); ok {
+			// Handle builtin commands. The last stage writes straight to
+			// cmd.Stdout so interactive builtins are not buffered.
 			var output bytes.Buffer
+			var builtinOutput io.Writer = &output
+			if stdout != nil {
+				builtinOutput = stdout
+			} else if i == len(pipeline.Commands)-1 {
+				builtinOutput = cmd.Stdout
+			}
+			// Builtins read their arguments from the first simple command,
+			// so hand them the evaluated command rather than the whole line.
 			tmpCmd := &Command{
-				Command: cmd.Command,
-				Stdin:   lastOutput,
-				Stdout:  &output,
-				Stderr:  cmd.Stderr,
+				Command:    singleCommand(simpleCmd),
+				Stdin:      lastOutput,
+				Stdout:     builtinOutput,
+				Stderr:     cmd.Stderr,
+				JobManager: cmd.JobManager,
+				Raw:        cmd.Raw,
+				subshell:   cmd.subshell,
+				background: cmd.background,
 			}
 			err := builtin(tmpCmd)
-			if err != nil {
-				fmt.Fprintf(cmd.Stderr, "%s: %v\n", cmdName, err)
-				cmd.ReturnCode = 1
-				return false
+			if err != nil && !isBrokenPipe(err) {
+				closeFiles(redirectFiles)
+				cmd.printError("%s: %v\n", cmdName, err)
+				cmd.ReturnCode = tmpCmd.ReturnCode
+				if cmd.ReturnCode == 0 {
+					cmd.ReturnCode = 1
+				}
+				runAfterHooks(simpleCmd, cmd.ReturnCode)
+				return false, nil
 			}
+			stageStatus[i] = tmpCmd.ReturnCode
 			lastOutput = &output
 
-			// Write the output of the built-in command to cmd.Stdout
-			if i == len(pipeline.Commands)-1 {
-				io.Copy(cmd.Stdout, &output)
+			// A reader that has gone away ends the builtin quietly, the way
+			// an external command is terminated by SIGPIPE.
+			if isBrokenPipe(err) {
+				stageStatus[i] = brokenPipeStatus
 			}
+			runAfterHooks(simpleCmd, stageStatus[i])
 		} else {
-			// Handle external commands
-			execCmd := exec.Command(cmdName, args...)
+			// Handle external commands, which get their words without
+			// the quotes and backslashes that protected them.
+			argv := make([]string, len(args))
+			for j, arg := range args {
+				argv[j] = removeQuotes(arg)
+			}
+			execCmd, ctx, cancel := commandWithTimeout(commandTimeout(), removeQuotes(cmdName), argv...)
+			contexts = append(contexts, ctx)
+			cancels = append(cancels, cancel)
 			gs := GetGlobalState()
 			execCmd.Dir = gs.GetCWD()
+			execCmd.Env = childEnvironment()
 			execCmd.Stdin = lastOutput
 			execCmd.Stderr = cmd.Stderr
 
-			if i < len(pipeline.Commands)-1 {
+			var pipeWriter *io.PipeWriter
+			if stdout != nil {
+				execCmd.Stdout = stdout
+				if i < len(pipeline.Commands)-1 {
+					lastOutput = strings.NewReader("")
+				}
+			} else if i < len(pipeline.Commands)-1 {
 				r, w := io.Pipe()
 				execCmd.Stdout = w
 				lastOutput = r
-				pipes = append(pipes, w)
+				pipeWriter = w
 			} else {
 				execCmd.Stdout = cmd.Stdout
 			}
 
 			cmds = append(cmds, execCmd)
+			pipes = append(pipes, pipeWriter)
+			stages = append(stages, i)
 		}
 	}
 
 	// Start all commands
-	for _, execCmd := range cmds {
+	for i, execCmd := range cmds {
 		err := execCmd.Start()
 		if err != nil {
-			fmt.Fprintf(cmd.Stderr, "Error starting command: %v\n", err)
+			for _, cancel := range cancels {
+				cancel()
+			}
+			closeFiles(redirectFiles)
+			cmd.printError("Error starting command: %v\n", err)
 			cmd.ReturnCode = 1
-			return false
+			runAfterHooks(stageCmds[stages[i]], cmd.ReturnCode)
+			return false, nil
 		}
 	}
 
-	// Wait for all commands to complete
-	for i, execCmd := range cmds {
-		err := execCmd.Wait()
+	// Wait for all commands to complete, recording each stage's exit code
+	waitAll := func() {
+		for i, execCmd := range cmds {
+			err := execCmd.Wait()
+			if contexts[i].Err() == context.DeadlineExceeded {
+				stageStatus[stages[i]] = timeoutStatus
+			} else if err != nil {
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					stageStatus[stages[i]] = exitErr.ExitCode()
+				} else {
+					cmd.printError("Error executing command: %v\n", err)
+					stageStatus[stages[i]] = 1
+				}
+			}
+			cancels[i]()
+			if pipes[i] != nil {
+				pipes[i].Close()
+			}
+			runAfterHooks(stageCmds[stages[i]], stageStatus[stages[i]])
+		}
+		closeFiles(redirectFiles)
+	}
+
+	if background && len(cmds) > 0 {
+		lastCmd := cmds[len(cmds)-1]
+		// Like bash, $! is the PID of the last command of the pipeline.
+		GetGlobalState().SetLastBackgroundPID(lastCmd.Process.Pid)
+		if cmd.JobManager == nil {
+			go waitAll()
+		} else {
+			jobCommand := parser.FormatCommand(&parser.Command{
+				AndCommands: []*parser.AndCommand{{Pipelines: []*parser.Pipeline{pipeline}, Background: true}},
+			})
+			job := cmd.JobManager.AddJob(jobCommand, lastCmd)
+			cmd.BackgroundJobs = append(cmd.BackgroundJobs, job)
+			cmd.JobManager.WaitInBackground(job, func() int {
+				waitAll()
+				return pipelineStatus(stageStatus)
+			})
+			fmt.Fprintf(cmd.Stderr, "[%d] %d\n", job.ID, lastCmd.Process.Pid)
+		}
+		cmd.ReturnCode = 0
+		return true, nil
+	}
+
+	waitAll()
+
+	cmd.ReturnCode = pipelineStatus(stageStatus)
+	return cmd.ReturnCode == 0, stageStatus
+}
+
+// pipelineStatus returns the exit status of a pipeline: that of its last
+// command or, with pipefail set, the rightmost non-zero status.
+func pipelineStatus(stageStatus []int) int {
+	if GetGlobalState().GetOption("pipefail") {
+		for i := len(stageStatus) - 1; i >= 0; i-- {
+			if stageStatus[i] != 0 {
+				return stageStatus[i]
+			}
+		}
+	}
+	return stageStatus[len(stageStatus)-1]
+}
+
+// openRedirections opens the files named by a command's redirections,
+// resolving relative names against the shell's working directory. Every
+// output file is created, as in bash, but only the last one is returned
+// as the command's output.
+func (cmd *Command) openRedirections(redirections parser.Redirections) (io.Reader, io.Writer, []*os.File, error) {
+	var stdin io.Reader
+	var stdout io.Writer
+	var files []*os.File
+	cwd := GetGlobalState().GetCWD()
+
+	if redirections.Input != "" {
+		file, err := os.Open(resolvePath(cwd, redirections.Input))
 		if err != nil {
-			fmt.Fprintf(cmd.Stderr, "Error executing command: %v\n", err)
-			cmd.ReturnCode = 1
-			return false
+			return nil, nil, files, err
+		}
+		files = append(files, file)
+		stdin = file
+	}
+
+	for _, output := range redirections.Outputs {
+		redirectType := ">"
+		if output.Append {
+			redirectType = ">>"
 		}
-		if i < len(cmds)-1 {
-			pipes[i].Close()
+		file, err := cmd.setupOutputRedirection(redirectType, resolvePath(cwd, output.File))
+		if err != nil {
+			return nil, nil, files, err
 		}
+		files = append(files, file)
+		stdout = file
 	}
 
-	cmd.ReturnCode = 0
-	return true
+	return stdin, stdout, files, nil
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// brokenPipeStatus is the exit code of a command killed by SIGPIPE.
+const brokenPipeStatus = 128 + int(syscall.SIGPIPE)
+
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
 }
 
+func singleCommand(simpleCmd *parser.SimpleCommand) *parser.Command {
+	return &parser.Command{
+		AndCommands: []*parser.AndCommand{{
+			Pipelines: []*parser.Pipeline{{
+				Commands: []*parser.SimpleCommand{simpleCmd},
+			}},
+		}},
+	}
+}
+
+// printError reports an execution error on the command's stderr. While a
+// file is being sourced the message is prefixed with its location.
+func (cmd *Command) printError(format string, args ...interface{}) {
+	fmt.Fprint(cmd.Stderr, sourceLocationPrefix())
+	fmt.Fprintf(cmd.Stderr, format, args...)
+}
+
+func sourceLocationPrefix() string {
+	file, line := GetGlobalState().GetSourceLocation()
+	if file == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s: line %d: ", file, line)
+}
+
+// lispError records an embedded Lisp expression that failed to evaluate
+// and its 1-based column in the command line.
+type lispError struct {
+	Column int
+	Expr   string
+	Err    error
+}
+
+// lispErrors collects every failed expression in a command line.
+type lispErrors []lispError
+
+func (errs lispErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = fmt.Sprintf("column %d: '%s': %v", e.Column, e.Expr, e.Err)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// evaluateLispWords replaces the embedded Lisp expressions in the
+// arguments of a command with their values, quoted so that they are taken
+// literally. The arguments of m28 are M28 code and are quoted as they
+// are. Errors are reported like evaluateLispInCommand's, with columns
+// counted in the words joined by spaces.
+func evaluateLispWords(words []string) ([]string, error) {
+	result := append([]string(nil), words...)
+	var errs lispErrors
+	column := len(words[0]) + 1
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case words[0] == "m28":
+			if strings.HasPrefix(word, "(") {
+				result[i] = shellQuote(word)
+			}
+		case strings.Contains(word, "("):
+			value, err := evaluateLispInCommand(word)
+			var wordErrs lispErrors
+			if errors.As(err, &wordErrs) {
+				for _, e := range wordErrs {
+					e.Column += column
+					errs = append(errs, e)
+				}
+			} else if value != word {
+				result[i] = shellQuote(value)
+			}
+		}
+		column += len(word) + 1
+	}
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// expandRedirects expands the file name of each redirection, which must
+// come to exactly one word.
+func (cmd *Command) expandRedirects(redirects []*parser.Redirect) ([]*parser.Redirect, error) {
+	expanded := make([]*parser.Redirect, len(redirects))
+	for i, redirect := range redirects {
+		fields, err := cmd.expandWordFields(redirect.File, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("%s: ambiguous redirect", redirect.File)
+		}
+		expanded[i] = &parser.Redirect{Type: redirect.Type, File: fields[0]}
+	}
+	return expanded, nil
+}
+
+// evaluateLispInCommand replaces each balanced (...) expression in a command
+// line with its value. Every expression is evaluated even after a failure,
+// so all errors can be reported at once; failed expressions keep their
+// original text and the errors are returned as lispErrors. Callers abort
+// the command when any expression fails.
 func evaluateLispInCommand(cmdString string) (string, error) {
-	re := regexp.MustCompile(`\((.*?)\)`)
-	var lastErr error
-	result := re.ReplaceAllStringFunc(cmdString, func(match string) string {
-		if IsLispExpression(match) {
-			result, err := ExecuteGoshLisp(match)
-			if err != nil {
-				lastErr = fmt.Errorf("in '%s': %v", match, err)
-				return match // Keep the original expression if there's an error
+	var result strings.Builder
+	var errs lispErrors
+	last := 0
+	for _, span := range findLispExpressions(cmdString) {
+		expr := cmdString[span[0]:span[1]]
+		if !IsLispExpression(expr) {
+			continue
+		}
+		result.WriteString(cmdString[last:span[0]])
+		last = span[1]
+
+		value, err := ExecuteGoshLisp(expr)
+		if err != nil {
+			errs = append(errs, lispError{Column: span[0] + 1, Expr: expr, Err: err})
+			result.WriteString(expr)
+			continue
+		}
+		result.WriteString(fmt.Sprintf("%v", value))
+	}
+	result.WriteString(cmdString[last:])
+
+	if len(errs) > 0 {
+		return result.String(), errs
+	}
+	return result.String(), nil
+}
+
+// findLispExpressions returns the start and end offsets of the outermost
+// balanced parenthesized expressions in s. An unbalanced '(' is not an
+// expression, and $(...) and $((...)) belong to the shell.
+func findLispExpressions(s string) [][2]int {
+	var spans [][2]int
+	depth, start := 0, 0
+	shell := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			if depth == 0 {
+				start = i
+				shell = i > 0 && s[i-1] == '$'
+			}
+			depth++
+		case ')':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 && !shell {
+				spans = append(spans, [2]int{start, i + 1})
 			}
-			return fmt.Sprintf("%v", result)
 		}
-		return match
-	})
-	return result, lastErr
+	}
+	return spans
 }
 
+// setupOutputRedirection opens filename for > or >>. New files are created
+// with mode 0666 less the umask, as in bash. Missing parent directories
+// are an error, not created.
 func (cmd *Command) setupOutputRedirection(redirectType, filename string) (*os.File, error) {
 	switch redirectType {
 	case ">":
-		return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	case ">>":
-		return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	default:
 		return nil, fmt.Errorf("unknown redirection type: %s", redirectType)
 	}
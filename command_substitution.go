@@ -0,0 +1,49 @@
+package gosh
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"gosh/parser"
+)
+
+// substitutionEnd returns the index of the parenthesis closing a command
+// substitution whose command starts at start, or -1 if there is none.
+// Parentheses inside quotes do not count.
+func substitutionEnd(s string, start int) int {
+	var quotes quoteState
+	depth := 0
+	for i := start; i < len(s); i++ {
+		if quotes.literal(s[i]) || quotes.double && s[i] != '"' {
+			continue
+		}
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// commandOutput runs command in a subshell and returns what it wrote to
+// stdout. All trailing newlines are removed, as in bash.
+func (cmd *Command) commandOutput(command string) string {
+	if strings.TrimSpace(command) == "" {
+		return ""
+	}
+	defer ProfilePhase("substitution", time.Now())
+	body, err := parser.Parse(command)
+	if err != nil {
+		cmd.printError("gosh: %v\n", err)
+		return ""
+	}
+	var output bytes.Buffer
+	cmd.runSubshell(body, cmd.Stdin, &output)
+	return strings.TrimRight(output.String(), "\n")
+}
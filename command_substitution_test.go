@@ -0,0 +1,107 @@
+package gosh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommandSubstitution(t *testing.T) {
+	useTempCWD(t)
+	GetGlobalState().SetVar("subst_test_var", "outer")
+	defer GetGlobalState().UnsetVar("subst_test_var")
+	defer GetGlobalState().UnsetVar("subst_test_lines")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo [$(printf 'a\\n\\n\\n')]", "[a]\n"},
+		{"echo $(printf 'one\\ntwo\\n\\n')", "one two\n"},
+		{"echo \"$(printf 'one\\ntwo\\n\\n')\"", "one\ntwo\n"},
+		{"for w in $(printf 'x y\\nz\\n'); do echo \"<$w>\"; done", "<x>\n<y>\n<z>\n"},
+		{"for w in \"$(printf 'x y\\nz\\n')\"; do echo \"<$w>\"; done", "<x y\nz>\n"},
+		{"subst_test_lines=$(printf 'a\\n\\n'); echo \"[$subst_test_lines]\"", "[a]\n"},
+		{"echo $(echo $subst_test_var | tr a-z A-Z)", "OUTER\n"},
+		{"echo $(subst_test_var=inner; echo $subst_test_var) $subst_test_var", "inner outer\n"},
+		{"echo 'a $(echo quoted)'", "a $(echo quoted)\n"},
+		{"echo [$()]", "[]\n"},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+}
+
+func TestCommandSubstitutionAssignments(t *testing.T) {
+	defer GetGlobalState().UnsetVar("subst_test_x")
+	defer os.Unsetenv("SUBST_TEST_Y")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"subst_test_x=$(echo 42); echo $subst_test_x", "42\n"},
+		{"subst_test_x=$(printf 'a  b\\n\\n'); echo \"[$subst_test_x]\"", "[a  b]\n"},
+		{"subst_test_x=pre$(printf \"it's\")post; echo \"$subst_test_x\"", "preit'spost\n"},
+		{"subst_test_x=\"$(printf 'one\\ntwo')\"; echo \"$subst_test_x\"", "one\ntwo\n"},
+		{"subst_test_x='a b'\"c d\"; echo \"$subst_test_x\"", "a bc d\n"},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+
+	runEcho(t, "export SUBST_TEST_Y=$(printf 'c  d\\n')")
+	if value := os.Getenv("SUBST_TEST_Y"); value != "c  d" {
+		t.Errorf("export SUBST_TEST_Y=$(printf 'c  d\\n') set %q, want %q", value, "c  d")
+	}
+}
+
+func TestAssignmentLikeArguments(t *testing.T) {
+	defer GetGlobalState().UnsetVar("subst_test_x")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo x=$(echo hi)", "x=hi\n"},
+		{"echo x=$(echo a  b)", "x=a b\n"},
+		{`echo x="a b"`, "x=a b\n"},
+		{`echo x='a b'c`, "x=a bc\n"},
+		{`printf '%s|' x=$(echo hi) y="a b"`, "x=hi|y=a b|"},
+		{`echo x="$(printf 'a  b')"`, "x=a  b\n"},
+		{`subst_test_x=$(printf 'a  b'); echo "$subst_test_x"`, "a  b\n"},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+}
+
+func TestCommandSubstitutionOutputIsData(t *testing.T) {
+	dir := useTempCWD(t)
+	defer GetGlobalState().UnsetVar("subst_test_x")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo $(printf 'hi > redir')", "hi > redir\n"},
+		{"echo $(printf 'a; echo injected')", "a; echo injected\n"},
+		{"subst_test_x='$(echo INJ)'; echo [$subst_test_x]", "[$(echo INJ)]\n"},
+		{`echo "[$(printf 'q" ; echo "z')]"`, "[q\" ; echo \"z]\n"},
+		{`echo "$(printf "it's")" $(printf '\\$HOME')`, "it's \\$HOME\n"},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "redir")); err == nil {
+		t.Errorf("redirection in substituted output created a file")
+	}
+}
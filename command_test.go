@@ -0,0 +1,536 @@
+package gosh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useTempCWD points the shell at a fresh directory for the duration of
+// the test and returns it.
+func useTempCWD(t *testing.T) string {
+	t.Helper()
+	gs := GetGlobalState()
+	previous := gs.GetCWD()
+	dir := t.TempDir()
+	gs.UpdateCWD(dir)
+	t.Cleanup(func() { gs.UpdateCWD(previous) })
+	return dir
+}
+
+func TestPipelineRecordsStageStatus(t *testing.T) {
+	cmd, err := NewCommand("false | true | false", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = io.Discard
+	cmd.Run()
+
+	expected := []int{1, 0, 1}
+	if status := GetGlobalState().GetPipeStatus(); !reflect.DeepEqual(status, expected) {
+		t.Errorf("GetPipeStatus() = %v, want %v", status, expected)
+	}
+	if cmd.ReturnCode != 1 {
+		t.Errorf("ReturnCode = %d, want 1", cmd.ReturnCode)
+	}
+
+	echo, err := NewCommand("echo $PIPESTATUS", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	output.Reset()
+	echo.Stdout = &output
+	echo.Run()
+	if output.String() != "1 0 1\n" {
+		t.Errorf("echo $PIPESTATUS = %q, want %q", output.String(), "1 0 1\n")
+	}
+}
+
+func TestLispExpressionWithoutPriorInitialization(t *testing.T) {
+	envMutex.Lock()
+	globalEnv = nil
+	envMutex.Unlock()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(+ 1 2)", "3\n"},
+		{"echo (* 2 3)", "6\n"},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		cmd.Run()
+
+		if output.String() != tt.expected {
+			t.Errorf("Run(%q) output = %q, want %q", tt.input, output.String(), tt.expected)
+		}
+		if cmd.ReturnCode != 0 {
+			t.Errorf("Run(%q) ReturnCode = %d, want 0", tt.input, cmd.ReturnCode)
+		}
+	}
+}
+
+func TestBuiltinWriteToClosedPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	r.Close()
+	defer w.Close()
+
+	cmd, err := NewCommand("echo hello", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	if stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want no error for a broken pipe", stderr.String())
+	}
+	if cmd.ReturnCode != brokenPipeStatus {
+		t.Errorf("ReturnCode = %d, want %d", cmd.ReturnCode, brokenPipeStatus)
+	}
+}
+
+func TestLongBuiltinOutputIntoLimitedReader(t *testing.T) {
+	os.Setenv("GOSH_LONG_VALUE", strings.Repeat("x", 100000))
+	defer os.Unsetenv("GOSH_LONG_VALUE")
+
+	cmd, err := NewCommand("env | head -c 10", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	if stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want no error when the reader exits early", stderr.String())
+	}
+	if stdout.Len() != 10 {
+		t.Errorf("stdout length = %d, want 10", stdout.Len())
+	}
+	if cmd.ReturnCode != 0 {
+		t.Errorf("ReturnCode = %d, want 0", cmd.ReturnCode)
+	}
+}
+
+func TestRedirections(t *testing.T) {
+	dir := useTempCWD(t)
+
+	commands := []string{
+		"echo first > out.txt",
+		"echo second >> out.txt",
+		"cat < out.txt > copy.txt",
+		"echo last > ignored.txt > final.txt",
+		"echo piped > piped.txt | cat > after.txt",
+		"printf '%s-%s\\n' a b > printf.txt",
+		"printf '%s\\n' x y | cat > printf-cat.txt",
+		"echo through | cat > cat.txt",
+		"echo again | cat | cat >> cat.txt",
+	}
+	for _, input := range commands {
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = NewSyncWriter(&stdout)
+		cmd.Stderr = NewSyncWriter(&stderr)
+		cmd.Run()
+		if cmd.ReturnCode != 0 || stdout.Len() != 0 || stderr.Len() != 0 {
+			t.Errorf("%q = (%d, %q, %q), want no output", input, cmd.ReturnCode, stdout.String(), stderr.String())
+		}
+	}
+
+	expected := map[string]string{
+		"out.txt":        "first\nsecond\n",
+		"copy.txt":       "first\nsecond\n",
+		"ignored.txt":    "",
+		"final.txt":      "last\n",
+		"piped.txt":      "piped\n",
+		"after.txt":      "",
+		"printf.txt":     "a-b\n",
+		"printf-cat.txt": "x\ny\n",
+		"cat.txt":        "through\nagain\n",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+
+	cmd, err := NewCommand("cat < missing.txt", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if cmd.ReturnCode != 1 || !strings.Contains(stderr.String(), "missing.txt") {
+		t.Errorf("cat < missing.txt = (%d, %q), want an error about the file", cmd.ReturnCode, stderr.String())
+	}
+}
+
+func TestOutputRedirectionFiles(t *testing.T) {
+	dir := useTempCWD(t)
+
+	old := syscall.Umask(077)
+	defer syscall.Umask(old)
+	if _, stderr, code := runWithOptions(t, "echo private >> private.txt"); code != 0 {
+		t.Fatalf("echo private >> private.txt = %d, stderr %q", code, stderr)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "private.txt")); err != nil {
+		t.Errorf("Failed to stat private.txt: %v", err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("private.txt mode = %v, want 0600 under umask 077", info.Mode().Perm())
+	}
+	syscall.Umask(old)
+
+	_, stderr, code := runWithOptions(t, "echo lost >> new/dir/out.txt")
+	if code != 1 || !strings.Contains(stderr, "new/dir/out.txt") {
+		t.Errorf("echo lost >> new/dir/out.txt = (%d, %q), want an error about the file", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("new exists after a failed redirection (%v), want nothing created", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+	locked := filepath.Join(dir, "locked")
+	if err := os.Mkdir(locked, 0500); err != nil {
+		t.Fatalf("Failed to create %s: %v", locked, err)
+	}
+	defer os.Chmod(locked, 0700)
+	_, stderr, code = runWithOptions(t, "echo denied >> locked/out.txt")
+	if code != 1 || !strings.Contains(stderr, "permission denied") {
+		t.Errorf("echo denied >> locked/out.txt = (%d, %q), want permission denied", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(locked, "out.txt")); !os.IsNotExist(err) {
+		t.Errorf("locked/out.txt exists after a failed redirection (%v)", err)
+	}
+}
+
+func TestEmbeddedLispErrorsReportEveryExpression(t *testing.T) {
+	if output := runEcho(t, "echo (+ 1 (* 2 3))"); output != "7\n" {
+		t.Errorf("echo (+ 1 (* 2 3)) = %q, want %q", output, "7\n")
+	}
+
+	input := "echo (+ 1 2) (+ no-such-a) and (+ no-such-b)"
+	result, err := evaluateLispInCommand(input)
+	var errs lispErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("evaluateLispInCommand(%q) error = %v, want two lispErrors", input, err)
+	}
+	if errs[0].Column != 14 || errs[0].Expr != "(+ no-such-a)" || errs[1].Column != 32 || errs[1].Expr != "(+ no-such-b)" {
+		t.Errorf("evaluateLispInCommand(%q) errors = %+v, want columns 14 and 32", input, errs)
+	}
+	if result != "echo 3 (+ no-such-a) and (+ no-such-b)" {
+		t.Errorf("evaluateLispInCommand(%q) = %q, want failed expressions kept", input, result)
+	}
+
+	// Any failure aborts the command after every error has been reported.
+	cmd, err := NewCommand(input, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if cmd.ReturnCode != 1 || stdout.Len() != 0 {
+		t.Errorf("%q = (%d, %q), want the command aborted", input, cmd.ReturnCode, stdout.String())
+	}
+	for _, expected := range []string{"column 14", "(+ no-such-a)", "column 32", "(+ no-such-b)"} {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("stderr = %q, want it to mention %q", stderr.String(), expected)
+		}
+	}
+}
+
+func TestIfClause(t *testing.T) {
+	useTempCWD(t)
+	GetGlobalState().SetVar("if_var", "b")
+	defer GetGlobalState().UnsetVar("if_var")
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"if true; then echo yes; else echo no; fi", "yes\n", 0},
+		{"if false; then echo yes; else echo no; fi", "no\n", 0},
+		{"if false; then echo yes; fi", "", 0},
+		{"if false; then echo a; elif [ ${if_var} = b ]; then echo b; else echo c; fi", "b\n", 0},
+		{"if true; then echo one; echo two; false; fi", "one\ntwo\n", 1},
+		{"if false && true; then echo yes; else echo no; fi && echo after", "no\nafter\n", 0},
+		{"if true; then if false; then echo inner; else echo nested; fi; fi", "nested\n", 0},
+		{"if printf x | grep -q x; then echo piped; fi", "piped\n", 0},
+		{"false; echo next", "next\n", 0},
+		{"false; if false; then true; fi; echo $?", "0\n", 0},
+		{"if true; then echo abc; fi | tr a X", "Xbc\n", 0},
+		{"if true; then echo inside; fi > if.txt; cat if.txt", "inside\n", 0},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		if stdout.String() != tt.expected || cmd.ReturnCode != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout.String(), cmd.ReturnCode, tt.expected, tt.code, stderr.String())
+		}
+	}
+}
+
+func TestForLoop(t *testing.T) {
+	dir := useTempCWD(t)
+	for _, name := range []string{"a.txt", "b.txt", "c.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	GetGlobalState().SetVar("for_words", "x")
+	defer GetGlobalState().UnsetVar("for_words")
+	defer GetGlobalState().UnsetVar("f")
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"for f in one two three; do echo $f; done", "one\ntwo\nthree\n", 0},
+		{"for f in *.txt; do cat $f; done", "a.txt\nb.txt\n", 0},
+		{"for f in '*.txt' \"$for_words y\"; do echo \"${f}\"; done", "*.txt\nx y\n", 0},
+		{"for f in '*.txt'; do echo ${f}; done", "a.txt b.txt\n", 0},
+		{"for f in $for_words *.none; do echo $f; done", "x\n*.none\n", 0},
+		{"for f in a b; do echo $f; false; done", "a\nb\n", 1},
+		{"for f in; do echo never; done", "", 0},
+		{"for f; do echo never; done", "", 0},
+		{"for f in 1 2; do if [ $f = 2 ]; then echo two; fi; done && echo after", "two\nafter\n", 0},
+		{"false; for f in; do true; done; echo $?", "0\n", 0},
+		{"for f in a b; do echo $f; done | tr ab AB", "A\nB\n", 0},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		if stdout.String() != tt.expected || cmd.ReturnCode != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout.String(), cmd.ReturnCode, tt.expected, tt.code, stderr.String())
+		}
+	}
+}
+
+// TestShellCWDIsAuthoritative runs commands in a shell directory that the
+// process has never changed into.
+func TestShellCWDIsAuthoritative(t *testing.T) {
+	processDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(processDir) })
+	defer GetGlobalState().UnsetVar("f")
+	dir := useTempCWD(t)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "script.sh"), []byte("echo sourced\n"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo hello > out.txt", ""},
+		{"cat < out.txt", "hello\n"},
+		{"[ -f out.txt ] && echo found", "found\n"},
+		{"[ -d sub ] && echo dir", "dir\n"},
+		{"source script.sh", "sourced\n"},
+		{"for f in *.sh; do echo $f; done", "script.sh\n"},
+		{"cd sub && pwd", filepath.Join(dir, "sub") + "\n"},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		if stdout.String() != tt.expected {
+			t.Errorf("%q = %q, want %q; stderr %q", tt.input, stdout.String(), tt.expected, stderr.String())
+		}
+	}
+}
+
+func TestWildcardsFollowShellCWD(t *testing.T) {
+	processDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(processDir) })
+	useTempCWD(t)
+	dir := t.TempDir()
+	for _, name := range []string{"one.txt", "two.txt", "three.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	runEcho(t, "cd "+dir)
+	// Move the process elsewhere so only the shell's directory is in dir.
+	if err := os.Chdir(os.TempDir()); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ls *", "one.txt\nthree.log\ntwo.txt\n"},
+		{"echo *.txt", "one.txt two.txt\n"},
+		{"echo '*.txt' *.none", "*.txt *.none\n"},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q = %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+}
+
+func TestWhileLoop(t *testing.T) {
+	dir := useTempCWD(t)
+	if err := os.WriteFile(filepath.Join(dir, "lines.txt"), []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer GetGlobalState().UnsetVar("i")
+	defer GetGlobalState().UnsetVar("line")
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"i=0; while [ $i -lt 3 ]; do echo $i; i=$((i+1)); done", "0\n1\n2\n", 0},
+		{"i=0; until [ $i -ge 2 ]; do i=$((i+1)); echo $i; done", "1\n2\n", 0},
+		{"while false; do echo never; done", "", 0},
+		{"until true; do echo never; done", "", 0},
+		{"i=0; while [ $i -lt 2 ]; do i=$((i+1)); false; done", "", 1},
+		{"while read line; do echo got $line; done < lines.txt", "got one\ngot two\ngot three\n", 0},
+		{"while false; do true; done < missing.txt", "", 1},
+		{"false; while false; do true; done; echo $?", "0\n", 0},
+		{"false; until true; do true; done; echo $?", "0\n", 0},
+		{"while read line; do echo $line; done < lines.txt | tr a-z A-Z", "ONE\nTWO\nTHREE\n", 0},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		if stdout.String() != tt.expected || cmd.ReturnCode != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout.String(), cmd.ReturnCode, tt.expected, tt.code, stderr.String())
+		}
+	}
+
+	cmd, err := NewCommand("while read line; do echo $line; done < lines.txt > copy.txt", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Stdout = &bytes.Buffer{}
+	cmd.Stderr = &bytes.Buffer{}
+	cmd.Run()
+	if got, err := os.ReadFile(filepath.Join(dir, "copy.txt")); err != nil || string(got) != "one\ntwo\nthree\n" {
+		t.Errorf("while loop redirected to copy.txt wrote %q (%v), want %q", got, err, "one\ntwo\nthree\n")
+	}
+}
+
+func TestExternalCommandWords(t *testing.T) {
+	dir := useTempCWD(t)
+	if err := os.WriteFile(filepath.Join(dir, "found.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`find . -name found.txt -exec echo found {} \;`, "found ./found.txt\n"},
+		{`find . -name found.txt -exec echo found {} ';'`, "found ./found.txt\n"},
+		{`sh -c 'printf "[%s]" "$@"' sh "a b" 'c d' e\ f`, "[a b][c d][e f]"},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != 0 {
+			t.Errorf("%q = (%q, %d), want (%q, 0); stderr %q", tt.input, stdout, code, tt.expected, stderr)
+		}
+	}
+}
+
+func TestLongCommandLine(t *testing.T) {
+	words := strings.Repeat("$x ${x:-y} $((1+1)) {a,b} (not-lisp) ", 1<<20/40)
+	cmd, err := NewCommand("true "+words, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	done := make(chan struct{})
+	go func() {
+		cmd.Run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("a 1MB command line took over 30s to run")
+	}
+	if cmd.ReturnCode != 0 {
+		t.Errorf("ReturnCode = %d, want 0; stderr %q", cmd.ReturnCode, stderr.String())
+	}
+}
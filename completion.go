@@ -3,46 +3,347 @@ package gosh
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// CompletionType restricts which filesystem entries are offered when
+// completing the arguments of a command.
+type CompletionType int
+
+const (
+	CompleteDefault CompletionType = iota
+	CompleteDirectories
+	// CompleteFiles offers files and, with a trailing slash, the
+	// directories that lead to them.
+	CompleteFiles
+)
+
+var (
+	completionTypes = map[string]CompletionType{
+		"cd": CompleteDirectories,
+	}
+	// completionExtensions lists the file extensions preferred when
+	// completing the arguments of a command, or of one of its options
+	// when keyed as "command -option".
+	completionExtensions = map[string][]string{
+		"source": {".sh", ".gosh"},
+		".":      {".sh", ".gosh"},
+		"m28 -f": {".m28"},
+	}
+	completionTypesMu sync.RWMutex
+)
+
+func SetCompletionType(command string, completionType CompletionType) {
+	completionTypesMu.Lock()
+	defer completionTypesMu.Unlock()
+	completionTypes[command] = completionType
+}
+
+func GetCompletionType(command string) CompletionType {
+	completionTypesMu.RLock()
+	defer completionTypesMu.RUnlock()
+	return completionTypes[command]
+}
+
+// CompletionTypes returns a copy of every registered completion type.
+func CompletionTypes() map[string]CompletionType {
+	completionTypesMu.RLock()
+	defer completionTypesMu.RUnlock()
+	types := make(map[string]CompletionType, len(completionTypes))
+	for command, completionType := range completionTypes {
+		types[command] = completionType
+	}
+	return types
+}
+
+// RemoveCompletionType forgets the completion type of command, which then
+// completes like any other command. It reports whether one was registered.
+func RemoveCompletionType(command string) bool {
+	completionTypesMu.Lock()
+	defer completionTypesMu.Unlock()
+	_, ok := completionTypes[command]
+	delete(completionTypes, command)
+	return ok
+}
+
+// ClearCompletionTypes forgets every registered completion type.
+func ClearCompletionTypes() {
+	completionTypesMu.Lock()
+	defer completionTypesMu.Unlock()
+	completionTypes = make(map[string]CompletionType)
+}
+
+// SetCompletionExtensions sets the file extensions preferred when
+// completing arguments of command. Other files are still offered when no
+// file has one of the extensions.
+func SetCompletionExtensions(command string, extensions []string) {
+	completionTypesMu.Lock()
+	defer completionTypesMu.Unlock()
+	completionExtensions[command] = extensions
+}
+
+func GetCompletionExtensions(command string) []string {
+	completionTypesMu.RLock()
+	defer completionTypesMu.RUnlock()
+	return completionExtensions[command]
+}
+
 type Completer struct {
 	builtins     map[string]func(cmd *Command) error
 	commands     []string
+	paths        map[string]string
+	frequency    map[string]int
+	jobManager   *JobManager
 	commandsLock sync.RWMutex
-	loaded       chan struct{}
+	// loaded is closed once PATH has first been indexed. indexedPath is
+	// the PATH the index was built from, and reindexing is set while it
+	// is rebuilt after PATH changed.
+	loaded      chan struct{}
+	indexedPath string
+	reindexing  bool
 }
 
 func NewCompleter(builtins map[string]func(cmd *Command) error) *Completer {
 	c := &Completer{
-		builtins: builtins,
-		commands: make([]string, 0, len(builtins)),
-		loaded:   make(chan struct{}),
+		builtins:  builtins,
+		commands:  make([]string, 0, len(builtins)),
+		paths:     make(map[string]string),
+		frequency: make(map[string]int),
+		loaded:    make(chan struct{}),
 	}
 	for cmd := range builtins {
 		c.commands = append(c.commands, cmd)
 	}
-	go c.loadCommands()
+	go c.loadCommands(os.Getenv("PATH"))
+
+	commandIndexMu.Lock()
+	commandIndex = c
+	commandIndexMu.Unlock()
 	return c
 }
 
-func (c *Completer) loadCommands() {
-	pathDirs := filepath.SplitList(os.Getenv("PATH"))
-	for _, dir := range pathDirs {
+// commandIndex is the most recently created completer, whose index of
+// PATH `which` consults.
+var (
+	commandIndex   *Completer
+	commandIndexMu sync.RWMutex
+)
+
+// ResolvePath returns the absolute path of the executable that name runs,
+// taken from the index of PATH built for completion. Names containing a
+// slash, and names the index does not hold or holds for an old PATH, are
+// searched for directly.
+func (c *Completer) ResolvePath(name string) (string, bool) {
+	if !strings.Contains(name, "/") && c.indexCurrent() {
+		c.commandsLock.RLock()
+		path, ok := c.paths[name]
+		c.commandsLock.RUnlock()
+		if ok {
+			return path, true
+		}
+	}
+	return findAbsoluteExecutable(name)
+}
+
+// indexCurrent reports whether the index is built and matches PATH. Once
+// PATH has changed it starts rebuilding the index and reports false until
+// that is done.
+func (c *Completer) indexCurrent() bool {
+	select {
+	case <-c.loaded:
+	default:
+		return false
+	}
+	path := os.Getenv("PATH")
+	c.commandsLock.Lock()
+	defer c.commandsLock.Unlock()
+	if path == c.indexedPath {
+		return true
+	}
+	if !c.reindexing {
+		c.reindexing = true
+		go c.loadCommands(path)
+	}
+	return false
+}
+
+// pathStatTimeout bounds how long indexing waits on a PATH directory, so
+// a slow network mount cannot stall completion.
+const pathStatTimeout = 200 * time.Millisecond
+
+// loadCommands indexes the executables in the directories of path, then
+// replaces the commands offered for completion with the builtins and
+// those executables.
+func (c *Completer) loadCommands(path string) {
+	ignored := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("GOSH_PATH_IGNORE")) {
+		ignored[filepath.Clean(dir)] = true
+	}
+
+	commands := make([]string, 0, len(c.builtins))
+	for cmd := range c.builtins {
+		commands = append(commands, cmd)
+	}
+	paths := make(map[string]string)
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" || ignored[filepath.Clean(dir)] || !statWithin(dir, pathStatTimeout) {
+			continue
+		}
 		files, err := os.ReadDir(dir)
 		if err != nil {
 			continue
 		}
 		for _, file := range files {
-			if file.Type().IsRegular() && file.Type().Perm()&0111 != 0 {
-				c.commandsLock.Lock()
-				c.commands = append(c.commands, file.Name())
-				c.commandsLock.Unlock()
+			// Stat the entry rather than using its Info, so that a
+			// symlink to an executable counts.
+			file := filepath.Join(dir, file.Name())
+			if !isExecutable(file) {
+				continue
 			}
+			name := filepath.Base(file)
+			commands = append(commands, name)
+			// The first directory in PATH holding a name wins.
+			if _, ok := paths[name]; !ok {
+				paths[name] = resolvePath(GetGlobalState().GetCWD(), file)
+			}
+		}
+	}
+
+	c.commandsLock.Lock()
+	defer c.commandsLock.Unlock()
+	c.commands = commands
+	c.paths = paths
+	c.indexedPath = path
+	c.reindexing = false
+	select {
+	case <-c.loaded:
+	default:
+		close(c.loaded)
+	}
+}
+
+// SetCommandFrequencies seeds the usage counts used to rank command
+// completions, typically from CommandUseCounts.
+func (c *Completer) SetCommandFrequencies(counts map[string]int) {
+	c.commandsLock.Lock()
+	defer c.commandsLock.Unlock()
+	for name, count := range counts {
+		c.frequency[name] = count
+	}
+}
+
+// RecordCommandUsage counts the commands of a line that was just run.
+func (c *Completer) RecordCommandUsage(line string) {
+	c.commandsLock.Lock()
+	defer c.commandsLock.Unlock()
+	for _, name := range commandNames(line) {
+		c.frequency[name]++
+	}
+}
+
+// jobSpecCommands take job specs such as %1 as arguments.
+var jobSpecCommands = map[string]bool{
+	"fg":     true,
+	"bg":     true,
+	"kill":   true,
+	"wait":   true,
+	"disown": true,
+}
+
+// variableNameCommands take the names of variables as arguments.
+var variableNameCommands = map[string]bool{
+	"unset":    true,
+	"export":   true,
+	"printenv": true,
+}
+
+// completingArgument reports whether the word being completed is an
+// argument rather than the command word itself.
+func completingArgument(lineStr string, parts []string) bool {
+	if strings.HasSuffix(lineStr, " ") {
+		return true
+	}
+	if len(parts) < 2 {
+		return false
+	}
+	previous := parts[len(parts)-2]
+	return previous != "|" && previous != "&&"
+}
+
+// completeVariableNames offers the names of environment and shell
+// variables that start with prefix.
+func completeVariableNames(prefix string) (newLine [][]rune, length int) {
+	names := GetGlobalState().GetVarNames()
+	for _, env := range os.Environ() {
+		if name, _, ok := strings.Cut(env, "="); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			newLine = append(newLine, []rune(name[len(prefix):]))
+		}
+	}
+	return newLine, len(prefix)
+}
+
+// SetJobManager gives the completer the jobs to offer for %job specs.
+func (c *Completer) SetJobManager(jobManager *JobManager) {
+	c.commandsLock.Lock()
+	defer c.commandsLock.Unlock()
+	c.jobManager = jobManager
+}
+
+// completeJobSpecs offers %N and %command for every current job.
+func (c *Completer) completeJobSpecs(prefix string) (newLine [][]rune, length int) {
+	c.commandsLock.RLock()
+	jobManager := c.jobManager
+	c.commandsLock.RUnlock()
+	if jobManager == nil {
+		return nil, len(prefix)
+	}
+
+	jobs := jobManager.ListJobs()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	var specs []string
+	for _, job := range jobs {
+		specs = append(specs, "%"+strconv.Itoa(job.ID))
+		if fields := strings.Fields(job.Command); len(fields) > 0 {
+			specs = append(specs, "%"+fields[0])
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, prefix) && !seen[spec] {
+			seen[spec] = true
+			newLine = append(newLine, []rune(spec[len(prefix):]))
 		}
 	}
-	close(c.loaded)
+	return newLine, len(prefix)
+}
+
+// statWithin reports whether path can be stat'ed within timeout.
+func statWithin(path string, timeout time.Duration) bool {
+	result := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(path)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 func (c *Completer) Do(line []rune, pos int) (newLine [][]rune, length int) {
@@ -57,20 +358,76 @@ func (c *Completer) Do(line []rune, pos int) (newLine [][]rune, length int) {
 	if lastPart == "&&" {
 		return c.completeCommands("", false)
 	}
+	commandName := currentCommandName(parts)
+	if jobSpecCommands[commandName] && strings.HasPrefix(lastPart, "%") && !strings.HasSuffix(lineStr, " ") {
+		return c.completeJobSpecs(lastPart)
+	}
+
+	if variableNameCommands[commandName] && completingArgument(lineStr, parts) {
+		prefix := lastPart
+		if strings.HasSuffix(lineStr, " ") {
+			prefix = ""
+		}
+		return completeVariableNames(prefix)
+	}
+
 	// Complete filenames for arguments
-	return c.completeFilenames(lineStr)
+	extensionKey := commandName
+	if option := previousWord(lineStr, parts); strings.HasPrefix(option, "-") {
+		extensionKey += " " + option
+	}
+	return c.completeFilenames(lineStr, GetCompletionType(commandName), GetCompletionExtensions(extensionKey))
+}
+
+// previousWord returns the word before the one being completed.
+func previousWord(lineStr string, parts []string) string {
+	if strings.HasSuffix(lineStr, " ") {
+		return parts[len(parts)-1]
+	}
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// currentCommandName returns the command word of the simple command being
+// completed, i.e. the first word after the last pipe or &&.
+func currentCommandName(parts []string) string {
+	start := 0
+	for i, part := range parts {
+		if part == "|" || part == "&&" {
+			start = i + 1
+		}
+	}
+	if start < len(parts) {
+		return parts[start]
+	}
+	return ""
 }
 
 func (c *Completer) completeCommands(prefix string, partial bool) (newLine [][]rune, length int) {
+	c.indexCurrent()
 	c.commandsLock.RLock()
 	defer c.commandsLock.RUnlock()
 
+	var matches []string
 	for _, cmd := range c.commands {
 		if strings.HasPrefix(cmd, prefix) {
-			newLine = append(newLine, []rune(cmd[len(prefix):]))
+			matches = append(matches, cmd)
 		}
 	}
 
+	// Offer the most frequently used commands first.
+	sort.SliceStable(matches, func(i, j int) bool {
+		if c.frequency[matches[i]] != c.frequency[matches[j]] {
+			return c.frequency[matches[i]] > c.frequency[matches[j]]
+		}
+		return matches[i] < matches[j]
+	})
+	for _, cmd := range matches {
+		newLine = append(newLine, []rune(cmd[len(prefix):]))
+	}
+
 	if len(newLine) == 1 && !partial {
 		newLine[0] = append(newLine[0], ' ')
 	}
@@ -78,26 +435,80 @@ func (c *Completer) completeCommands(prefix string, partial bool) (newLine [][]r
 	return newLine, len(prefix)
 }
 
-func (c *Completer) completeFilenames(line string) (newLine [][]rune, length int) {
+// completeFilenames completes the last word of line as a path. When
+// extensions are given and some file has one of them, only those files and
+// directories are offered.
+func (c *Completer) completeFilenames(line string, completionType CompletionType, extensions []string) (newLine [][]rune, length int) {
 	lastWord := line[strings.LastIndex(line, " ")+1:]
 	dir := filepath.Dir(lastWord)
 	prefix := filepath.Base(lastWord)
+	if lastWord == "" || strings.HasSuffix(lastWord, "/") {
+		dir = filepath.Clean(lastWord + ".")
+		prefix = ""
+	}
+	dir = resolvePath(GetGlobalState().GetCWD(), dir)
 
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, len(prefix)
 	}
 
+	// Hidden entries are only offered when the word starts with a dot,
+	// unless dotglob is set. A bare dot also offers . and .. themselves.
+	hidden := strings.HasPrefix(prefix, ".")
+	dotglob := GetGlobalState().GetOption("dotglob")
+	if hidden {
+		for _, name := range []string{".", ".."} {
+			if strings.HasPrefix(name, prefix) {
+				newLine = append(newLine, []rune(name[len(prefix):]+"/"))
+			}
+		}
+	}
+
+	var preferred [][]rune
 	for _, entry := range entries {
 		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !hidden && !dotglob {
+			continue
+		}
 		if strings.HasPrefix(name, prefix) {
+			isDir := entry.IsDir()
+			if !isDir && entry.Type()&os.ModeSymlink != 0 {
+				if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					isDir = info.IsDir()
+				}
+			}
+			if completionType == CompleteDirectories && !isDir {
+				continue
+			}
+
 			completion := name[len(prefix):]
-			if entry.IsDir() {
+			if isDir {
 				completion += "/"
 			}
 			newLine = append(newLine, []rune(completion))
+			if !isDir && hasExtension(name, extensions) {
+				preferred = append(preferred, []rune(completion))
+			}
 		}
 	}
 
+	if len(preferred) > 0 {
+		for _, completion := range newLine {
+			if strings.HasSuffix(string(completion), "/") {
+				preferred = append(preferred, completion)
+			}
+		}
+		return preferred, len(prefix)
+	}
 	return newLine, len(prefix)
 }
+
+func hasExtension(name string, extensions []string) bool {
+	for _, extension := range extensions {
+		if filepath.Ext(name) == extension {
+			return true
+		}
+	}
+	return false
+}
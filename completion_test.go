@@ -0,0 +1,480 @@
+package gosh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func completionStrings(candidates [][]rune) []string {
+	result := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		result[i] = string(candidate)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// chdirForTest moves both the process and the shell into dir.
+func chdirForTest(t *testing.T, dir string) {
+	t.Helper()
+	gs := GetGlobalState()
+	previousCWD := gs.GetCWD()
+	gs.UpdateCWD(dir)
+	t.Cleanup(func() { gs.UpdateCWD(previousCWD) })
+
+	previous, err := os.Getwd()
+	if err != nil {
+		// An earlier test may have removed the directory we were in.
+		previous = os.TempDir()
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func TestCompletionUsesShellCWD(t *testing.T) {
+	dir := useTempCWD(t)
+	if err := os.Mkdir(filepath.Join(dir, "shell-only-dir"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "shell-only-dir", "inner.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	completer := NewCompleter(Builtins())
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"cd shell-only", []string{"-dir/"}},
+		{"cat shell-only-dir/in", []string{"ner.txt"}},
+	}
+	for _, tt := range tests {
+		candidates, _ := completer.Do([]rune(tt.line), len(tt.line))
+		if result := completionStrings(candidates); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Do(%q) = %v, want %v", tt.line, result, tt.expected)
+		}
+	}
+}
+
+func TestCompletionByCommandType(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"alpha", "beta"} {
+		if err := os.Mkdir(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	for _, file := range []string{"apple.txt", "banana.txt"} {
+		if err := os.WriteFile(filepath.Join(tempDir, file), nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	chdirForTest(t, tempDir)
+
+	cmd, err := NewCommand("complete -f gosh-test-viewer", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Run()
+	if cmd.ReturnCode != 0 {
+		t.Fatalf("complete -f returned %d", cmd.ReturnCode)
+	}
+
+	completer := NewCompleter(Builtins())
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"cd ", []string{"alpha/", "beta/"}},
+		{"cd a", []string{"lpha/"}},
+		{"ls a", []string{"lpha/", "pple.txt"}},
+		{"echo hi && cd b", []string{"eta/"}},
+		{"gosh-test-viewer ", []string{"alpha/", "apple.txt", "banana.txt", "beta/"}},
+		{"gosh-test-viewer a", []string{"lpha/", "pple.txt"}},
+	}
+
+	for _, tt := range tests {
+		candidates, _ := completer.Do([]rune(tt.line), len(tt.line))
+		if result := completionStrings(candidates); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Do(%q) = %v, want %v", tt.line, result, tt.expected)
+		}
+	}
+}
+
+func TestPathIgnoreSkipsDirectories(t *testing.T) {
+	indexed, ignored := t.TempDir(), t.TempDir()
+	for dir, name := range map[string]string{indexed: "gosh-test-indexed", ignored: "gosh-test-ignored"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0755); err != nil {
+			t.Fatalf("Failed to create executable: %v", err)
+		}
+	}
+	t.Setenv("PATH", indexed+string(os.PathListSeparator)+ignored)
+	t.Setenv("GOSH_PATH_IGNORE", ignored+"/")
+
+	completer := NewCompleter(nil)
+	<-completer.loaded
+	candidates, _ := completer.completeCommands("gosh-test-", true)
+	if result := completionStrings(candidates); !reflect.DeepEqual(result, []string{"indexed"}) {
+		t.Errorf("completeCommands(%q) = %v, want %v", "gosh-test-", result, []string{"indexed"})
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := os.WriteFile(filepath.Join(dir, "gosh-test-resolve"), nil, 0755); err != nil {
+			t.Fatalf("Failed to create executable: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(second, "gosh-test-data"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create data file: %v", err)
+	}
+	t.Setenv("PATH", first+string(os.PathListSeparator)+second)
+
+	completer := NewCompleter(nil)
+	tests := []struct {
+		name     string
+		expected string
+		found    bool
+	}{
+		{"gosh-test-resolve", filepath.Join(first, "gosh-test-resolve"), true},
+		{"gosh-test-data", "", false},
+		{"gosh-test-missing", "", false},
+		{filepath.Join(second, "gosh-test-resolve"), filepath.Join(second, "gosh-test-resolve"), true},
+	}
+	check := func(when string) {
+		for _, tt := range tests {
+			if path, found := completer.ResolvePath(tt.name); path != tt.expected || found != tt.found {
+				t.Errorf("ResolvePath(%q) %s = (%q, %v), want (%q, %v)", tt.name, when, path, found, tt.expected, tt.found)
+			}
+		}
+	}
+	check("while indexing")
+	<-completer.loaded
+	check("after indexing")
+}
+
+func TestResolvePathFollowsPath(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(first, "gosh-test-target"), nil, 0755); err != nil {
+		t.Fatalf("Failed to create executable: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(first, "gosh-test-target"), filepath.Join(first, "gosh-test-link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(second, "gosh-test-moved"), nil, 0755); err != nil {
+		t.Fatalf("Failed to create executable: %v", err)
+	}
+	t.Setenv("PATH", first)
+
+	completer := NewCompleter(nil)
+	<-completer.loaded
+	if path, found := completer.ResolvePath("gosh-test-link"); path != filepath.Join(first, "gosh-test-link") || !found {
+		t.Errorf("ResolvePath of a symlinked executable = (%q, %v), want it indexed", path, found)
+	}
+	candidates, _ := completer.completeCommands("gosh-test-", true)
+	if result := completionStrings(candidates); !reflect.DeepEqual(result, []string{"link", "target"}) {
+		t.Errorf("completeCommands(%q) = %v, want %v", "gosh-test-", result, []string{"link", "target"})
+	}
+
+	added := filepath.Join(first, "gosh-test-added")
+	if err := os.WriteFile(added, nil, 0755); err != nil {
+		t.Fatalf("Failed to create executable: %v", err)
+	}
+	if path, found := completer.ResolvePath("gosh-test-added"); path != added || !found {
+		t.Errorf("ResolvePath of an executable added after indexing = (%q, %v), want (%q, true)", path, found, added)
+	}
+
+	os.Setenv("PATH", second)
+	if path, found := completer.ResolvePath("gosh-test-target"); found {
+		t.Errorf("ResolvePath after PATH changed = %q, want the old PATH forgotten", path)
+	}
+	if path, found := completer.ResolvePath("gosh-test-moved"); path != filepath.Join(second, "gosh-test-moved") || !found {
+		t.Errorf("ResolvePath after PATH changed = (%q, %v), want the new PATH searched", path, found)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !completer.indexCurrent(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("index was not rebuilt after PATH changed")
+		}
+	}
+	candidates, _ = completer.completeCommands("gosh-test-", true)
+	if result := completionStrings(candidates); !reflect.DeepEqual(result, []string{"moved"}) {
+		t.Errorf("completeCommands(%q) after PATH changed = %v, want %v", "gosh-test-", result, []string{"moved"})
+	}
+}
+
+func TestCompletionConcurrentWithIndexing(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 200; i++ {
+		name := filepath.Join(dir, "gosh-stress-"+strconv.Itoa(i))
+		if err := os.WriteFile(name, nil, 0755); err != nil {
+			t.Fatalf("Failed to create executable: %v", err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	chdirForTest(t, dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		completer := NewCompleter(Builtins())
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 50; k++ {
+					completer.Do([]rune(""), 0)
+					completer.Do([]rune("ls gosh-"), len("ls gosh-"))
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-completer.loaded
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompletionRankedByHistoryUsage(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"gosh-rank-a", "gosh-rank-b", "gosh-rank-c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0755); err != nil {
+			t.Fatalf("Failed to create executable: %v", err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	historyManager, err := NewHistoryManager(filepath.Join(t.TempDir(), "history.sqlite"))
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	for _, input := range []string{"gosh-rank-c", "gosh-rank-b x | gosh-rank-c", "echo hi && gosh-rank-c"} {
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		if _, err := historyManager.Insert(cmd, 0); err != nil {
+			t.Fatalf("Insert() returned error: %v", err)
+		}
+	}
+
+	counts, err := CommandUseCounts(historyManager)
+	if err != nil {
+		t.Fatalf("CommandUseCounts() returned error: %v", err)
+	}
+	expectedCounts := map[string]int{"gosh-rank-c": 3, "gosh-rank-b": 1, "echo": 1}
+	if !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("CommandUseCounts() = %v, want %v", counts, expectedCounts)
+	}
+
+	completer := NewCompleter(nil)
+	<-completer.loaded
+	completer.SetCommandFrequencies(counts)
+
+	order := func() []string {
+		candidates, _ := completer.completeCommands("gosh-rank-", true)
+		result := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			result[i] = string(candidate)
+		}
+		return result
+	}
+	if result := order(); !reflect.DeepEqual(result, []string{"c", "b", "a"}) {
+		t.Errorf("completion order = %v, want %v", result, []string{"c", "b", "a"})
+	}
+
+	for i := 0; i < 4; i++ {
+		completer.RecordCommandUsage("gosh-rank-a")
+	}
+	if result := order(); !reflect.DeepEqual(result, []string{"a", "c", "b"}) {
+		t.Errorf("completion order after use = %v, want %v", result, []string{"a", "c", "b"})
+	}
+}
+
+func TestCompletionOfHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{".hidden", "visible"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".config"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	chdirForTest(t, dir)
+	defer GetGlobalState().SetOption("dotglob", false)
+
+	completer := NewCompleter(Builtins())
+	tests := []struct {
+		line     string
+		dotglob  bool
+		expected []string
+	}{
+		{"cat ", false, []string{"visible"}},
+		{"cat .", false, []string{"./", "/", "config/", "hidden"}},
+		{"cat .h", false, []string{"idden"}},
+		{"cd .", false, []string{"./", "/", "config/"}},
+		{"cat ", true, []string{".config/", ".hidden", "visible"}},
+	}
+
+	for _, tt := range tests {
+		GetGlobalState().SetOption("dotglob", tt.dotglob)
+		candidates, _ := completer.Do([]rune(tt.line), len(tt.line))
+		if result := completionStrings(candidates); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Do(%q) with dotglob=%v = %v, want %v", tt.line, tt.dotglob, result, tt.expected)
+		}
+	}
+}
+
+func TestCompletionOfJobSpecs(t *testing.T) {
+	jobManager := NewJobManager()
+	jobManager.AddJob("sleep 100 &", nil)
+	jobManager.AddJob("vim notes.txt", nil)
+
+	completer := NewCompleter(Builtins())
+	completer.SetJobManager(jobManager)
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"fg %", []string{"1", "2", "sleep", "vim"}},
+		{"kill %s", []string{"leep"}},
+		{"bg %2", []string{""}},
+		{"disown %x", []string{}},
+	}
+
+	for _, tt := range tests {
+		candidates, _ := completer.Do([]rune(tt.line), len(tt.line))
+		if result := completionStrings(candidates); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Do(%q) = %v, want %v", tt.line, result, tt.expected)
+		}
+	}
+}
+
+func TestCompletionOfVariableNames(t *testing.T) {
+	t.Setenv("GOSH_COMPLETION_ENV", "1")
+	GetGlobalState().SetVar("GOSH_COMPLETION_VAR", "1")
+	defer GetGlobalState().UnsetVar("GOSH_COMPLETION_VAR")
+
+	completer := NewCompleter(Builtins())
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"unset GOSH_COMPLETION_", []string{"ENV", "VAR"}},
+		{"export GOSH_COMPLETION_E", []string{"NV"}},
+		{"printenv GOSH_COMPLETION_V", []string{"AR"}},
+		{"unset GOSH_COMPLETION_X", []string{}},
+	}
+
+	for _, tt := range tests {
+		candidates, _ := completer.Do([]rune(tt.line), len(tt.line))
+		if result := completionStrings(candidates); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Do(%q) = %v, want %v", tt.line, result, tt.expected)
+		}
+	}
+
+	candidates, _ := completer.Do([]rune("unset "), len("unset "))
+	found := false
+	for _, candidate := range completionStrings(candidates) {
+		found = found || candidate == "GOSH_COMPLETION_VAR"
+	}
+	if !found {
+		t.Errorf("Do(%q) does not offer GOSH_COMPLETION_VAR", "unset ")
+	}
+}
+
+func TestCompletionPrefersScriptExtensions(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, file := range []string{"setup.sh", "env.gosh", "notes.txt", "lib.m28"} {
+		if err := os.WriteFile(filepath.Join(tempDir, file), nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "scripts"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	chdirForTest(t, tempDir)
+
+	completer := NewCompleter(Builtins())
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"source ", []string{"env.gosh", "scripts/", "setup.sh"}},
+		{". s", []string{"cripts/", "etup.sh"}},
+		{"source n", []string{"otes.txt"}},
+		{"m28 -f ", []string{"lib.m28", "scripts/"}},
+		{"m28 ", []string{"env.gosh", "lib.m28", "notes.txt", "scripts/", "setup.sh"}},
+		{"cat ", []string{"env.gosh", "lib.m28", "notes.txt", "scripts/", "setup.sh"}},
+	}
+
+	for _, tt := range tests {
+		candidates, _ := completer.Do([]rune(tt.line), len(tt.line))
+		if result := completionStrings(candidates); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Do(%q) = %v, want %v", tt.line, result, tt.expected)
+		}
+	}
+}
+
+func TestCompleteRegistrations(t *testing.T) {
+	saved := CompletionTypes()
+	t.Cleanup(func() {
+		ClearCompletionTypes()
+		for command, completionType := range saved {
+			SetCompletionType(command, completionType)
+		}
+	})
+	ClearCompletionTypes()
+
+	run := func(input string) (string, string, int) {
+		t.Helper()
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", input, err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+		return stdout.String(), stderr.String(), cmd.ReturnCode
+	}
+
+	run("complete -d gosh-test-dirs")
+	run("complete -f gosh-test-files gosh-test-other")
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"complete -p", "complete -d gosh-test-dirs\ncomplete -f gosh-test-files\ncomplete -f gosh-test-other\n", 0},
+		{"complete -p gosh-test-files", "complete -f gosh-test-files\n", 0},
+		{"complete -p gosh-test-unknown", "", 1},
+		{"complete -r gosh-test-files", "", 0},
+		{"complete -r gosh-test-files", "", 1},
+		{"complete -p", "complete -d gosh-test-dirs\ncomplete -f gosh-test-other\n", 0},
+		{"complete -r", "", 0},
+		{"complete -p", "", 0},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := run(tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+
+	if _, stderr, _ := run("complete -p gosh-test-unknown"); !strings.Contains(stderr, "gosh-test-unknown: no completion specification") {
+		t.Errorf("complete -p of an unregistered command wrote %q to stderr", stderr)
+	}
+}
@@ -0,0 +1,126 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// declare implements `declare [-rix] [NAME[=VALUE]...]`, also run as
+// typeset. -r makes each variable read-only, -i evaluates assignments to
+// it as arithmetic and -x exports it. Without names it lists the
+// variables that have attributes, or with options only those having
+// every attribute given, exported variables included.
+func declare(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	args := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+
+	var readOnly, integer, export bool
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		flags := args[0][1:]
+		args = args[1:]
+		if flags == "-" {
+			break
+		}
+		for _, flag := range flags {
+			switch flag {
+			case 'r':
+				readOnly = true
+			case 'i':
+				integer = true
+			case 'x':
+				export = true
+			default:
+				cmd.ReturnCode = 2
+				return fmt.Errorf("declare: -%c: invalid option\nUsage: declare [-rix] [NAME[=VALUE]...]", flag)
+			}
+		}
+	}
+
+	cmd.ReturnCode = 0
+	if len(args) == 0 {
+		return listDeclared(cmd, VarAttributes{ReadOnly: readOnly, Integer: integer}, export)
+	}
+
+	gs := GetGlobalState()
+	for _, arg := range args {
+		name, value, hasValue := parseAssignment(arg)
+		if !hasValue {
+			name = arg
+		}
+		if !isVariableName(name) {
+			cmd.printError("declare: `%s': not a valid identifier\n", arg)
+			cmd.ReturnCode = 1
+			continue
+		}
+
+		attributes := gs.GetVarAttributes(name)
+		if integer && !attributes.ReadOnly {
+			attributes.Integer = true
+			gs.SetVarAttributes(name, attributes)
+		}
+		if hasValue {
+			if err := setVariable(name, value); err != nil {
+				cmd.printError("declare: %v\n", err)
+				cmd.ReturnCode = 1
+				continue
+			}
+		}
+		if export && variableIsSet(name) {
+			value := lookupVariable(name)
+			gs.UnsetVar(name)
+			if err := os.Setenv(name, value); err != nil {
+				return fmt.Errorf("declare: %v", err)
+			}
+		}
+		if readOnly {
+			attributes.ReadOnly = true
+			gs.SetVarAttributes(name, attributes)
+		}
+	}
+	return nil
+}
+
+// listDeclared prints a declare command recreating each variable that has
+// attributes, or if any are given, each variable with all of want and
+// exported when export is set. A variable without a value is listed by
+// name alone.
+func listDeclared(cmd *Command, want VarAttributes, export bool) error {
+	gs := GetGlobalState()
+	names := gs.GetAttributedVarNames()
+	if export {
+		for name := range EnvSnapshot() {
+			if gs.GetVarAttributes(name) == (VarAttributes{}) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attributes := gs.GetVarAttributes(name)
+		_, exported := os.LookupEnv(name)
+		if want.Integer && !attributes.Integer || want.ReadOnly && !attributes.ReadOnly || export && !exported {
+			continue
+		}
+		flags := "-"
+		if attributes.Integer {
+			flags += "i"
+		}
+		if attributes.ReadOnly {
+			flags += "r"
+		}
+		if exported {
+			flags += "x"
+		}
+		line := fmt.Sprintf("declare %s %s", flags, name)
+		if variableIsSet(name) {
+			line += "=" + shellQuote(lookupVariable(name))
+		}
+		if _, err := fmt.Fprintln(cmd.Stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package gosh
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDeclareAttributes(t *testing.T) {
+	gs := GetGlobalState()
+	for _, name := range []string{"n", "ro", "ro_unset", "shared"} {
+		defer gs.UnsetVar(name)
+		defer gs.SetVarAttributes(name, VarAttributes{})
+	}
+	defer os.Unsetenv("shared")
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"declare -i n; n=3+4; echo $n", "7\n", 0},
+		{"typeset -i n=2*5; echo $n", "10\n", 0},
+		{"declare -r ro=fixed; echo $ro", "fixed\n", 0},
+		{"ro=changed", "", 1},
+		{"declare ro=changed", "", 1},
+		{"export ro=changed", "", 1},
+		{"read ro", "", 1},
+		{"echo a b | read -a ro", "", 1},
+		{"echo $ro", "fixed\n", 0},
+		{"shared=value; declare -x shared; env | grep ^shared=", "shared=value\n", 0},
+		{"declare", "declare -i n='10'\ndeclare -r ro='fixed'\n", 0},
+		{"printf -v ro %s changed", "", 1},
+		{"declare -r ro_unset; declare -r", "declare -r ro='fixed'\ndeclare -r ro_unset\n", 0},
+		{"declare -i", "declare -i n='10'\n", 0},
+		{"declare -x | grep ' shared='", "declare -x shared='value'\n", 0},
+		{"declare -rx", "", 0},
+		{"declare -q x", "", 2},
+		{"declare 1x=2", "", 1},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}
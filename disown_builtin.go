@@ -0,0 +1,50 @@
+package gosh
+
+import "fmt"
+
+// disown implements `disown [-h] [JOBID|PID]...`, which stops the shell
+// tracking each job, or the most recent one when none is named, so that it
+// is no longer listed, reported when it finishes or waited for. With -h
+// the jobs stay in the table and are only marked NoHUP, so that the shell
+// would not pass a SIGHUP on to them.
+func disown(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		for _, arg := range cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:] {
+			args = append(args, unquoteArg(arg))
+		}
+	}
+	noHUP := len(args) > 0 && args[0] == "-h"
+	if noHUP {
+		args = args[1:]
+	}
+	cmd.ReturnCode = 0
+
+	var jobs []*Job
+	if len(args) == 0 {
+		job, ok := cmd.JobManager.LastJob()
+		if !ok {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("current: no such job")
+		}
+		jobs = append(jobs, job)
+	}
+	for _, arg := range args {
+		job, err := findJobArg(cmd.JobManager, arg)
+		if err != nil {
+			cmd.printError("disown: %v\n", err)
+			cmd.ReturnCode = 1
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, job := range jobs {
+		if noHUP {
+			job.NoHUP = true
+		} else {
+			cmd.JobManager.RemoveJob(job.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package gosh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisownBuiltin(t *testing.T) {
+	useTempCWD(t)
+	jobManager := NewJobManager()
+	listJobs := func() string {
+		var stdout bytes.Buffer
+		if err := jobs(&Command{Stdout: &stdout, JobManager: jobManager}); err != nil {
+			t.Fatalf("jobs returned error: %v", err)
+		}
+		return stdout.String()
+	}
+
+	if stderr, code := runInJobManager(t, jobManager, "disown"); code != 1 || stderr == "" {
+		t.Errorf("disown without jobs = (%q, %d), want an error and 1", stderr, code)
+	}
+
+	runInJobManager(t, jobManager, "sleep 0.2 &")
+	runInJobManager(t, jobManager, "sleep 0.2 &")
+	if stderr, code := runInJobManager(t, jobManager, "disown -h %1"); code != 0 {
+		t.Errorf("disown -h %%1 returned %d; stderr %q", code, stderr)
+	}
+	if job, ok := jobManager.GetJob(1); !ok || !job.NoHUP {
+		t.Errorf("job 1 after disown -h = (%v, %v), want it still tracked and marked NoHUP", job, ok)
+	}
+
+	if stderr, code := runInJobManager(t, jobManager, "disown"); code != 0 {
+		t.Errorf("disown returned %d; stderr %q", code, stderr)
+	}
+	if output := listJobs(); output != "[1] Running sleep 0.2 &\n" {
+		t.Errorf("jobs after disown = %q, want only job 1", output)
+	}
+	if stderr, code := runInJobManager(t, jobManager, "disown %1 %5"); code != 1 || stderr == "" {
+		t.Errorf("disown %%1 %%5 = (%q, %d), want an error for %%5 and 1", stderr, code)
+	}
+	if output := listJobs(); output != "" {
+		t.Errorf("jobs after disowning everything = %q, want nothing", output)
+	}
+}
@@ -0,0 +1,8 @@
+package gosh
+
+import "syscall"
+
+// dupTo makes newfd refer to the same file as oldfd.
+func dupTo(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
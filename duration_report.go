@@ -0,0 +1,40 @@
+package gosh
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// commandDurationThreshold returns how long a foreground command must run
+// before its duration is reported. GOSH_CMD_DURATION_MIN is either a number
+// of seconds or a Go duration such as "1m30s"; unset or invalid turns
+// reporting off, as does a number of seconds too large for a time.Duration.
+func commandDurationThreshold() time.Duration {
+	value := lookupVariable("GOSH_CMD_DURATION_MIN")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || seconds*float64(time.Second) >= math.MaxInt64 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if threshold, err := time.ParseDuration(value); err == nil {
+		return threshold
+	}
+	return 0
+}
+
+// CommandDurationReport returns the "(took 12.3s)" note to print after a
+// foreground command that ran for duration, or "" if it finished within
+// the threshold.
+func CommandDurationReport(duration time.Duration) string {
+	threshold := commandDurationThreshold()
+	if threshold <= 0 || duration < threshold {
+		return ""
+	}
+	return fmt.Sprintf("(took %.1fs)", duration.Seconds())
+}
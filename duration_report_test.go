@@ -0,0 +1,45 @@
+package gosh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommandDurationReport(t *testing.T) {
+	tests := []struct {
+		threshold string
+		duration  time.Duration
+		expected  string
+	}{
+		{"", time.Hour, ""},
+		{"invalid", time.Hour, ""},
+		{"0", time.Hour, ""},
+		{"5", 4 * time.Second, ""},
+		{"5", 5 * time.Second, "(took 5.0s)"},
+		{"5", 12300 * time.Millisecond, "(took 12.3s)"},
+		{"0.5", 750 * time.Millisecond, "(took 0.8s)"},
+		{"1m", 59 * time.Second, ""},
+		{"1m", 90 * time.Second, "(took 90.0s)"},
+		{"1e300", time.Hour, ""},
+		{"NaN", time.Hour, ""},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("GOSH_CMD_DURATION_MIN", tt.threshold)
+		if report := CommandDurationReport(tt.duration); report != tt.expected {
+			t.Errorf("CommandDurationReport(%v) with GOSH_CMD_DURATION_MIN=%q = %q, want %q", tt.duration, tt.threshold, report, tt.expected)
+		}
+	}
+}
+
+func TestCommandDurationThresholdShellVariable(t *testing.T) {
+	t.Setenv("GOSH_CMD_DURATION_MIN", "")
+	if err := setVariable("GOSH_CMD_DURATION_MIN", "2"); err != nil {
+		t.Fatal(err)
+	}
+	defer GetGlobalState().UnsetVar("GOSH_CMD_DURATION_MIN")
+
+	if threshold := commandDurationThreshold(); threshold != 2*time.Second {
+		t.Errorf("commandDurationThreshold() = %v, want %v", threshold, 2*time.Second)
+	}
+}
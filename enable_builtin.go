@@ -0,0 +1,71 @@
+package gosh
+
+import (
+	"fmt"
+	"sort"
+)
+
+// enable implements `enable [-n] NAME...`. With -n each builtin is
+// disabled, so its name runs the executable found in PATH; without it
+// the builtins are enabled again. Without names it lists the enabled
+// builtins, the disabled ones with -n, or all of them with -a.
+func enable(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	names := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	enabled, all := true, false
+	for len(names) > 0 && (names[0] == "-n" || names[0] == "-a") {
+		if names[0] == "-n" {
+			enabled = false
+		} else {
+			all = true
+		}
+		names = names[1:]
+	}
+	if len(names) == 0 {
+		cmd.ReturnCode = 0
+		return listBuiltinStates(cmd, all, enabled)
+	}
+	if all {
+		cmd.ReturnCode = 2
+		return fmt.Errorf("Usage: enable [-a] [-n] [NAME...]")
+	}
+
+	cmd.ReturnCode = 0
+	for _, name := range names {
+		if !setBuiltinEnabled(name, enabled) {
+			cmd.printError("enable: %s: not a shell builtin\n", name)
+			cmd.ReturnCode = 1
+		}
+	}
+	return nil
+}
+
+// listBuiltinStates prints the builtins as enable commands that would
+// restore their state: every builtin when all is set, otherwise only the
+// enabled or only the disabled ones.
+func listBuiltinStates(cmd *Command, all, enabled bool) error {
+	builtinsMu.RLock()
+	names := make([]string, 0, len(builtins))
+	disabled := make(map[string]bool, len(disabledBuiltins))
+	for name := range builtins {
+		if all || disabledBuiltins[name] != enabled {
+			names = append(names, name)
+			disabled[name] = disabledBuiltins[name]
+		}
+	}
+	builtinsMu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		line := "enable " + name
+		if disabled[name] {
+			line = "enable -n " + name
+		}
+		if _, err := fmt.Fprintln(cmd.Stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
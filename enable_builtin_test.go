@@ -0,0 +1,78 @@
+package gosh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useFakeEcho puts a directory holding an echo script that prints
+// "external" first in PATH.
+func useFakeEcho(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho external\n"
+	if err := os.WriteFile(filepath.Join(dir, "echo"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write echo script: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestEnableDisablesBuiltins(t *testing.T) {
+	useFakeEcho(t)
+	defer setBuiltinEnabled("echo", true)
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"echo hi", "hi\n", 0},
+		{"enable -n echo", "", 0},
+		{"echo hi", "external\n", 0},
+		{"type -t echo", "file\n", 0},
+		{"enable echo", "", 0},
+		{"echo hi", "hi\n", 0},
+		{"enable -n gosh-no-such-builtin", "", 1},
+		{"enable -a gosh-no-such-builtin", "", 2},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}
+
+func TestPreferExternal(t *testing.T) {
+	useFakeEcho(t)
+	t.Setenv("GOSH_PREFER_EXTERNAL", "printf echo")
+
+	if stdout, _, _ := runWithOptions(t, "echo hi"); stdout != "external\n" {
+		t.Errorf("echo with GOSH_PREFER_EXTERNAL = %q, want %q", stdout, "external\n")
+	}
+	if stdout, _, _ := runWithOptions(t, "pwd"); stdout != GetGlobalState().GetCWD()+"\n" {
+		t.Errorf("pwd with GOSH_PREFER_EXTERNAL = %q, want the builtin's output", stdout)
+	}
+}
+
+func TestEnableLists(t *testing.T) {
+	defer setBuiltinEnabled("echo", true)
+	setBuiltinEnabled("echo", false)
+
+	stdout, _, code := runWithOptions(t, "enable")
+	if code != 0 || !strings.Contains(stdout, "enable cd\n") || strings.Contains(stdout, "echo") {
+		t.Errorf("enable = (%q, %d), want the enabled builtins without echo", stdout, code)
+	}
+	if stdout, _, _ := runWithOptions(t, "enable -n"); stdout != "enable -n echo\n" {
+		t.Errorf("enable -n = %q, want %q", stdout, "enable -n echo\n")
+	}
+	stdout, _, _ = runWithOptions(t, "enable -a")
+	if !strings.Contains(stdout, "enable cd\nenable clear\n") || !strings.Contains(stdout, "enable -n echo\n") {
+		t.Errorf("enable -a = %q, want every builtin with its state", stdout)
+	}
+	if lines := strings.Count(stdout, "\n"); lines != len(Builtins()) {
+		t.Errorf("enable -a listed %d builtins, want %d", lines, len(Builtins()))
+	}
+}
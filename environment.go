@@ -0,0 +1,36 @@
+package gosh
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvSnapshot returns a copy of the process environment. Only the first =
+// of each entry separates the name from its value.
+func EnvSnapshot() map[string]string {
+	snapshot := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			snapshot[name] = value
+		}
+	}
+	return snapshot
+}
+
+// EnvRestore makes the process environment match snapshot. Only the
+// variables that differ are set or unset, so a background job starting a
+// process meanwhile never sees the others missing.
+func EnvRestore(snapshot map[string]string) {
+	for name, value := range EnvSnapshot() {
+		if _, ok := snapshot[name]; !ok {
+			os.Unsetenv(name)
+		} else if snapshot[name] != value {
+			os.Setenv(name, snapshot[name])
+		}
+	}
+	for name, value := range snapshot {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+}
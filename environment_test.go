@@ -0,0 +1,80 @@
+package gosh
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEnvSnapshotAndRestore(t *testing.T) {
+	t.Setenv("GOSH_ENV_EQUALS", "a=b=c")
+	t.Setenv("GOSH_ENV_EMPTY", "")
+	t.Setenv("GOSH_ENV_TRAILING", "value=")
+
+	snapshot := EnvSnapshot()
+	for name, expected := range map[string]string{
+		"GOSH_ENV_EQUALS":   "a=b=c",
+		"GOSH_ENV_EMPTY":    "",
+		"GOSH_ENV_TRAILING": "value=",
+	} {
+		if value, ok := snapshot[name]; !ok || value != expected {
+			t.Errorf("EnvSnapshot()[%q] = (%q, %v), want (%q, true)", name, value, ok, expected)
+		}
+	}
+
+	os.Setenv("GOSH_ENV_EQUALS", "changed")
+	os.Unsetenv("GOSH_ENV_EMPTY")
+	os.Setenv("GOSH_ENV_ADDED", "x=y")
+	EnvRestore(snapshot)
+
+	if restored := EnvSnapshot(); !reflect.DeepEqual(restored, snapshot) {
+		t.Errorf("EnvSnapshot() after EnvRestore = %v, want %v", restored, snapshot)
+	}
+	if _, ok := os.LookupEnv("GOSH_ENV_ADDED"); ok {
+		t.Errorf("GOSH_ENV_ADDED survived EnvRestore")
+	}
+}
+
+func TestEnvRestoreKeepsUnchangedVariables(t *testing.T) {
+	t.Setenv("GOSH_ENV_STEADY", "kept")
+	snapshot := EnvSnapshot()
+
+	done := make(chan struct{})
+	missing := make(chan bool, 1)
+	go func() {
+		defer close(missing)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, ok := os.LookupEnv("GOSH_ENV_STEADY"); !ok {
+				missing <- true
+				return
+			}
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		os.Setenv("GOSH_ENV_ADDED", "x")
+		EnvRestore(snapshot)
+	}
+	close(done)
+	if <-missing {
+		t.Errorf("GOSH_ENV_STEADY was briefly unset while EnvRestore ran")
+	}
+}
+
+func TestSubshellRestoresEnvironment(t *testing.T) {
+	t.Setenv("GOSH_ENV_EQUALS", "a=b=c")
+
+	runEcho(t, "(export GOSH_ENV_EQUALS=x=y)")
+	runEcho(t, "(export GOSH_ENV_NEW=p=q)")
+
+	if value := os.Getenv("GOSH_ENV_EQUALS"); value != "a=b=c" {
+		t.Errorf("GOSH_ENV_EQUALS = %q after a subshell, want %q", value, "a=b=c")
+	}
+	if _, ok := os.LookupEnv("GOSH_ENV_NEW"); ok {
+		t.Errorf("variable exported in a subshell leaked into the shell")
+	}
+}
@@ -0,0 +1,38 @@
+package gosh
+
+import "strings"
+
+// eval implements `eval ARG...`. The arguments, with their quotes
+// removed, are joined with spaces and the result is run as a command in
+// the current shell, so its assignments and cd persist. Like any command
+// gosh runs internally, it runs raw, without aliases. The status is that
+// of the command, or 0 when there is nothing to run.
+func eval(cmd *Command) error {
+	cmd.ReturnCode = 0
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	parts := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	words := make([]string, len(parts))
+	for i, part := range parts {
+		words[i] = removeQuotes(part)
+	}
+	input := strings.Join(words, " ")
+	if strings.TrimSpace(input) == "" {
+		return nil
+	}
+
+	evalCmd, err := NewCommandRaw(input, cmd.JobManager)
+	if err != nil {
+		cmd.ReturnCode = 2
+		return err
+	}
+	evalCmd.Stdin = cmd.Stdin
+	evalCmd.Stdout = cmd.Stdout
+	evalCmd.Stderr = cmd.Stderr
+	evalCmd.subshell = cmd.subshell
+	evalCmd.background = cmd.background
+	evalCmd.Run()
+	cmd.ReturnCode = evalCmd.ReturnCode
+	return nil
+}
@@ -0,0 +1,30 @@
+package gosh
+
+import "testing"
+
+func TestEvalBuiltin(t *testing.T) {
+	useTempCWD(t)
+	gs := GetGlobalState()
+	for _, name := range []string{"cmd", "target", "x", "y"} {
+		defer gs.UnsetVar(name)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{`cmd="echo stored command"; eval "$cmd"`, "stored command\n", 0},
+		{`target=x; eval $target=5; echo $x`, "5\n", 0},
+		{`y=inner; eval 'echo $y'`, "inner\n", 0},
+		{`eval "echo 'a  b' | cat"`, "a  b\n", 0},
+		{`eval ls /gosh-no-such-dir`, "", 2},
+		{`eval`, "", 0},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"gosh/parser"
+)
+
+// execProcess is replaced in tests so a failed exec can be observed.
+var execProcess = syscall.Exec
+
+// execCommand implements `exec [COMMAND [ARG...]]`. The command replaces
+// the shell process, keeping its environment and the shell's working
+// directory. Redirections on exec are applied to the shell itself first,
+// so without a command they stay in effect for the rest of the session.
+// If the command cannot be run the shell carries on with status 127 when
+// it is not found and 126 otherwise. Subshells share the shell's process,
+// so exec is refused inside one.
+func execCommand(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	if cmd.subshell {
+		cmd.ReturnCode = 1
+		return fmt.Errorf("not supported in a subshell")
+	}
+	simpleCmd := cmd.AndCommands[0].Pipelines[0].Commands[0]
+	_, args, redirections := parser.ProcessCommand2(simpleCmd)
+	cmd.ReturnCode = 0
+
+	if err := redirectShell(cmd, redirections); err != nil {
+		cmd.ReturnCode = 1
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	argv := make([]string, len(args))
+	for i, arg := range args {
+		argv[i] = unquoteArg(arg)
+	}
+	path, ok := findExecutable(argv[0])
+	if !ok {
+		cmd.ReturnCode = 127
+		return fmt.Errorf("%s: not found", argv[0])
+	}
+	if err := os.Chdir(GetGlobalState().GetCWD()); err != nil {
+		cmd.ReturnCode = 126
+		return err
+	}
+	if err := execProcess(resolvePath(GetGlobalState().GetCWD(), path), argv, childEnvironment()); err != nil {
+		cmd.ReturnCode = 126
+		return fmt.Errorf("%s: %v", argv[0], err)
+	}
+	return nil
+}
+
+// redirectShell points the shell's own standard input and output at the
+// files the executor opened for exec's redirections.
+func redirectShell(cmd *Command, redirections parser.Redirections) error {
+	if redirections.Input != "" {
+		if file, ok := cmd.Stdin.(*os.File); ok {
+			if err := dupTo(int(file.Fd()), int(os.Stdin.Fd())); err != nil {
+				return err
+			}
+		}
+	}
+	if len(redirections.Outputs) > 0 {
+		if file, ok := cmd.Stdout.(*os.File); ok {
+			if err := dupTo(int(file.Fd()), int(os.Stdout.Fd())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
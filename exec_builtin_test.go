@@ -0,0 +1,110 @@
+package gosh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestExecHelperProcess runs the command in GOSH_EXEC_HELPER when the test
+// binary is started by TestExecReplacesProcess. It exits 3 if the shell
+// is still running afterwards.
+func TestExecHelperProcess(t *testing.T) {
+	input := os.Getenv("GOSH_EXEC_HELPER")
+	if input == "" {
+		t.Skip("only run as a helper process")
+	}
+	cmd, err := NewCommand(input, NewJobManager())
+	if err != nil {
+		os.Exit(4)
+	}
+	cmd.Run()
+	os.Exit(3)
+}
+
+func runExecHelper(t *testing.T, input string) (string, error) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("exec needs a Unix system")
+	}
+	helper := exec.Command(os.Args[0], "-test.run=^TestExecHelperProcess$")
+	helper.Env = append(os.Environ(), "GOSH_EXEC_HELPER="+input)
+	output, err := helper.Output()
+	return string(output), err
+}
+
+func TestExecReplacesProcess(t *testing.T) {
+	if _, err := os.Stat("/bin/true"); err != nil {
+		t.Skip("no /bin/true")
+	}
+	if _, err := runExecHelper(t, "exec /bin/true"); err != nil {
+		t.Errorf("exec /bin/true ended with %v, want a clean exit from true", err)
+	}
+
+	output, err := runExecHelper(t, "exec /bin/false")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("exec /bin/false ended with %v, want status 1 from false", err)
+	}
+
+	output, err = runExecHelper(t, "exec echo replaced; echo not reached")
+	if err != nil || output != "replaced\n" {
+		t.Errorf("exec echo = (%q, %v), want only the output of echo", output, err)
+	}
+}
+
+func TestExecRedirectsShell(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	output, _ := runExecHelper(t, "exec > "+log+"; echo first; ls -d /")
+	if strings.Contains(output, "first") {
+		t.Errorf("stdout after exec > log = %q, want nothing", output)
+	}
+	if data, err := os.ReadFile(log); err != nil || string(data) != "first\n/\n" {
+		t.Errorf("log = (%q, %v), want the output of the commands after exec", data, err)
+	}
+}
+
+func TestExecRefusedInSubshell(t *testing.T) {
+	dir := useTempCWD(t)
+	execProcess = func(string, []string, []string) error {
+		t.Fatal("exec in a subshell replaced the shell")
+		return nil
+	}
+	defer func() { execProcess = syscall.Exec }()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(exec ls); echo $?", "1\n"},
+		{"echo x$(exec ls)y", "xy\n"},
+		{"echo x$(exec > log)y; echo still here", "xy\nstill here\n"},
+	}
+	for _, tt := range tests {
+		stdout, stderr, _ := runWithOptions(t, tt.input)
+		if stdout != tt.expected || !strings.Contains(stderr, "subshell") {
+			t.Errorf("%q = %q, want %q and an error; stderr %q", tt.input, stdout, tt.expected, stderr)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "log")); len(data) != 0 {
+		t.Errorf("log = %q, want the shell's output left alone", data)
+	}
+}
+
+func TestExecFailureKeepsShell(t *testing.T) {
+	stdout, stderr, code := runWithOptions(t, "exec gosh-no-such-command; echo still here")
+	if code != 0 || stdout != "still here\n" || !strings.Contains(stderr, "not found") {
+		t.Errorf("exec of a missing command = (%q, %q, %d), want the shell to carry on", stdout, stderr, code)
+	}
+
+	execProcess = func(string, []string, []string) error { return os.ErrPermission }
+	defer func() { execProcess = syscall.Exec }()
+	if _, _, code := runWithOptions(t, "exec ls"); code != 126 {
+		t.Errorf("exec that fails returned %d, want 126", code)
+	}
+}
@@ -0,0 +1,55 @@
+package gosh
+
+import (
+	"sync"
+
+	"gosh/parser"
+)
+
+// ExecHook observes command execution. Before is called once a simple
+// command has been expanded and is about to run, and After once its exit
+// code is known. Hooks may modify cmd.Parts in Before to change what runs.
+type ExecHook interface {
+	Before(cmd *parser.SimpleCommand)
+	After(cmd *parser.SimpleCommand, code int)
+}
+
+var execHooks []ExecHook
+var execHooksMu sync.RWMutex
+
+// RegisterExecHook adds a hook that runs around every command.
+func RegisterExecHook(hook ExecHook) {
+	execHooksMu.Lock()
+	defer execHooksMu.Unlock()
+	execHooks = append(execHooks, hook)
+}
+
+// UnregisterExecHook removes a previously registered hook.
+func UnregisterExecHook(hook ExecHook) {
+	execHooksMu.Lock()
+	defer execHooksMu.Unlock()
+	for i, h := range execHooks {
+		if h == hook {
+			execHooks = append(execHooks[:i:i], execHooks[i+1:]...)
+			return
+		}
+	}
+}
+
+func currentExecHooks() []ExecHook {
+	execHooksMu.RLock()
+	defer execHooksMu.RUnlock()
+	return execHooks
+}
+
+func runBeforeHooks(cmd *parser.SimpleCommand) {
+	for _, hook := range currentExecHooks() {
+		hook.Before(cmd)
+	}
+}
+
+func runAfterHooks(cmd *parser.SimpleCommand, code int) {
+	for _, hook := range currentExecHooks() {
+		hook.After(cmd, code)
+	}
+}
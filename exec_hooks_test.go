@@ -0,0 +1,70 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"gosh/parser"
+)
+
+type recordingHook struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordingHook) Before(cmd *parser.SimpleCommand) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, "before "+cmd.Parts[0])
+}
+
+func (h *recordingHook) After(cmd *parser.SimpleCommand, code int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, fmt.Sprintf("after %s %d", cmd.Parts[0], code))
+}
+
+func TestExecHooks(t *testing.T) {
+	useTempCWD(t)
+	hook := &recordingHook{}
+	RegisterExecHook(hook)
+	defer UnregisterExecHook(hook)
+
+	cmd, err := NewCommand("echo hi | false && test 1 -eq 2", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+	cmd.Run()
+
+	expected := []string{"before echo", "after echo 0", "before false", "after false 1"}
+	if !reflect.DeepEqual(hook.events, expected) {
+		t.Errorf("hook events = %q, want %q", hook.events, expected)
+	}
+
+	hook.events = nil
+	cmd, err = NewCommand("test 1 -eq 2", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Run()
+	expected = []string{"before test", "after test 1"}
+	if !reflect.DeepEqual(hook.events, expected) {
+		t.Errorf("hook events = %q, want %q", hook.events, expected)
+	}
+
+	UnregisterExecHook(hook)
+	hook.events = nil
+	cmd, err = NewCommand("test 1 -eq 1", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Run()
+	if len(hook.events) != 0 {
+		t.Errorf("unregistered hook recorded %q", hook.events)
+	}
+}
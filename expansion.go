@@ -0,0 +1,296 @@
+package gosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// expandWords expands the words of a parsed simple command: braces,
+// parameters, arithmetic and command substitutions, then field splitting,
+// pathname expansion and quote removal. The text an expansion produces is
+// never scanned again, so the values of variables and the output of
+// commands are data rather than shell syntax. Assignments, as judged by
+// isAssignmentWord, are neither split nor globbed. Each resulting word is
+// passed through quoteWord, since builtins remove quotes themselves.
+func (cmd *Command) expandWords(words []string, glob bool) ([]string, error) {
+	var expanded []string
+	for i, word := range words {
+		if isAssignmentWord(words, i) {
+			name, value, _ := strings.Cut(word, "=")
+			fields, err := cmd.expandWord(value, false)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, name+"="+quoteWord(strings.Join(fields, "")))
+			continue
+		}
+		for _, braced := range expandBraceWords([]string{word}) {
+			fields, err := cmd.expandWordFields(braced, glob)
+			if err != nil {
+				return nil, err
+			}
+			for _, field := range fields {
+				expanded = append(expanded, quoteWord(field))
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// quoteWord quotes an expanded word so that removing its quotes, the way
+// builtins do with unquoteArg or removeQuotes, gives back word itself.
+func quoteWord(word string) string {
+	switch {
+	case !strings.ContainsAny(word, `'"\`):
+		return word
+	case !strings.Contains(word, "'"):
+		return "'" + word + "'"
+	case !strings.ContainsAny(word, "\"\\$`"):
+		return `"` + word + `"`
+	}
+	return shellQuote(word)
+}
+
+// expandWord expands a single word into the text of its fields, without
+// pathname expansion. With split unset, as for the value of an
+// assignment, the result is always one field.
+func (cmd *Command) expandWord(word string, split bool) ([]string, error) {
+	w := wordExpansion{cmd: cmd, split: split}
+	if err := w.expand(word); err != nil {
+		return nil, err
+	}
+	if !split {
+		return []string{w.text.String()}, nil
+	}
+	w.endField()
+	fields := make([]string, len(w.fields))
+	for i, field := range w.fields {
+		fields[i] = field.text
+	}
+	return fields, nil
+}
+
+// expandWordFields expands a word like expandWord, then replaces each
+// field holding an unquoted *, ? or [ with the paths it matches, if glob
+// is set and it matches any.
+func (cmd *Command) expandWordFields(word string, glob bool) ([]string, error) {
+	w := wordExpansion{cmd: cmd, split: true}
+	if err := w.expand(word); err != nil {
+		return nil, err
+	}
+	w.endField()
+	var fields []string
+	for _, field := range w.fields {
+		if glob && field.glob {
+			if matches := matchPattern(field.pattern); len(matches) > 0 {
+				fields = append(fields, matches...)
+				continue
+			}
+		}
+		fields = append(fields, field.text)
+	}
+	return fields, nil
+}
+
+// wordExpansion collects the fields of a word as it is expanded.
+type wordExpansion struct {
+	cmd   *Command
+	split bool
+
+	fields []expandedField
+	// text and pattern hold the current field: its text, and the same
+	// text as a pathname pattern, with quoted pattern characters escaped.
+	text, pattern strings.Builder
+	// glob is set once the current field holds an unquoted pattern
+	// character, and keep once it holds anything that makes it a field
+	// even when empty, such as quotes. noParams is set by a "$@" with no
+	// positional parameters, which makes no field of its own.
+	glob, keep, noParams bool
+}
+
+type expandedField struct {
+	text, pattern string
+	glob          bool
+}
+
+// endField ends the current field. An empty field is dropped unless
+// something, such as a pair of quotes, made it a field.
+func (w *wordExpansion) endField() {
+	if w.text.Len() > 0 || w.keep && !w.noParams {
+		w.fields = append(w.fields, expandedField{text: w.text.String(), pattern: w.pattern.String(), glob: w.glob})
+	}
+	w.text.Reset()
+	w.pattern.Reset()
+	w.glob, w.keep, w.noParams = false, false, false
+}
+
+// quoted adds text that came from quotes or escapes, and is taken
+// literally.
+func (w *wordExpansion) quoted(text string) {
+	w.text.WriteString(text)
+	for _, r := range text {
+		if strings.ContainsRune(`*?[\`, r) {
+			w.pattern.WriteByte('\\')
+		}
+		w.pattern.WriteRune(r)
+	}
+	w.keep = true
+}
+
+// unquoted adds unquoted text, whose pattern characters are live.
+func (w *wordExpansion) unquoted(text string) {
+	w.text.WriteString(text)
+	w.pattern.WriteString(text)
+	if strings.ContainsAny(text, "*?[") {
+		w.glob = true
+	}
+}
+
+// expansion adds the result of an unquoted expansion, split into fields
+// on IFS.
+func (w *wordExpansion) expansion(value string) {
+	ifs := currentIFS()
+	if !w.split || ifs == "" {
+		w.unquoted(value)
+		return
+	}
+	if r, _ := utf8.DecodeRuneInString(value); value != "" && strings.ContainsRune(ifs, r) {
+		w.endField()
+	}
+	for i, field := range splitByIFS(value, ifs) {
+		if i > 0 {
+			w.endField()
+		}
+		w.unquoted(field)
+		w.keep = true
+	}
+	if r, _ := utf8.DecodeLastRuneInString(value); value != "" && strings.ContainsRune(ifs, r) {
+		w.endField()
+	}
+}
+
+// expand scans word, adding its literal text and the results of its
+// expansions to the fields.
+func (w *wordExpansion) expand(word string) error {
+	double := false
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '\'' && !double:
+			end := strings.IndexByte(word[i+1:], '\'')
+			if end < 0 {
+				w.unquoted(word[i:])
+				return nil
+			}
+			w.quoted(word[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			double = !double
+			w.keep = true
+		case c == '\\' && i+1 < len(word) && (!double || strings.IndexByte("$`\"\\", word[i+1]) >= 0):
+			i++
+			w.quoted(word[i : i+1])
+		case c == '$' && double && w.split && (strings.HasPrefix(word[i:], "$@") || strings.HasPrefix(word[i:], "${@}")):
+			w.positionalParams()
+			if word[i+1] == '{' {
+				i += len("${@}") - 1
+			} else {
+				i++
+			}
+		case c == '$':
+			value, end, ok, err := w.cmd.expandDollar(word, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				w.add(double, "$")
+				continue
+			}
+			if double {
+				w.quoted(value)
+			} else {
+				w.expansion(value)
+			}
+			i = end
+		default:
+			w.add(double, word[i:i+1])
+		}
+	}
+	return nil
+}
+
+// positionalParams adds "$@": each positional parameter as a field of its
+// own, with the text before and after it joined to the first and last.
+func (w *wordExpansion) positionalParams() {
+	params := GetGlobalState().GetPositionalParams()
+	if len(params) == 0 {
+		w.noParams = true
+	}
+	for i, param := range params {
+		if i > 0 {
+			w.endField()
+		}
+		w.quoted(param)
+	}
+}
+
+func (w *wordExpansion) add(double bool, text string) {
+	if double {
+		w.quoted(text)
+	} else {
+		w.unquoted(text)
+	}
+}
+
+// expandDollar expands the $ expansion at word[start], returning its value
+// and the index of its last byte. ok is false for a $ that starts no
+// expansion and stands for itself.
+func (cmd *Command) expandDollar(word string, start int) (value string, end int, ok bool, err error) {
+	rest := word[start+1:]
+	switch {
+	case strings.HasPrefix(rest, "(("):
+		end = arithmeticEnd(word, start+3)
+		if end < 0 {
+			return "", 0, false, fmt.Errorf("unterminated arithmetic expansion: %s", word[start:])
+		}
+		expr, err := expandParameters(word[start+3 : end])
+		if err != nil {
+			return "", 0, false, err
+		}
+		n, err := EvalArithmetic(expr)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return strconv.FormatInt(n, 10), end + 1, true, nil
+	case strings.HasPrefix(rest, "("):
+		end = substitutionEnd(word, start+2)
+		if end < 0 {
+			return "", 0, false, fmt.Errorf("unterminated command substitution: %s", word[start:])
+		}
+		return cmd.commandOutput(word[start+2 : end]), end, true, nil
+	case strings.HasPrefix(rest, "{"):
+		end = parameterEnd(word, start+2)
+		if end < 0 {
+			return "", 0, false, fmt.Errorf("%s: bad substitution", word[start:])
+		}
+		value, err = expandParameter(word[start+2:end], 0)
+		return value, end, err == nil, err
+	case rest != "" && strings.IndexByte("$?!#@*", rest[0]) >= 0:
+		return lookupVariable(rest[:1]), start + 1, true, nil
+	case rest != "" && '1' <= rest[0] && rest[0] <= '9':
+		if err := checkBound(rest[:1]); err != nil {
+			return "", 0, false, err
+		}
+		return lookupVariable(rest[:1]), start + 1, true, nil
+	}
+	name := variableNamePrefix.FindString(rest)
+	if name == "" {
+		return "", 0, false, nil
+	}
+	if err := checkBound(name); err != nil {
+		return "", 0, false, err
+	}
+	return lookupVariable(name), start + len(name), true, nil
+}
@@ -0,0 +1,140 @@
+package gosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gosh/parser"
+)
+
+// getoptsState remembers where getopts is inside a group of options such
+// as -abc, which POSIX leaves to the shell. It only applies while OPTIND
+// still holds the value getopts last gave it, so resetting OPTIND=1 starts
+// over.
+var getoptsState struct {
+	sync.Mutex
+	optind int
+	char   int
+}
+
+// getopts implements `getopts OPTSTRING NAME [ARG...]`. Each call sets
+// NAME to the next option in the arguments, or the positional parameters
+// when there are none, and OPTIND to the index of the next argument to
+// look at. A letter followed by ':' in OPTSTRING takes an argument, which
+// is stored in OPTARG. An unknown option or a missing argument sets NAME
+// to '?' and prints an error, unless OPTSTRING starts with ':', in which
+// case OPTARG holds the option letter and a missing argument sets NAME to
+// ':'. The status is 1 once the options are exhausted.
+func getopts(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		_, args, _ = parser.ProcessCommand2(cmd.AndCommands[0].Pipelines[0].Commands[0])
+	}
+	if len(args) < 2 {
+		cmd.ReturnCode = 2
+		return fmt.Errorf("Usage: getopts OPTSTRING NAME [ARG...]")
+	}
+	optstring, name := unquoteArg(args[0]), args[1]
+	if !isVariableName(name) {
+		cmd.ReturnCode = 2
+		return fmt.Errorf("`%s': not a valid identifier", name)
+	}
+	operands := GetGlobalState().GetPositionalParams()
+	if len(args) > 2 {
+		operands = make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			operands[i] = unquoteArg(arg)
+		}
+	}
+
+	optind, err := strconv.Atoi(lookupVariable("OPTIND"))
+	if err != nil || optind < 1 {
+		optind = 1
+	}
+	getoptsState.Lock()
+	defer getoptsState.Unlock()
+	char := getoptsState.char
+	if getoptsState.optind != optind || char < 1 {
+		char = 1
+	}
+
+	option, optarg, hasOptarg, next, nextChar := nextOption(optstring, operands, optind, char)
+	if option == "" {
+		GetGlobalState().UnsetVar("OPTARG")
+		cmd.ReturnCode = 1
+		return finishGetopts(name, "?", next, nextChar)
+	}
+
+	silent := strings.HasPrefix(optstring, ":")
+	switch {
+	case option == "?" && !silent:
+		cmd.printError("getopts: illegal option -- %s\n", optarg)
+		hasOptarg = false
+	case option == ":" && !silent:
+		cmd.printError("getopts: option requires an argument -- %s\n", optarg)
+		option, hasOptarg = "?", false
+	}
+	if hasOptarg {
+		if err := setVariable("OPTARG", optarg); err != nil {
+			return err
+		}
+	} else {
+		GetGlobalState().UnsetVar("OPTARG")
+	}
+	cmd.ReturnCode = 0
+	return finishGetopts(name, option, next, nextChar)
+}
+
+// nextOption finds the option at character char of operand optind (both
+// counted from 1). It returns the option letter, or "?" for an unknown
+// one and ":" for a missing argument, with the letter as the optarg. The
+// option is "" when there are no more. next and nextChar say where to look
+// on the following call.
+func nextOption(optstring string, operands []string, optind, char int) (option, optarg string, hasOptarg bool, next, nextChar int) {
+	if optind > len(operands) {
+		return "", "", false, optind, 1
+	}
+	arg := operands[optind-1]
+	if arg == "--" {
+		return "", "", false, optind + 1, 1
+	}
+	if len(arg) < 2 || arg[0] != '-' || char >= len(arg) {
+		return "", "", false, optind, 1
+	}
+
+	letter := arg[char]
+	next, nextChar = optind, char+1
+	if nextChar >= len(arg) {
+		next, nextChar = optind+1, 1
+	}
+
+	i := strings.IndexByte(optstring, letter)
+	if letter == ':' || i < 0 {
+		return "?", string(letter), true, next, nextChar
+	}
+	if i+1 >= len(optstring) || optstring[i+1] != ':' {
+		return string(letter), "", false, next, nextChar
+	}
+
+	// The argument is the rest of this operand or the whole next one.
+	if char+1 < len(arg) {
+		return string(letter), arg[char+1:], true, optind + 1, 1
+	}
+	if optind < len(operands) {
+		return string(letter), operands[optind], true, optind + 2, 1
+	}
+	return ":", string(letter), true, optind + 1, 1
+}
+
+// finishGetopts sets NAME and OPTIND and remembers the position within
+// the current operand for the next call. It must be called with
+// getoptsState locked.
+func finishGetopts(name, option string, optind, char int) error {
+	getoptsState.optind, getoptsState.char = optind, char
+	if err := setVariable(name, option); err != nil {
+		return err
+	}
+	return setVariable("OPTIND", strconv.Itoa(optind))
+}
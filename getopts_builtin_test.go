@@ -0,0 +1,54 @@
+package gosh
+
+import "testing"
+
+func TestGetopts(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.SetPositionalParams(nil)
+	for _, name := range []string{"opt", "OPTARG", "OPTIND", "seen"} {
+		defer gs.UnsetVar(name)
+	}
+
+	loop := `OPTIND=1; seen=; while getopts "ab:c" opt; do seen="$seen $opt=$OPTARG"; done; echo $seen $OPTIND`
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"-a", "-b", "value", "-c", "file"}, "a= b=value c= 5\n"},
+		{[]string{"-ac", "-bvalue", "file"}, "a= c= b=value 3\n"},
+		{[]string{"-a", "--", "-c"}, "a= 3\n"},
+		{[]string{"file", "-a"}, "1\n"},
+		{[]string{"-x", "-a"}, "?= a= 3\n"},
+		{[]string{"-cb"}, "c= ?= 2\n"},
+		{nil, "1\n"},
+	}
+	for _, tt := range tests {
+		gs.SetPositionalParams(tt.params)
+		stdout, _, _ := runWithOptions(t, loop)
+		if stdout != tt.expected {
+			t.Errorf("getopts over %q = %q, want %q", tt.params, stdout, tt.expected)
+		}
+	}
+}
+
+func TestGetoptsSilentAndExplicitArguments(t *testing.T) {
+	gs := GetGlobalState()
+	for _, name := range []string{"opt", "OPTARG", "OPTIND", "seen"} {
+		defer gs.UnsetVar(name)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`OPTIND=1; seen=; while getopts ":ab:" opt -x -b; do seen="$seen $opt=$OPTARG"; done; echo $seen`, "?=x :=b\n"},
+		{`OPTIND=1; getopts "b:" opt -b; echo $opt $OPTIND`, "? 2\n"},
+		{`OPTIND=1; getopts "a" opt extra; echo $?`, "1\n"},
+	}
+	for _, tt := range tests {
+		stdout, _, _ := runWithOptions(t, tt.input)
+		if stdout != tt.expected {
+			t.Errorf("%q = %q, want %q", tt.input, stdout, tt.expected)
+		}
+	}
+}
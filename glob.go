@@ -5,22 +5,36 @@ import (
 	"strings"
 )
 
+// ExpandWildcards replaces each argument containing * or ? with the paths
+// it matches. Relative patterns are matched against the shell's working
+// directory and the matches stay relative.
 func ExpandWildcards(args []string) []string {
 	var expandedArgs []string
-
 	for _, arg := range args {
 		if strings.ContainsAny(arg, "*?") {
-			matches, err := filepath.Glob(arg)
-			if err != nil || len(matches) == 0 {
-				// If there's an error or no matches, use the original argument
-				expandedArgs = append(expandedArgs, arg)
-			} else {
+			if matches := matchPattern(arg); len(matches) > 0 {
 				expandedArgs = append(expandedArgs, matches...)
+				continue
 			}
-		} else {
-			expandedArgs = append(expandedArgs, arg)
 		}
+		// If there's an error or no matches, use the original argument
+		expandedArgs = append(expandedArgs, arg)
 	}
-
 	return expandedArgs
 }
+
+// matchPattern returns the paths matching a pathname pattern, relative
+// to the shell's working directory unless the pattern is absolute.
+func matchPattern(pattern string) []string {
+	cwd := GetGlobalState().GetCWD()
+	matches, err := filepath.Glob(resolvePath(cwd, pattern))
+	if err != nil {
+		return nil
+	}
+	if !filepath.IsAbs(pattern) {
+		for i, match := range matches {
+			matches[i], _ = filepath.Rel(cwd, match)
+		}
+	}
+	return matches
+}
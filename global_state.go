@@ -8,6 +8,17 @@ import (
 type GlobalState struct {
 	CWD         string
 	PreviousDir string
+	SourceFile  string
+	LineNumber  int
+	PipeStatus  []int
+	LastBgPID   int
+	Params      []string
+	Variables   map[string]string
+	Arrays      map[string][]string
+	Attributes  map[string]VarAttributes
+	Options     map[string]bool
+	ChpwdHooks  []string
+	SessionID   int64
 	mu          sync.RWMutex
 }
 
@@ -20,6 +31,10 @@ func GetGlobalState() *GlobalState {
 		globalState = &GlobalState{
 			CWD:         cwd,
 			PreviousDir: cwd,
+			Variables:   make(map[string]string),
+			Arrays:      make(map[string][]string),
+			Attributes:  make(map[string]VarAttributes),
+			Options:     make(map[string]bool),
 		}
 	})
 	return globalState
@@ -43,3 +58,198 @@ func (gs *GlobalState) GetPreviousDir() string {
 	defer gs.mu.RUnlock()
 	return gs.PreviousDir
 }
+
+// SetSourceLocation records the file and line currently being executed by
+// `source`. An empty file means commands are coming from the prompt.
+func (gs *GlobalState) SetSourceLocation(file string, line int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.SourceFile = file
+	gs.LineNumber = line
+}
+
+func (gs *GlobalState) GetSourceLocation() (string, int) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.SourceFile, gs.LineNumber
+}
+
+// SetPipeStatus records the exit code of every stage of the last pipeline.
+func (gs *GlobalState) SetPipeStatus(codes []int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.PipeStatus = append([]int(nil), codes...)
+}
+
+func (gs *GlobalState) GetPipeStatus() []int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]int(nil), gs.PipeStatus...)
+}
+
+// SetLastBackgroundPID records the PID of the last command started in the
+// background, which $! expands to.
+func (gs *GlobalState) SetLastBackgroundPID(pid int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.LastBgPID = pid
+}
+
+func (gs *GlobalState) GetLastBackgroundPID() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.LastBgPID
+}
+
+// SetPositionalParams replaces the positional parameters $1, $2 and so on.
+func (gs *GlobalState) SetPositionalParams(params []string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Params = append([]string(nil), params...)
+}
+
+func (gs *GlobalState) GetPositionalParams() []string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]string(nil), gs.Params...)
+}
+
+// GetLastExitStatus returns the exit code of the last pipeline, which is
+// the code of its final stage.
+func (gs *GlobalState) GetLastExitStatus() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if len(gs.PipeStatus) == 0 {
+		return 0
+	}
+	return gs.PipeStatus[len(gs.PipeStatus)-1]
+}
+
+// SetVar sets a shell variable. Shell variables are visible to expansion
+// but, unlike exported variables, are not passed to child processes.
+func (gs *GlobalState) SetVar(name, value string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.Arrays, name)
+	gs.Variables[name] = value
+}
+
+func (gs *GlobalState) GetVar(name string) (string, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	value, ok := gs.Variables[name]
+	return value, ok
+}
+
+// GetVarNames returns the names of all shell variables.
+func (gs *GlobalState) GetVarNames() []string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	names := make([]string, 0, len(gs.Variables))
+	for name := range gs.Variables {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (gs *GlobalState) UnsetVar(name string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.Variables, name)
+	delete(gs.Arrays, name)
+}
+
+// SetArray makes name an indexed array holding elements, replacing any
+// shell variable of that name.
+func (gs *GlobalState) SetArray(name string, elements []string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.Variables, name)
+	gs.Arrays[name] = append([]string(nil), elements...)
+}
+
+func (gs *GlobalState) GetArray(name string) ([]string, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	elements, ok := gs.Arrays[name]
+	return append([]string(nil), elements...), ok
+}
+
+// VarAttributes are the attributes declare gives a variable.
+type VarAttributes struct {
+	ReadOnly bool
+	Integer  bool
+}
+
+func (gs *GlobalState) SetVarAttributes(name string, attributes VarAttributes) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if attributes == (VarAttributes{}) {
+		delete(gs.Attributes, name)
+		return
+	}
+	gs.Attributes[name] = attributes
+}
+
+func (gs *GlobalState) GetVarAttributes(name string) VarAttributes {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Attributes[name]
+}
+
+// GetAttributedVarNames returns the names of the variables that have
+// attributes.
+func (gs *GlobalState) GetAttributedVarNames() []string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	names := make([]string, 0, len(gs.Attributes))
+	for name := range gs.Attributes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// SetOption turns a shell option such as "posix" on or off.
+func (gs *GlobalState) SetOption(name string, enabled bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Options[name] = enabled
+}
+
+func (gs *GlobalState) GetOption(name string) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Options[name]
+}
+
+// SetSessionID records the history session of this shell.
+func (gs *GlobalState) SetSessionID(id int64) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.SessionID = id
+}
+
+func (gs *GlobalState) GetSessionID() int64 {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.SessionID
+}
+
+// AddChpwdHook registers a command to run whenever cd changes directory.
+func (gs *GlobalState) AddChpwdHook(command string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.ChpwdHooks = append(gs.ChpwdHooks, command)
+}
+
+func (gs *GlobalState) GetChpwdHooks() []string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]string(nil), gs.ChpwdHooks...)
+}
+
+func (gs *GlobalState) ClearChpwdHooks() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.ChpwdHooks = nil
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"unicode"
 )
 
 // LispValue represents any Lisp value
@@ -444,10 +445,50 @@ func ExecuteGoshLisp(input string) (interface{}, error) {
 	return Eval(expr, globalEnv)
 }
 
-// IsLispExpression checks if a given string is a Lisp expression
+// lispSpecialForms are evaluated by Eval itself rather than being bound in
+// the global environment.
+var lispSpecialForms = map[LispSymbol]bool{
+	"define": true,
+	"if":     true,
+	"lambda": true,
+	"quote":  true,
+	"set!":   true,
+	"begin":  true,
+	"cond":   true,
+}
+
+// IsLispExpression checks if a given string is a Lisp expression. Besides
+// being parenthesized, the expression must start with a special form, a
+// symbol bound in the global environment or a nested list, so that shell
+// syntax such as the subshell (echo hi) is not mistaken for Lisp.
 func IsLispExpression(cmdString string) bool {
 	trimmed := strings.TrimSpace(cmdString)
-	return strings.HasPrefix(trimmed, "(") && strings.HasSuffix(trimmed, ")")
+	if !strings.HasPrefix(trimmed, "(") || !strings.HasSuffix(trimmed, ")") {
+		return false
+	}
+
+	body := strings.TrimSpace(trimmed[1:])
+	if body == ")" || strings.HasPrefix(body, "(") {
+		return true
+	}
+	fields := strings.FieldsFunc(body, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')'
+	})
+	if len(fields) == 0 {
+		return false
+	}
+	head := LispSymbol(fields[0])
+	if lispSpecialForms[head] {
+		return true
+	}
+
+	envMutex.Lock()
+	defer envMutex.Unlock()
+	if globalEnv == nil {
+		globalEnv = SetupGlobalEnvironment()
+	}
+	_, ok := globalEnv.Get(head)
+	return ok
 }
 
 func evalLoop(args []LispValue, env *Environment) (LispValue, error) {
@@ -0,0 +1,217 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Guarded commands are the ones `guard` has been given. While the safe-rm
+// option is set, a command matching a guard only runs once the user
+// confirms it. A command matches when, either as typed or once expanded,
+// it runs a program of the same base name, with every option of the guard
+// and the guard's other words first among its own. Short options are
+// compared letter by letter, so rm -fr / and /bin/rm -r -f / both match
+// a guard on rm -rf /.
+//
+// This is a safety net against slips, not a sandbox. Only the command
+// itself is looked at, so rm run through another program, as in sudo rm
+// or xargs rm, or from a script is not matched, and neither is an option
+// spelled differently, such as --recursive for -r.
+var (
+	guards   [][]string
+	guardsMu sync.Mutex
+)
+
+// openGuardInput opens the terminal confirmations are read from, so they
+// never consume a script's standard input. Tests replace it.
+var openGuardInput = func() (io.ReadCloser, error) {
+	return os.Open("/dev/tty")
+}
+
+func addGuard(words []string) {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	for _, guard := range guards {
+		if equalWords(guard, words) {
+			return
+		}
+	}
+	guards = append(guards, words)
+}
+
+func removeGuard(words []string) bool {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	for i, guard := range guards {
+		if equalWords(guard, words) {
+			guards = append(guards[:i:i], guards[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// matchGuard reports whether the command made of words matches a guard.
+// Assignments before the command are skipped.
+func matchGuard(words []string) bool {
+	for len(words) > 0 && isAssignmentWord(words, 0) {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return false
+	}
+	command := splitGuardWords(words)
+
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	for _, guard := range guards {
+		if splitGuardWords(guard).covers(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardWords is a command as guards compare it: the base name of the
+// program, the letters of its short options, its long options and its
+// other words in order. Every word starting with - before a -- is taken
+// for an option.
+type guardWords struct {
+	name     string
+	short    map[rune]bool
+	long     map[string]bool
+	operands []string
+}
+
+func splitGuardWords(words []string) guardWords {
+	g := guardWords{name: filepath.Base(words[0]), short: make(map[rune]bool), long: make(map[string]bool)}
+	options := true
+	for _, word := range words[1:] {
+		switch {
+		case options && word == "--":
+			options = false
+		case options && strings.HasPrefix(word, "--"):
+			g.long[word] = true
+		case options && len(word) > 1 && word[0] == '-':
+			for _, letter := range word[1:] {
+				g.short[letter] = true
+			}
+		default:
+			g.operands = append(g.operands, word)
+		}
+	}
+	return g
+}
+
+// covers reports whether the guard g matches command.
+func (g guardWords) covers(command guardWords) bool {
+	if g.name != command.name || len(command.operands) < len(g.operands) {
+		return false
+	}
+	for letter := range g.short {
+		if !command.short[letter] {
+			return false
+		}
+	}
+	for option := range g.long {
+		if !command.long[option] {
+			return false
+		}
+	}
+	return equalWords(g.operands, command.operands[:len(g.operands)])
+}
+
+func equalWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// confirmGuarded reports whether a command may run, given its words as
+// typed and as expanded. With safe-rm set and a guard matching either, it
+// asks on stderr and only an answer of y or yes, read from the terminal,
+// lets the command run. Raw commands are never guarded, since no one is
+// there to answer.
+func (cmd *Command) confirmGuarded(typed, expanded []string) bool {
+	if cmd.Raw || !GetGlobalState().GetOption("safe-rm") {
+		return true
+	}
+	words := make([]string, len(expanded))
+	for i, word := range expanded {
+		words[i] = removeQuotes(word)
+	}
+	unquoted := make([]string, len(typed))
+	for i, word := range typed {
+		unquoted[i] = removeQuotes(word)
+	}
+	if !matchGuard(words) && !matchGuard(unquoted) {
+		return true
+	}
+
+	fmt.Fprintf(cmd.Stderr, "gosh: run '%s'? [y/N] ", strings.Join(words, " "))
+	var answer string
+	if input, err := openGuardInput(); err == nil {
+		answer, _ = readLine(input, '\n')
+		input.Close()
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	cmd.printError("gosh: %s: not confirmed\n", strings.Join(words, " "))
+	return false
+}
+
+// guard implements `guard WORD...` to guard commands starting with the
+// words, `guard -r WORD...` to remove a guard and `guard` to list them.
+func guard(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		for _, arg := range cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:] {
+			args = append(args, unquoteArg(arg))
+		}
+	}
+	cmd.ReturnCode = 0
+
+	switch {
+	case len(args) == 0:
+		guardsMu.Lock()
+		lines := make([]string, len(guards))
+		for i, words := range guards {
+			quoted := make([]string, len(words))
+			for j, word := range words {
+				quoted[j] = shellQuote(word)
+			}
+			lines[i] = "guard " + strings.Join(quoted, " ")
+		}
+		guardsMu.Unlock()
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(cmd.Stdout, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case args[0] == "-r":
+		if len(args) == 1 {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("Usage: guard [-r] [WORD...]")
+		}
+		if !removeGuard(args[1:]) {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("%s: not guarded", strings.Join(args[1:], " "))
+		}
+		return nil
+	}
+	addGuard(args)
+	return nil
+}
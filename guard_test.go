@@ -0,0 +1,121 @@
+package gosh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGuardedCommands(t *testing.T) {
+	useTempCWD(t)
+	defer func() { guards = nil }()
+	saved := openGuardInput
+	defer func() { openGuardInput = saved }()
+	answerWith := func(answer string) {
+		openGuardInput = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(answer)), nil
+		}
+	}
+	runWithOptions(t, "guard rm -rf '*'")
+	runWithOptions(t, "guard echo danger")
+	// The operand is a path that does not exist, so that a guard failing
+	// to match does no harm.
+	runWithOptions(t, "guard rm -rf /gosh-guard-test")
+	defer GetGlobalState().UnsetVar("RM")
+
+	tests := []struct {
+		input    string
+		answer   string
+		expected string
+		code     int
+	}{
+		{"echo danger zone", "y\n", "danger zone\n", 0},
+		{"echo danger zone", "yes\n", "danger zone\n", 0},
+		{"echo danger zone", "n\n", "", 1},
+		{"echo danger zone", "", "", 1},
+		{"echo 'danger' && echo after", "\n", "", 1},
+		{"echo safe; echo danger", "no\n", "safe\n", 1},
+		{"echo dangerous", "", "dangerous\n", 0},
+		{"rm -rf *", "n\n", "", 1},
+		{"rm -rf x", "", "", 0},
+		{"/bin/rm -rf /gosh-guard-test", "n\n", "", 1},
+		{"rm -fr /gosh-guard-test", "n\n", "", 1},
+		{"rm -r -f /gosh-guard-test", "n\n", "", 1},
+		{"rm -f /gosh-guard-test -r -v", "n\n", "", 1},
+		{"RM=rm; $RM -rf /gosh-guard-test", "n\n", "", 1},
+		{"X=1 rm -rf /gosh-guard-test", "n\n", "", 1},
+		{"rm -f /gosh-guard-test", "", "", 0},
+		{"rm -rf -- -x /gosh-guard-test", "", "", 0},
+	}
+	for _, tt := range tests {
+		answerWith(tt.answer)
+		stdout, stderr, code := runWithOptions(t, "set -o safe-rm; "+tt.input, "safe-rm")
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q answering %q = (%q, %d), want (%q, %d); stderr %q", tt.input, tt.answer, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+
+	// Guards are only consulted with safe-rm set.
+	answerWith("")
+	if stdout, stderr, code := runWithOptions(t, "echo danger"); stdout != "danger\n" || code != 0 {
+		t.Errorf("echo danger without safe-rm = (%q, %d), want it to run; stderr %q", stdout, code, stderr)
+	}
+
+	// Without a terminal to answer on, nothing is confirmed, and the
+	// command's own standard input is left alone.
+	openGuardInput = func() (io.ReadCloser, error) {
+		return nil, errors.New("no terminal")
+	}
+	defer GetGlobalState().SetOption("safe-rm", false)
+	cmd, err := NewCommand("set -o safe-rm; echo danger; cat", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader("y\nnext line\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if stdout.String() != "y\nnext line\n" || cmd.ReturnCode != 0 {
+		t.Errorf("echo danger; cat without a terminal = (%q, %d), want only cat's output; stderr %q", stdout.String(), cmd.ReturnCode, stderr.String())
+	}
+
+	// Raw commands are never guarded.
+	cmd, err = NewCommandRaw("set -o safe-rm; echo danger", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	stdout.Reset()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if stdout.String() != "danger\n" || cmd.ReturnCode != 0 {
+		t.Errorf("raw echo danger = (%q, %d), want it to run; stderr %q", stdout.String(), cmd.ReturnCode, stderr.String())
+	}
+}
+
+func TestGuardBuiltin(t *testing.T) {
+	defer func() { guards = nil }()
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"guard rm -rf /", "", 0},
+		{"guard rm -rf '*'", "", 0},
+		{"guard rm -rf /", "", 0},
+		{"guard", "guard 'rm' '-rf' '/'\nguard 'rm' '-rf' '*'\n", 0},
+		{"guard -r rm -rf /", "", 0},
+		{"guard -r rm -rf /", "", 1},
+		{"guard -r", "", 2},
+		{"guard", "guard 'rm' '-rf' '*'\n", 0},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package gosh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtinDescriptions holds the one-line summary shown by `help`.
+var builtinDescriptions = map[string]string{
+	".":              "run commands from a file in the current shell, like source",
+	"[":              "evaluate a conditional expression, closed by ]",
+	"alias":          "define or list command aliases",
+	"bg":             "resume a stopped job in the background",
+	"bindkey":        "bind a key such as ^G to a line editing action or, with -s, to a string, list the actions with -l, or remove a binding with -r",
+	"cd":             "change the working directory",
+	"clear":          "clear the terminal screen",
+	"complete":       "set how arguments to a command are completed",
+	"declare":        "set variables and their attributes: -r read-only, -i integer, -x exported",
+	"disown":         "stop tracking background jobs, the most recent by default, or with -h only mark them not to get SIGHUP",
+	"echo":           "write arguments to standard output, without the newline with -n or with backslash escapes with -e",
+	"enable":         "turn builtins back on, or off with -n so the command of that name in PATH runs instead; without names list the enabled builtins, or all with -a",
+	"env":            "print the environment",
+	"eval":           "join the arguments into a command and run it in the current shell",
+	"exec":           "replace the shell with a command, or with only redirections apply them to the shell",
+	"exit":           "exit the shell",
+	"export":         "set an environment variable for child processes",
+	"false":          "return an unsuccessful exit status",
+	"fg":             "move a job to the foreground",
+	"getopts":        "parse the options of a script one at a time, setting NAME, OPTARG and OPTIND",
+	"gosh-lisp":      "evaluate a gosh Lisp expression",
+	"guard":          "ask before running commands matching the given words, with options in any order, while set -o safe-rm is on; -r removes a guard and no arguments list them",
+	"help":           "list builtins, or search them with --search KEYWORD",
+	"history":        "show the command history, the most used commands with --stats, or only those run in a given dir (--cwd) or this session (--session)",
+	"jobs":           "list background jobs",
+	"m28":            "evaluate an M28 Lisp expression, run a file with -f, or start the M28 REPL with --repl",
+	"printf":         "format and print arguments, with %N$ selecting arguments by position, or store the result in a variable with -v NAME",
+	"prompt":         "set the prompt",
+	"pwd":            "print the working directory",
+	"read":           "read a line from standard input and split it into variables using IFS, or into an array with -a; -r keeps backslashes and -d sets the delimiter",
+	"set":            "set or unset shell options with -o and +o, or errexit, nounset and xtrace with -e, -u and -x, and set the positional parameters with set -- ARG...",
+	"set-chpwd-hook": "add a command to run after every cd, list them, or clear them with -c",
+	"source":         "run commands from a file in the current shell",
+	"test":           "evaluate a conditional expression",
+	"timeout":        "run a command, killing it if it runs longer than a duration",
+	"trap":           "run commands when the shell gets a signal or exits, remove them with -, or list the signals with -l",
+	"true":           "return a successful exit status",
+	"typeset":        "set variables and their attributes, like declare",
+	"type":           "tell whether a name is an alias, a builtin or a file in PATH, with -t for just the kind or -p for just the path",
+	"unalias":        "remove command aliases",
+	"wait":           "wait for background jobs to finish, returning the status of the last one named",
+	"which":          "print the full path of the executable each name runs",
+}
+
+func help(cmd *Command) error {
+	var keyword string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		args := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+		if len(args) > 0 {
+			if args[0] != "--search" || len(args) != 2 {
+				return fmt.Errorf("Usage: help [--search KEYWORD]")
+			}
+			keyword = strings.ToLower(strings.Trim(args[1], "'\""))
+		}
+	}
+
+	names := searchBuiltins(keyword)
+	if keyword != "" && len(names) == 0 {
+		cmd.ReturnCode = 1
+		return fmt.Errorf("no builtins match '%s'", keyword)
+	}
+
+	_, err := fmt.Fprintln(cmd.Stdout, "Built-in commands:")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err = fmt.Fprintf(cmd.Stdout, "  %-10s %s\n", name, builtinDescriptions[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchBuiltins returns the sorted names of the builtins whose name or
+// description contains keyword, ignoring case. An empty keyword matches
+// every builtin.
+func searchBuiltins(keyword string) []string {
+	var names []string
+	for name := range Builtins() {
+		text := strings.ToLower(name + " " + builtinDescriptions[name])
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,29 @@
+package gosh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHelpSearch(t *testing.T) {
+	for name := range Builtins() {
+		if _, ok := builtinDescriptions[name]; !ok {
+			t.Errorf("builtin %q has no help description", name)
+		}
+	}
+
+	if result := searchBuiltins("DIRECTORY"); !reflect.DeepEqual(result, []string{"cd", "pwd"}) {
+		t.Errorf("searchBuiltins(%q) = %v, want %v", "DIRECTORY", result, []string{"cd", "pwd"})
+	}
+
+	output := runEcho(t, "help --search ifs")
+	if !strings.Contains(output, "read") || strings.Contains(output, "echo") {
+		t.Errorf("help --search ifs = %q, want only the read builtin", output)
+	}
+
+	code, stderr := runTestBuiltin(t, "help --search no-such-keyword")
+	if code != 1 || !strings.Contains(stderr, "no builtins match") {
+		t.Errorf("help --search no-such-keyword = (%d, %q), want a failure", code, stderr)
+	}
+}
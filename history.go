@@ -4,13 +4,17 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"gosh/parser"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// HistoryManager manages the command history stored in SQLite.
+// HistoryManager is the HistoryStore that keeps the command history in
+// SQLite.
 type HistoryManager struct {
 	db *sql.DB
 }
@@ -57,15 +61,49 @@ func NewHistoryManager(dbPath string) (*HistoryManager, error) {
 		}
 	}
 
+	// Session IDs come from their own table, so shells started at the same
+	// time never share one. A new table starts above the sessions already
+	// recorded in the command table.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS session(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		start_time INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`INSERT OR IGNORE INTO session (id, start_time)
+		SELECT MAX(session_id), 0 FROM command
+		WHERE NOT EXISTS (SELECT 1 FROM session)
+		HAVING MAX(session_id) IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+
 	return &HistoryManager{db: db}, nil
 }
 
-func (h *HistoryManager) Insert(cmd *Command, sessionID int) error {
+// Close closes the database.
+func (h *HistoryManager) Close() error {
+	return h.db.Close()
+}
+
+// NewSessionID records a new shell session and returns its ID, which no
+// other session, past or concurrent, is given.
+func (h *HistoryManager) NewSessionID() (int64, error) {
+	result, err := h.db.Exec("INSERT INTO session (start_time) VALUES (?)", time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Insert records a finished command and returns the ID of its row.
+func (h *HistoryManager) Insert(cmd *Command, sessionID int64) (int64, error) {
 	// Check if 'args' column exists
 	var argsColumnExists bool
 	err := h.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('command') WHERE name='args'").Scan(&argsColumnExists)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	var insertSQL string
@@ -82,7 +120,17 @@ func (h *HistoryManager) Insert(cmd *Command, sessionID int) error {
 		args = []interface{}{sessionID, cmd.TTY, cmd.EUID, gs.GetCWD(), cmd.StartTime.Unix(), cmd.EndTime.Unix(), int(cmd.Duration.Seconds()), fullCommand, cmd.ReturnCode}
 	}
 
-	_, err = h.db.Exec(insertSQL, args...)
+	result, err := h.db.Exec(insertSQL, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateResult records the exit code and end time of a command recorded
+// before it finished, such as one that started a background job.
+func (h *HistoryManager) UpdateResult(id int64, returnCode int, endTime time.Time) error {
+	_, err := h.db.Exec("UPDATE command SET return_code = ?, end_time = ?, duration = ? - start_time WHERE id = ?", returnCode, endTime.Unix(), endTime.Unix(), id)
 	return err
 }
 
@@ -103,3 +151,131 @@ func (h *HistoryManager) Dump() ([]string, error) {
 	}
 	return history, nil
 }
+
+// HistoryByCWD returns the commands run in dir, oldest first. A positive
+// limit keeps only the most recent limit commands.
+func (h *HistoryManager) HistoryByCWD(dir string, limit int) ([]string, error) {
+	return h.recentCommands("cwd", dir, limit)
+}
+
+// HistoryBySession returns the commands of one session, oldest first. A
+// positive limit keeps only the most recent limit commands.
+func (h *HistoryManager) HistoryBySession(sessionID int64, limit int) ([]string, error) {
+	return h.recentCommands("session_id", sessionID, limit)
+}
+
+// recentCommands returns the commands whose column equals value, oldest
+// first, keeping only the most recent limit of them when limit is positive.
+func (h *HistoryManager) recentCommands(column string, value interface{}, limit int) ([]string, error) {
+	if limit <= 0 {
+		limit = -1 // SQLite reads a negative LIMIT as no limit
+	}
+	query := "SELECT command FROM (SELECT id, command FROM command WHERE " + column + " = ? ORDER BY id DESC LIMIT ?) ORDER BY id"
+	rows, err := h.db.Query(query, value, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []string
+	for rows.Next() {
+		var cmd string
+		if err := rows.Scan(&cmd); err != nil {
+			return nil, err
+		}
+		history = append(history, cmd)
+	}
+	return history, rows.Err()
+}
+
+// LastCommand returns the most recently recorded command, or an empty string
+// if the history is empty.
+func (h *HistoryManager) LastCommand() (string, error) {
+	var cmd string
+	err := h.db.QueryRow("SELECT command FROM command ORDER BY id DESC LIMIT 1").Scan(&cmd)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return cmd, err
+}
+
+// CommandUseCounts returns how many times each command name appears in the
+// history, counting every command of a pipeline or && list.
+func CommandUseCounts(store HistoryStore) (map[string]int, error) {
+	history, err := store.Dump()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, line := range history {
+		for _, name := range commandNames(line) {
+			counts[name]++
+		}
+	}
+	return counts, nil
+}
+
+// CommandCount is a command and the number of times it appears in the
+// history.
+type CommandCount struct {
+	Name  string
+	Count int
+}
+
+// TopCommands returns the n most used command names, most used first.
+func TopCommands(store HistoryStore, n int) ([]CommandCount, error) {
+	counts, err := CommandUseCounts(store)
+	if err != nil {
+		return nil, err
+	}
+	var top []CommandCount
+	for name, count := range counts {
+		top = append(top, CommandCount{Name: name, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, nil
+}
+
+// TopCommandLines returns the n most used full command lines, most used
+// first.
+func (h *HistoryManager) TopCommandLines(n int) ([]CommandCount, error) {
+	rows, err := h.db.Query("SELECT command, COUNT(*) AS uses FROM command GROUP BY command ORDER BY uses DESC, command LIMIT ?", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var top []CommandCount
+	for rows.Next() {
+		var entry CommandCount
+		if err := rows.Scan(&entry.Name, &entry.Count); err != nil {
+			return nil, err
+		}
+		top = append(top, entry)
+	}
+	return top, rows.Err()
+}
+
+// commandNames returns the command word of each simple command in line.
+func commandNames(line string) []string {
+	var names []string
+	expectCommand := true
+	for _, word := range strings.Fields(line) {
+		switch {
+		case word == "|" || word == "&&" || word == "&" || word == ";":
+			expectCommand = true
+		case expectCommand:
+			names = append(names, strings.TrimSuffix(word, ";"))
+			expectCommand = strings.HasSuffix(word, ";")
+		case strings.HasSuffix(word, ";"):
+			expectCommand = true
+		}
+	}
+	return names
+}
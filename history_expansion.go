@@ -0,0 +1,78 @@
+package gosh
+
+import (
+	"fmt"
+	"strings"
+
+	"gosh/parser"
+)
+
+// ExpandHistoryDesignators replaces the word designators !$ (last argument),
+// !^ (first argument) and !* (all arguments) with words taken from the
+// previous command line. Designators inside single quotes are left alone.
+func ExpandHistoryDesignators(line, previous string) (string, error) {
+	if !strings.Contains(line, "!") {
+		return line, nil
+	}
+
+	var words []string
+	var result strings.Builder
+	inSingleQuote := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\'' {
+			inSingleQuote = !inSingleQuote
+		}
+		if c != '!' || inSingleQuote || i+1 >= len(line) || !strings.ContainsRune("$^*", rune(line[i+1])) {
+			result.WriteByte(c)
+			continue
+		}
+
+		designator := line[i : i+2]
+		if words == nil {
+			words = previousCommandWords(previous)
+			if len(words) == 0 {
+				return "", fmt.Errorf("%s: event not found", designator)
+			}
+		}
+
+		args := words[1:]
+		switch designator {
+		case "!$":
+			result.WriteString(words[len(words)-1])
+		case "!^":
+			if len(args) == 0 {
+				return "", fmt.Errorf("%s: bad word specifier", designator)
+			}
+			result.WriteString(args[0])
+		case "!*":
+			result.WriteString(strings.Join(args, " "))
+		}
+		i++
+	}
+
+	return result.String(), nil
+}
+
+// previousCommandWords splits a command line into its words, keeping quoted
+// strings intact.
+func previousCommandWords(previous string) []string {
+	if strings.TrimSpace(previous) == "" {
+		return nil
+	}
+	parsed, err := parser.Parse(previous)
+	if err != nil {
+		return strings.Fields(previous)
+	}
+
+	var words []string
+	for _, andCmd := range parsed.AndCommands {
+		for _, pipeline := range andCmd.Pipelines {
+			for _, simpleCmd := range pipeline.Commands {
+				words = append(words, simpleCmd.Parts...)
+			}
+		}
+	}
+	return words
+}
@@ -0,0 +1,81 @@
+package gosh
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandHistoryDesignators(t *testing.T) {
+	tests := []struct {
+		line     string
+		previous string
+		expected string
+	}{
+		{"ls !$", "echo a b c", "ls c"},
+		{"ls !^", "echo a b c", "ls a"},
+		{"ls !*", "echo a b c", "ls a b c"},
+		{"cat !$", "echo 'hello world'", "cat 'hello world'"},
+		{"echo '!$'", "echo a b c", "echo '!$'"},
+		{"echo hi!", "echo a b c", "echo hi!"},
+		{"echo !$ !^", "cp src dst", "echo dst src"},
+		{"echo !*", "pwd", "echo "},
+	}
+
+	for _, tt := range tests {
+		result, err := ExpandHistoryDesignators(tt.line, tt.previous)
+		if err != nil {
+			t.Errorf("ExpandHistoryDesignators(%q, %q) returned error: %v", tt.line, tt.previous, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("ExpandHistoryDesignators(%q, %q) = %q, want %q", tt.line, tt.previous, result, tt.expected)
+		}
+	}
+}
+
+func TestExpandHistoryDesignatorsErrors(t *testing.T) {
+	tests := []struct {
+		line     string
+		previous string
+	}{
+		{"ls !$", ""},
+		{"ls !^", "pwd"},
+	}
+
+	for _, tt := range tests {
+		if _, err := ExpandHistoryDesignators(tt.line, tt.previous); err == nil {
+			t.Errorf("ExpandHistoryDesignators(%q, %q) expected error, got nil", tt.line, tt.previous)
+		}
+	}
+}
+
+func TestHistoryLastCommand(t *testing.T) {
+	historyManager, err := NewHistoryManager(filepath.Join(t.TempDir(), "history.sqlite"))
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+
+	last, err := historyManager.LastCommand()
+	if err != nil || last != "" {
+		t.Errorf("LastCommand() on empty history = (%q, %v), want empty", last, err)
+	}
+
+	for _, input := range []string{"echo first", "echo a b c"} {
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		if _, err := historyManager.Insert(cmd, 0); err != nil {
+			t.Fatalf("Insert() returned error: %v", err)
+		}
+	}
+
+	last, err = historyManager.LastCommand()
+	if err != nil {
+		t.Fatalf("LastCommand() returned error: %v", err)
+	}
+	expanded, _ := ExpandHistoryDesignators("ls !$", last)
+	if expanded != "ls c" {
+		t.Errorf("ExpandHistoryDesignators(\"ls !$\", %q) = %q, want %q", last, expanded, "ls c")
+	}
+}
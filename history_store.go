@@ -0,0 +1,35 @@
+package gosh
+
+import "time"
+
+// HistoryStore is where the shell records the commands it runs and reads
+// them back for the history builtin, history expansion and completion.
+// HistoryManager is the SQLite implementation.
+type HistoryStore interface {
+	// NewSessionID returns an ID for a new shell session.
+	NewSessionID() (int64, error)
+	// Insert records a finished command and returns an ID for it.
+	Insert(cmd *Command, sessionID int64) (int64, error)
+	// UpdateResult records the outcome of a command inserted before it
+	// finished.
+	UpdateResult(id int64, returnCode int, endTime time.Time) error
+	// Dump returns every command, oldest first.
+	Dump() ([]string, error)
+	// HistoryByCWD and HistoryBySession return the commands run in a
+	// directory or a session, oldest first, keeping only the most recent
+	// limit of them when limit is positive.
+	HistoryByCWD(dir string, limit int) ([]string, error)
+	HistoryBySession(sessionID int64, limit int) ([]string, error)
+	// LastCommand returns the most recent command, or "" if there is none.
+	LastCommand() (string, error)
+	// TopCommandLines returns the n most used command lines, most used
+	// first.
+	TopCommandLines(n int) ([]CommandCount, error)
+	Close() error
+}
+
+// openHistoryStore opens the store the history builtin reads. Tests
+// replace it with an in-memory store.
+var openHistoryStore = func() (HistoryStore, error) {
+	return NewHistoryManager("")
+}
@@ -0,0 +1,103 @@
+package gosh
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gosh/parser"
+)
+
+// memoryHistory is a HistoryStore that keeps commands in a slice.
+type memoryHistory struct {
+	commands []string
+	closed   bool
+}
+
+func (m *memoryHistory) NewSessionID() (int64, error) { return 1, nil }
+
+func (m *memoryHistory) Insert(cmd *Command, sessionID int64) (int64, error) {
+	m.commands = append(m.commands, parser.FormatCommand(cmd.Command))
+	return int64(len(m.commands)), nil
+}
+
+func (m *memoryHistory) UpdateResult(int64, int, time.Time) error { return nil }
+
+func (m *memoryHistory) Dump() ([]string, error) { return m.commands, nil }
+
+func (m *memoryHistory) HistoryByCWD(string, int) ([]string, error) { return nil, nil }
+
+func (m *memoryHistory) HistoryBySession(int64, int) ([]string, error) { return m.commands, nil }
+
+func (m *memoryHistory) LastCommand() (string, error) {
+	if len(m.commands) == 0 {
+		return "", nil
+	}
+	return m.commands[len(m.commands)-1], nil
+}
+
+func (m *memoryHistory) TopCommandLines(n int) ([]CommandCount, error) {
+	if len(m.commands) == 0 {
+		return nil, nil
+	}
+	return []CommandCount{{m.commands[0], 1}}, nil
+}
+
+func (m *memoryHistory) Close() error {
+	m.closed = true
+	return nil
+}
+
+var _ HistoryStore = (*HistoryManager)(nil)
+
+func useMemoryHistory(t *testing.T, commands ...string) *memoryHistory {
+	t.Helper()
+	store := &memoryHistory{commands: commands}
+	saved := openHistoryStore
+	openHistoryStore = func() (HistoryStore, error) { return store, nil }
+	t.Cleanup(func() { openHistoryStore = saved })
+	return store
+}
+
+func TestHistoryBuiltinUsesStore(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"history", "git status\nls | wc -l\ngit log\n"},
+		{"history --session", "git status\nls | wc -l\ngit log\n"},
+		{"history --stats", "Most used commands:\n     2  git\n     1  ls\n     1  wc\nMost used command lines:\n     1  git status\n"},
+	}
+	for _, tt := range tests {
+		store := useMemoryHistory(t, "git status", "ls | wc -l", "git log")
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != 0 {
+			t.Errorf("%q = (%q, %d), want (%q, 0); stderr %q", tt.input, stdout, code, tt.expected, stderr)
+		}
+		if !store.closed {
+			t.Errorf("%q left the history store open", tt.input)
+		}
+	}
+}
+
+func TestCommandUseCountsFromStore(t *testing.T) {
+	store := &memoryHistory{}
+	for _, input := range []string{"git status", "echo hi && git log"} {
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		if _, err := store.Insert(cmd, 0); err != nil {
+			t.Fatalf("Insert() returned error: %v", err)
+		}
+	}
+
+	counts, err := CommandUseCounts(store)
+	expected := map[string]int{"git": 2, "echo": 1}
+	if err != nil || !reflect.DeepEqual(counts, expected) {
+		t.Errorf("CommandUseCounts() = (%v, %v), want %v", counts, err, expected)
+	}
+	if last, err := store.LastCommand(); err != nil || last != "echo hi && git log" {
+		t.Errorf("LastCommand() = (%q, %v), want the second command", last, err)
+	}
+}
@@ -0,0 +1,272 @@
+package gosh
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryStats(t *testing.T) {
+	historyManager, err := NewHistoryManager(filepath.Join(t.TempDir(), "history.sqlite"))
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	inputs := []string{
+		"git status",
+		"git status",
+		"git log",
+		"ls -l | grep go",
+		"ls",
+		"cd /tmp; ls",
+		"git status",
+	}
+	for _, input := range inputs {
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		if _, err := historyManager.Insert(cmd, 0); err != nil {
+			t.Fatalf("Insert() returned error: %v", err)
+		}
+	}
+
+	commands, err := TopCommands(historyManager, 3)
+	if err != nil {
+		t.Fatalf("TopCommands() returned error: %v", err)
+	}
+	expectedCommands := []CommandCount{{"git", 4}, {"ls", 3}, {"cd", 1}}
+	if !reflect.DeepEqual(commands, expectedCommands) {
+		t.Errorf("TopCommands(3) = %v, want %v", commands, expectedCommands)
+	}
+
+	lines, err := historyManager.TopCommandLines(2)
+	if err != nil {
+		t.Fatalf("TopCommandLines() returned error: %v", err)
+	}
+	expectedLines := []CommandCount{{"git status", 3}, {"cd /tmp; ls", 1}}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("TopCommandLines(2) = %v, want %v", lines, expectedLines)
+	}
+
+	var output bytes.Buffer
+	if err := writeHistoryStats(&output, historyManager, 1); err != nil {
+		t.Fatalf("writeHistoryStats() returned error: %v", err)
+	}
+	expected := "Most used commands:\n     4  git\nMost used command lines:\n     3  git status\n"
+	if output.String() != expected {
+		t.Errorf("writeHistoryStats() = %q, want %q", output.String(), expected)
+	}
+}
+
+func TestHistoryByCWD(t *testing.T) {
+	historyManager, err := NewHistoryManager(filepath.Join(t.TempDir(), "history.sqlite"))
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	gs := GetGlobalState()
+	previous := gs.GetCWD()
+	defer gs.UpdateCWD(previous)
+
+	entries := []struct {
+		dir, input string
+	}{
+		{"/project/a", "make"},
+		{"/project/b", "go test"},
+		{"/project/a", "git status"},
+		{"/project/a", "make install"},
+		{"/project/b", "go vet"},
+	}
+	for _, entry := range entries {
+		gs.UpdateCWD(entry.dir)
+		cmd, err := NewCommand(entry.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		if _, err := historyManager.Insert(cmd, 0); err != nil {
+			t.Fatalf("Insert() returned error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		dir      string
+		limit    int
+		expected []string
+	}{
+		{"/project/a", 0, []string{"make", "git status", "make install"}},
+		{"/project/a", 2, []string{"git status", "make install"}},
+		{"/project/b", 0, []string{"go test", "go vet"}},
+		{"/project/c", 0, nil},
+	}
+	for _, tt := range tests {
+		history, err := historyManager.HistoryByCWD(tt.dir, tt.limit)
+		if err != nil {
+			t.Fatalf("HistoryByCWD(%q, %d) returned error: %v", tt.dir, tt.limit, err)
+		}
+		if !reflect.DeepEqual(history, tt.expected) {
+			t.Errorf("HistoryByCWD(%q, %d) = %q, want %q", tt.dir, tt.limit, history, tt.expected)
+		}
+	}
+}
+
+func TestHistoryBySession(t *testing.T) {
+	historyManager, err := NewHistoryManager(filepath.Join(t.TempDir(), "history.sqlite"))
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+
+	first, err := historyManager.NewSessionID()
+	if err != nil {
+		t.Fatalf("NewSessionID() returned error: %v", err)
+	}
+	second, err := historyManager.NewSessionID()
+	if err != nil || second != first+1 {
+		t.Fatalf("NewSessionID() = %d, %v, want %d", second, err, first+1)
+	}
+	entries := []struct {
+		session int64
+		input   string
+	}{
+		{first, "make"},
+		{second, "go test"},
+		{first, "git status"},
+		{second, "go vet"},
+		{first, "make install"},
+	}
+	for _, entry := range entries {
+		cmd, err := NewCommand(entry.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		if _, err := historyManager.Insert(cmd, entry.session); err != nil {
+			t.Fatalf("Insert() returned error: %v", err)
+		}
+	}
+	if next, err := historyManager.NewSessionID(); err != nil || next != first+2 {
+		t.Errorf("NewSessionID() = %d, %v, want %d", next, err, first+2)
+	}
+
+	tests := []struct {
+		session  int64
+		limit    int
+		expected []string
+	}{
+		{first, 0, []string{"make", "git status", "make install"}},
+		{first, 1, []string{"make install"}},
+		{second, 0, []string{"go test", "go vet"}},
+		{first + 2, 0, nil},
+	}
+	for _, tt := range tests {
+		history, err := historyManager.HistoryBySession(tt.session, tt.limit)
+		if err != nil {
+			t.Fatalf("HistoryBySession(%d, %d) returned error: %v", tt.session, tt.limit, err)
+		}
+		if !reflect.DeepEqual(history, tt.expected) {
+			t.Errorf("HistoryBySession(%d, %d) = %q, want %q", tt.session, tt.limit, history, tt.expected)
+		}
+	}
+}
+
+func TestNewSessionIDUnique(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "history.sqlite")
+	a, err := NewHistoryManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	defer a.Close()
+	b, err := NewHistoryManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	defer b.Close()
+
+	// Neither shell has recorded a command yet, so only the session table
+	// keeps their IDs apart.
+	seen := map[int64]bool{}
+	for _, h := range []*HistoryManager{a, b, a, b} {
+		id, err := h.NewSessionID()
+		if err != nil {
+			t.Fatalf("NewSessionID() returned error: %v", err)
+		}
+		if seen[id] {
+			t.Errorf("NewSessionID() = %d, given out twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestNewSessionIDAfterOldHistory(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "history.sqlite")
+	old, err := NewHistoryManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	cmd, err := NewCommand("make", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	if _, err := old.Insert(cmd, 7); err != nil {
+		t.Fatalf("Insert() returned error: %v", err)
+	}
+	// A history file from before sessions had their own table.
+	if _, err := old.db.Exec("DROP TABLE session"); err != nil {
+		t.Fatalf("DROP TABLE session returned error: %v", err)
+	}
+	old.Close()
+
+	historyManager, err := NewHistoryManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	defer historyManager.Close()
+	if id, err := historyManager.NewSessionID(); err != nil || id != 8 {
+		t.Errorf("NewSessionID() = %d, %v, want 8", id, err)
+	}
+}
+
+func TestBackgroundJobUpdatesHistory(t *testing.T) {
+	useTempCWD(t)
+	historyManager, err := NewHistoryManager(filepath.Join(t.TempDir(), "history.sqlite"))
+	if err != nil {
+		t.Fatalf("NewHistoryManager() returned error: %v", err)
+	}
+	jobManager := NewJobManager()
+	cmd, err := NewCommand("ls /gosh-no-such-dir &", jobManager)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = NewSyncWriter(&stderr)
+	cmd.Run()
+	if len(cmd.BackgroundJobs) != 1 {
+		t.Fatalf("BackgroundJobs = %v, want one job", cmd.BackgroundJobs)
+	}
+
+	id, err := historyManager.Insert(cmd, 0)
+	if err != nil {
+		t.Fatalf("Insert() returned error: %v", err)
+	}
+	updated := make(chan error, 1)
+	jobManager.WhenDone(cmd.BackgroundJobs[0], func(job *Job) {
+		updated <- historyManager.UpdateResult(id, job.ExitCode, job.EndTime)
+	})
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Fatalf("UpdateResult() returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("background job never finished")
+	}
+
+	var returnCode int
+	var startTime, endTime int64
+	row := historyManager.db.QueryRow("SELECT return_code, start_time, end_time FROM command WHERE id = ?", id)
+	if err := row.Scan(&returnCode, &startTime, &endTime); err != nil {
+		t.Fatalf("Failed to read history entry: %v", err)
+	}
+	if returnCode != 2 || endTime < startTime {
+		t.Errorf("history entry = (return code %d, start %d, end %d), want return code 2 and end after start", returnCode, startTime, endTime)
+	}
+}
@@ -2,9 +2,13 @@ package gosh
 
 import (
 	"fmt"
+	"io"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type Job struct {
@@ -12,6 +16,14 @@ type Job struct {
 	Command string
 	Cmd     *exec.Cmd
 	Status  string
+	NoHUP   bool // set by disown -h
+	// ExitCode and EndTime are set once the job is done.
+	ExitCode int
+	EndTime  time.Time
+	done     chan struct{}
+	onDone   []func(*Job)
+	// stopped is closed when the job is stopped while in the foreground.
+	stopped chan struct{}
 }
 
 type JobManager struct {
@@ -20,12 +32,15 @@ type JobManager struct {
 	mu      sync.Mutex
 	fgJob   *Job
 	fgJobMu sync.Mutex
+	// Output receives job notifications. It defaults to ShellOutput.
+	Output io.Writer
 }
 
 func NewJobManager() *JobManager {
 	return &JobManager{
 		jobs:   make(map[int]*Job),
 		nextID: 1,
+		Output: ShellOutput,
 	}
 }
 
@@ -38,6 +53,7 @@ func (jm *JobManager) AddJob(command string, cmd *exec.Cmd) *Job {
 		Command: command,
 		Cmd:     cmd,
 		Status:  "Running",
+		done:    make(chan struct{}),
 	}
 	jm.jobs[job.ID] = job
 	jm.nextID++
@@ -64,6 +80,79 @@ func (jm *JobManager) GetJob(id int) (*Job, bool) {
 	return job, exists
 }
 
+// JobStatus returns the status of job, which changes while it runs.
+func (jm *JobManager) JobStatus(job *Job) string {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	return job.Status
+}
+
+// setStatus moves job to status, and is where every change of state short
+// of finishing happens. A job put in the foreground gets a stopped channel,
+// which setStatus returns and closes once the job is stopped, so that fg
+// stops waiting for it.
+func (jm *JobManager) setStatus(job *Job, status string) <-chan struct{} {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	job.Status = status
+	switch status {
+	case "Foreground":
+		job.stopped = make(chan struct{})
+	case "Stopped":
+		if job.stopped != nil {
+			close(job.stopped)
+			job.stopped = nil
+		}
+	}
+	return job.stopped
+}
+
+// LastJob returns the most recently started job.
+func (jm *JobManager) LastJob() (*Job, bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	var last *Job
+	for _, job := range jm.jobs {
+		if last == nil || job.ID > last.ID {
+			last = job
+		}
+	}
+	return last, last != nil
+}
+
+// ResolveJobSpec returns the ID of the job named by spec: a job number,
+// %N, or %string for the job whose command starts with string.
+func (jm *JobManager) ResolveJobSpec(spec string) (int, error) {
+	if !strings.HasPrefix(spec, "%") {
+		id, err := strconv.Atoi(spec)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid job ID")
+		}
+		return id, nil
+	}
+
+	spec = spec[1:]
+	if id, err := strconv.Atoi(spec); err == nil {
+		return id, nil
+	}
+
+	var matches []int
+	for _, job := range jm.ListJobs() {
+		if strings.HasPrefix(job.Command, spec) {
+			matches = append(matches, job.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("%%%s: no such job", spec)
+	case 1:
+		return matches[0], nil
+	default:
+		return 0, fmt.Errorf("%%%s: ambiguous job spec", spec)
+	}
+}
+
 func (jm *JobManager) RemoveJob(id int) {
 	jm.mu.Lock()
 	defer jm.mu.Unlock()
@@ -88,48 +177,47 @@ func (jm *JobManager) StopForegroundJob() {
 	defer jm.fgJobMu.Unlock()
 
 	if jm.fgJob != nil {
-		fmt.Printf("\nStopping job: [%d] %s\n", jm.fgJob.ID, jm.fgJob.Command)
-		err := jm.fgJob.Cmd.Process.Signal(syscall.SIGTSTP)
+		fmt.Fprintf(jm.Output, "\nStopping job: [%d] %s\n", jm.fgJob.ID, jm.fgJob.Command)
+		err := jm.fgJob.signal(syscall.SIGTSTP)
 		if err != nil {
-			fmt.Printf("Error stopping job: %v\n", err)
+			fmt.Fprintf(jm.Output, "Error stopping job: %v\n", err)
 		} else {
-			jm.fgJob.Status = "Stopped"
-			fmt.Printf("[%d]+ Stopped %s\n", jm.fgJob.ID, jm.fgJob.Command)
+			jm.setStatus(jm.fgJob, "Stopped")
+			fmt.Fprintf(jm.Output, "[%d]+ Stopped %s\n", jm.fgJob.ID, jm.fgJob.Command)
 		}
 		jm.fgJob = nil
 	}
 }
 
+// ForegroundJob continues job id in the foreground and waits until it
+// finishes or is stopped again, leaving a stopped job in the job table.
 func (jm *JobManager) ForegroundJob(id int) error {
 	job, exists := jm.GetJob(id)
 	if !exists {
 		return fmt.Errorf("job %d not found", id)
 	}
 
+	stopped := jm.setStatus(job, "Foreground")
 	jm.SetForegroundJob(job)
-	job.Status = "Foreground"
 
-	fmt.Printf("Bringing job to foreground: [%d] %s\n", job.ID, job.Command)
+	fmt.Fprintf(jm.Output, "Bringing job to foreground: [%d] %s\n", job.ID, job.Command)
 
-	err := job.Cmd.Process.Signal(syscall.SIGCONT)
-	if err != nil {
-		return err
+	if job.Cmd != nil {
+		if err := job.signal(syscall.SIGCONT); err != nil {
+			jm.SetForegroundJob(nil)
+			return err
+		}
 	}
 
-	state, err := job.Cmd.Process.Wait()
-	if err != nil {
-		return err
+	select {
+	case <-job.done:
+	case <-stopped:
+		return nil
 	}
 
 	jm.SetForegroundJob(nil)
-
-	if state.Exited() {
-		jm.RemoveJob(id)
-		fmt.Printf("[%d]+ Done %s\n", job.ID, job.Command)
-	} else {
-		job.Status = "Stopped"
-		fmt.Printf("[%d]+ Stopped %s\n", job.ID, job.Command)
-	}
+	jm.RemoveJob(id)
+	fmt.Fprintf(jm.Output, "[%d]+ Done %s\n", job.ID, job.Command)
 
 	return nil
 }
@@ -140,25 +228,108 @@ func (jm *JobManager) BackgroundJob(id int) error {
 		return fmt.Errorf("job %d not found", id)
 	}
 
-	job.Status = "Running"
-	return job.Cmd.Process.Signal(syscall.SIGCONT)
+	jm.setStatus(job, "Running")
+	if job.Cmd == nil {
+		return nil
+	}
+	return job.signal(syscall.SIGCONT)
 }
 
-func (jm *JobManager) ReapChildren() {
-	for {
-		pid, _ := syscall.Wait4(-1, nil, syscall.WNOHANG, nil)
-		if pid <= 0 {
-			break
-		}
+// signal sends sig to the last process of the job. A job the shell runs
+// itself, such as a background && chain, has no process to signal.
+func (job *Job) signal(sig syscall.Signal) error {
+	if job.Cmd == nil || job.Cmd.Process == nil {
+		return fmt.Errorf("job %d cannot be signalled", job.ID)
+	}
+	return job.Cmd.Process.Signal(sig)
+}
 
+// WaitInBackground runs wait, which must block until every process of the
+// job has exited and return its exit status, on its own goroutine and
+// marks the job done afterwards. Background processes are only ever waited
+// for here, so the job manager never competes with foreground commands for
+// their exit status.
+func (jm *JobManager) WaitInBackground(job *Job, wait func() int) {
+	go func() {
+		exitCode := wait()
 		jm.mu.Lock()
-		for id, job := range jm.jobs {
-			if job.Cmd.Process.Pid == pid {
+		job.Status = doneStatus(exitCode)
+		job.ExitCode = exitCode
+		job.EndTime = time.Now()
+		callbacks := job.onDone
+		job.onDone = nil
+		close(job.done)
+		jm.mu.Unlock()
+		for _, fn := range callbacks {
+			fn(job)
+		}
+	}()
+}
+
+// doneStatus is the status of a finished job: Done, or Done(N) when it
+// failed with exit code N.
+func doneStatus(exitCode int) string {
+	if exitCode == 0 {
+		return "Done"
+	}
+	return fmt.Sprintf("Done(%d)", exitCode)
+}
+
+// WhenDone arranges for fn to be called once job has finished: straight
+// away if it already has, otherwise on the goroutine that waited for it.
+func (jm *JobManager) WhenDone(job *Job, fn func(*Job)) {
+	jm.mu.Lock()
+	select {
+	case <-job.done:
+		jm.mu.Unlock()
+		fn(job)
+	default:
+		job.onDone = append(job.onDone, fn)
+		jm.mu.Unlock()
+	}
+}
+
+// Done returns a channel that is closed once the job has finished.
+func (job *Job) Done() <-chan struct{} {
+	return job.done
+}
+
+// FindJobByPID returns the job whose last process has the given PID.
+func (jm *JobManager) FindJobByPID(pid int) (*Job, bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	for _, job := range jm.jobs {
+		if job.Cmd != nil && job.Cmd.Process != nil && job.Cmd.Process.Pid == pid {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// WaitJob blocks until job has finished, forgets it without reporting it
+// as done, since whoever waited already knows, and returns its exit code.
+func (jm *JobManager) WaitJob(job *Job) int {
+	<-job.done
+	jm.RemoveJob(job.ID)
+	return job.ExitCode
+}
+
+// ReapChildren reports and forgets background jobs that have finished.
+func (jm *JobManager) ReapChildren() {
+	fgJob := jm.GetForegroundJob()
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	for id, job := range jm.jobs {
+		select {
+		case <-job.done:
+			if job != fgJob {
 				delete(jm.jobs, id)
-				fmt.Printf("[%d]+ Done %s\n", job.ID, job.Command)
-				break
+				fmt.Fprintf(jm.Output, "[%d]+ %s %s\n", job.ID, doneStatus(job.ExitCode), job.Command)
 			}
+		default:
 		}
-		jm.mu.Unlock()
 	}
 }
@@ -0,0 +1,358 @@
+package gosh
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReapChildrenDoesNotStealForegroundChildren(t *testing.T) {
+	useTempCWD(t)
+	jobManager := NewJobManager()
+
+	stop := make(chan struct{})
+	var reaper sync.WaitGroup
+	reaper.Add(1)
+	go func() {
+		defer reaper.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				jobManager.ReapChildren()
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		input := "true"
+		if i%5 == 0 {
+			input = "true &"
+		}
+		cmd, err := NewCommand(input, jobManager)
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = NewSyncWriter(&stdout)
+		cmd.Stderr = NewSyncWriter(&stderr)
+		cmd.Run()
+
+		if cmd.ReturnCode != 0 {
+			t.Errorf("%q ReturnCode = %d, want 0", input, cmd.ReturnCode)
+		}
+		if strings.Contains(stderr.String(), "Error") {
+			t.Errorf("%q stderr = %q, want no errors", input, stderr.String())
+		}
+	}
+
+	close(stop)
+	reaper.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(jobManager.ListJobs()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("background jobs were never reaped: %d remaining", len(jobManager.ListJobs()))
+		}
+		time.Sleep(10 * time.Millisecond)
+		jobManager.ReapChildren()
+	}
+}
+
+func TestBackgroundJobIsTracked(t *testing.T) {
+	useTempCWD(t)
+	jobManager := NewJobManager()
+	cmd, err := NewCommand("sleep 0.2 &", jobManager)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = NewSyncWriter(&stderr)
+	start := time.Now()
+	cmd.Run()
+
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("background command blocked for %v", elapsed)
+	}
+	jobs := jobManager.ListJobs()
+	if len(jobs) != 1 || jobs[0].Command != "sleep 0.2 &" {
+		t.Fatalf("ListJobs() = %v, want the sleep job", jobs)
+	}
+
+	<-jobs[0].done
+	if !strings.HasPrefix(stderr.String(), "[1] ") {
+		t.Errorf("stderr = %q, want job announcement", stderr.String())
+	}
+	jobManager.ReapChildren()
+	if remaining := jobManager.ListJobs(); len(remaining) != 0 {
+		t.Errorf("ListJobs() after reaping = %v, want none", remaining)
+	}
+}
+
+func TestBackgroundJobExitCode(t *testing.T) {
+	useTempCWD(t)
+	falsePath, err := exec.LookPath("false")
+	if err != nil {
+		t.Skip("no false in PATH")
+	}
+	jobManager := NewJobManager()
+	var notices bytes.Buffer
+	jobManager.Output = &notices
+	input := falsePath + " &"
+	cmd, err := NewCommand(input, jobManager)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Stderr = NewSyncWriter(&bytes.Buffer{})
+	cmd.Run()
+
+	tracked := jobManager.ListJobs()
+	if len(tracked) != 1 {
+		t.Fatalf("ListJobs() = %v, want the false job", tracked)
+	}
+	<-tracked[0].Done()
+	if tracked[0].ExitCode != 1 || jobManager.JobStatus(tracked[0]) != "Done(1)" {
+		t.Errorf("false job = (%d, %q), want (1, %q)", tracked[0].ExitCode, jobManager.JobStatus(tracked[0]), "Done(1)")
+	}
+
+	var stdout bytes.Buffer
+	list := &Command{Stdout: &stdout, JobManager: jobManager}
+	if err := jobs(list); err != nil {
+		t.Fatalf("jobs returned error: %v", err)
+	}
+	if expected := "[1] Done(1) " + input + "\n"; stdout.String() != expected {
+		t.Errorf("jobs = %q, want %q", stdout.String(), expected)
+	}
+
+	jobManager.ReapChildren()
+	if expected := "[1]+ Done(1) " + input + "\n"; notices.String() != expected {
+		t.Errorf("reap notice = %q, want %q", notices.String(), expected)
+	}
+}
+
+func TestLastBackgroundPID(t *testing.T) {
+	useTempCWD(t)
+	defer GetGlobalState().SetLastBackgroundPID(0)
+	jobManager := NewJobManager()
+
+	for _, input := range []string{"sleep 0.1 &", "sleep 0.1 | sleep 0.1 &"} {
+		cmd, err := NewCommand(input, jobManager)
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = NewSyncWriter(&stderr)
+		cmd.Run()
+
+		var id, pid int
+		if _, err := fmt.Sscanf(stderr.String(), "[%d] %d", &id, &pid); err != nil {
+			t.Fatalf("%q reported %q: %v", input, stderr.String(), err)
+		}
+		if output := runEcho(t, "echo $!"); output != fmt.Sprintf("%d\n", pid) {
+			t.Errorf("$! after %q = %q, want the PID %d of its last command", input, output, pid)
+		}
+	}
+	for _, job := range jobManager.ListJobs() {
+		<-job.Done()
+	}
+}
+
+func TestBackgroundSeparator(t *testing.T) {
+	useTempCWD(t)
+	jobManager := NewJobManager()
+	cmd, err := NewCommand("sleep 1 & echo after", jobManager)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = NewSyncWriter(&stdout)
+	cmd.Stderr = NewSyncWriter(&stderr)
+	start := time.Now()
+	cmd.Run()
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("echo after waited %v for the background sleep", elapsed)
+	}
+	if stdout.String() != "after\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "after\n")
+	}
+	jobs := jobManager.ListJobs()
+	if len(jobs) != 1 || jobs[0].Command != "sleep 1 &" {
+		t.Fatalf("ListJobs() = %v, want the sleep job", jobs)
+	}
+	if status := jobManager.JobStatus(jobs[0]); status != "Running" {
+		t.Errorf("sleep job status = %q right after the line ran, want Running", status)
+	}
+	<-jobs[0].done
+}
+
+func TestBackgroundChain(t *testing.T) {
+	useTempCWD(t)
+	defer GetGlobalState().SetPipeStatus(nil)
+	GetGlobalState().SetPipeStatus([]int{7})
+	jobManager := NewJobManager()
+	jobManager.Output = NewSyncWriter(&bytes.Buffer{})
+	cmd, err := NewCommand("sleep 0.3 && echo chained & false && echo no &", jobManager)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = NewSyncWriter(&stdout)
+	cmd.Stderr = NewSyncWriter(&bytes.Buffer{})
+	start := time.Now()
+	cmd.Run()
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("background chains blocked for %v", elapsed)
+	}
+	chained, ok := jobManager.GetJob(1)
+	if !ok || chained.Command != "sleep 0.3 && echo chained &" {
+		t.Fatalf("job 1 = %v, want the whole sleep && echo chain", chained)
+	}
+	failed, ok := jobManager.GetJob(2)
+	if !ok {
+		t.Fatalf("job 2 missing, want the false && echo chain")
+	}
+	if err := jobManager.ForegroundJob(chained.ID); err != nil {
+		t.Errorf("fg of the chain returned %v", err)
+	}
+	if stdout.String() != "chained\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "chained\n")
+	}
+	if code := jobManager.WaitJob(failed); code != 1 {
+		t.Errorf("false && echo no & exited %d, want 1", code)
+	}
+	if status := lookupVariable("?"); status != "7" {
+		t.Errorf("$? = %q after background chains ran, want it left at 7", status)
+	}
+}
+
+func TestBackgroundSubshellRefused(t *testing.T) {
+	jobManager := NewJobManager()
+	for _, input := range []string{"(echo sub) &", "true && echo $(echo sub) &"} {
+		cmd, err := NewCommand(input, jobManager)
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		var stderr bytes.Buffer
+		cmd.Stdout = NewSyncWriter(&bytes.Buffer{})
+		cmd.Stderr = NewSyncWriter(&stderr)
+		cmd.Run()
+		for _, job := range jobManager.ListJobs() {
+			jobManager.WaitJob(job)
+		}
+		if !strings.Contains(stderr.String(), "subshells are not supported in the background") {
+			t.Errorf("%q printed %q, want the subshell refused", input, stderr.String())
+		}
+	}
+}
+
+func TestResolveJobSpec(t *testing.T) {
+	jobManager := NewJobManager()
+	jobManager.AddJob("sleep 100 &", nil)
+	jobManager.AddJob("vim notes.txt", nil)
+	jobManager.AddJob("vi other.txt", nil)
+
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{"2", 2, false},
+		{"%1", 1, false},
+		{"%sl", 1, false},
+		{"%vim", 2, false},
+		{"%v", 0, true},
+		{"%nope", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := jobManager.ResolveJobSpec(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveJobSpec(%q) = %d, %v, want %d (error %v)", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWhenDone(t *testing.T) {
+	jobManager := NewJobManager()
+	job := jobManager.AddJob("work &", nil)
+	release := make(chan struct{})
+	jobManager.WaitInBackground(job, func() int {
+		<-release
+		return 3
+	})
+
+	codes := make(chan int, 2)
+	record := func(job *Job) { codes <- job.ExitCode }
+	jobManager.WhenDone(job, record)
+	select {
+	case <-codes:
+		t.Fatal("WhenDone called its function before the job finished")
+	default:
+	}
+
+	close(release)
+	<-job.done
+	jobManager.WhenDone(job, record)
+	for i := 0; i < 2; i++ {
+		select {
+		case code := <-codes:
+			if code != 3 {
+				t.Errorf("ExitCode = %d, want 3", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("WhenDone never called its function")
+		}
+	}
+	if job.EndTime.IsZero() {
+		t.Error("EndTime was not set when the job finished")
+	}
+}
+
+func TestForegroundJobStoppedAgain(t *testing.T) {
+	jobManager := NewJobManager()
+	jobManager.Output = NewSyncWriter(&bytes.Buffer{})
+	process := exec.Command("sleep", "30")
+	if err := process.Start(); err != nil {
+		t.Fatalf("Failed to start sleep: %v", err)
+	}
+	job := jobManager.AddJob("sleep 30 &", process)
+	jobManager.WaitInBackground(job, func() int {
+		process.Wait()
+		return 0
+	})
+	defer process.Process.Kill()
+
+	returned := make(chan error, 1)
+	go func() { returned <- jobManager.ForegroundJob(job.ID) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for jobManager.GetForegroundJob() != job {
+		if time.Now().After(deadline) {
+			t.Fatal("job never reached the foreground")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	jobManager.StopForegroundJob()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Errorf("ForegroundJob returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ForegroundJob kept waiting for a stopped job")
+	}
+	if status := jobManager.JobStatus(job); status != "Stopped" {
+		t.Errorf("status = %q, want %q", status, "Stopped")
+	}
+	if _, exists := jobManager.GetJob(job.ID); !exists {
+		t.Error("stopped job was removed from the job table")
+	}
+}
@@ -0,0 +1,232 @@
+package gosh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// keyBinding is what a key bound with bindkey does: run a named action
+// or, with bindkey -s, insert a string.
+type keyBinding struct {
+	action string
+	text   string
+}
+
+// keyAction edits the line when its key is pressed. It gets the line, the
+// cursor position and the previous command line, and returns the new
+// line and cursor position.
+type keyAction func(line []rune, pos int, previous string) ([]rune, int)
+
+var keyActions = map[string]keyAction{
+	"beginning-of-line": func(line []rune, _ int, _ string) ([]rune, int) {
+		return line, 0
+	},
+	"end-of-line": func(line []rune, _ int, _ string) ([]rune, int) {
+		return line, len(line)
+	},
+	"kill-whole-line": func([]rune, int, string) ([]rune, int) {
+		return nil, 0
+	},
+	"clear-screen": func(line []rune, pos int, _ string) ([]rune, int) {
+		fmt.Fprint(ShellOutput, clearScreenSequence)
+		return line, pos
+	},
+	"insert-last-argument": func(line []rune, pos int, previous string) ([]rune, int) {
+		words := strings.Fields(previous)
+		if len(words) == 0 {
+			return line, pos
+		}
+		return insertRunes(line, pos, []rune(words[len(words)-1]))
+	},
+}
+
+var (
+	keyBindings   = make(map[rune]keyBinding)
+	keyBindingsMu sync.RWMutex
+)
+
+// boundKeyBase is added to a bound key by KeyBindingFilter, so that the
+// listener can tell it from a key typed for its own sake. It starts a
+// private use plane that nothing types.
+const boundKeyBase = 0xF0000
+
+func bindKey(key rune, binding keyBinding) {
+	keyBindingsMu.Lock()
+	defer keyBindingsMu.Unlock()
+	keyBindings[key] = binding
+}
+
+func unbindKey(key rune) bool {
+	keyBindingsMu.Lock()
+	defer keyBindingsMu.Unlock()
+	_, ok := keyBindings[key]
+	delete(keyBindings, key)
+	return ok
+}
+
+func lookupKeyBinding(key rune) (keyBinding, bool) {
+	keyBindingsMu.RLock()
+	defer keyBindingsMu.RUnlock()
+	binding, ok := keyBindings[key]
+	return binding, ok
+}
+
+// parseKey parses a key as bindkey takes it: ^X for a control key, ^? for
+// delete, or a single character.
+func parseKey(s string) (rune, error) {
+	runes := []rune(s)
+	switch {
+	case len(runes) == 2 && runes[0] == '^' && runes[1] == '?':
+		return 127, nil
+	case len(runes) == 2 && runes[0] == '^':
+		c := runes[1]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < '@' || c > '_' {
+			return 0, fmt.Errorf("%s: invalid key", s)
+		}
+		return c - '@', nil
+	case len(runes) == 1:
+		return runes[0], nil
+	}
+	return 0, fmt.Errorf("%s: invalid key", s)
+}
+
+// formatKey is the inverse of parseKey.
+func formatKey(key rune) string {
+	switch {
+	case key == 127:
+		return "^?"
+	case key < ' ':
+		return "^" + string(key+'@')
+	}
+	return string(key)
+}
+
+// KeyBindingFilter is a readline input filter that marks keys bound with
+// bindkey so that KeyBindingListener handles them instead of readline.
+func KeyBindingFilter(r rune) (rune, bool) {
+	if _, ok := lookupKeyBinding(r); ok {
+		return boundKeyBase + r, true
+	}
+	return r, true
+}
+
+// KeyBindingListener returns a readline listener that runs the binding of
+// each key marked by KeyBindingFilter. previous returns the last command
+// line, for actions such as insert-last-argument.
+func KeyBindingListener(previous func() string) func(line []rune, pos int, key rune) ([]rune, int, bool) {
+	return func(line []rune, pos int, key rune) ([]rune, int, bool) {
+		if key < boundKeyBase {
+			return nil, 0, false
+		}
+		binding, ok := lookupKeyBinding(key - boundKeyBase)
+		if !ok || pos == 0 || pos > len(line) || line[pos-1] != key {
+			return nil, 0, false
+		}
+
+		// Readline has inserted the marked key; take it out again.
+		edited := append(append([]rune{}, line[:pos-1]...), line[pos:]...)
+		pos--
+		if binding.action == "" {
+			edited, pos = insertRunes(edited, pos, []rune(binding.text))
+		} else if action, ok := keyActions[binding.action]; ok {
+			edited, pos = action(edited, pos, previous())
+		}
+		return edited, pos, true
+	}
+}
+
+func insertRunes(line []rune, pos int, text []rune) ([]rune, int) {
+	result := append(append(append([]rune{}, line[:pos]...), text...), line[pos:]...)
+	return result, pos + len(text)
+}
+
+// bindkey implements `bindkey KEY ACTION`, `bindkey -s KEY STRING`,
+// `bindkey -r KEY` and `bindkey -l`. Without arguments it lists the
+// bindings.
+func bindkey(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	parts := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	args := make([]string, len(parts))
+	for i, part := range parts {
+		args[i] = unquoteArg(part)
+	}
+	cmd.ReturnCode = 0
+
+	switch {
+	case len(args) == 0:
+		return listKeyBindings(cmd)
+	case len(args) == 1 && args[0] == "-l":
+		names := make([]string, 0, len(keyActions))
+		for name := range keyActions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, err := fmt.Fprintln(cmd.Stdout, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case len(args) == 2 && args[0] == "-r":
+		key, err := parseKey(args[1])
+		if err != nil {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("bindkey: %v", err)
+		}
+		if !unbindKey(key) {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("bindkey: %s: not bound", args[1])
+		}
+		return nil
+	case len(args) == 3 && args[0] == "-s":
+		key, err := parseKey(args[1])
+		if err != nil {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("bindkey: %v", err)
+		}
+		bindKey(key, keyBinding{text: args[2]})
+		return nil
+	case len(args) == 2 && !strings.HasPrefix(args[0], "-"):
+		key, err := parseKey(args[0])
+		if err != nil {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("bindkey: %v", err)
+		}
+		if _, ok := keyActions[args[1]]; !ok {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("bindkey: %s: unknown action", args[1])
+		}
+		bindKey(key, keyBinding{action: args[1]})
+		return nil
+	}
+	cmd.ReturnCode = 2
+	return fmt.Errorf("Usage: bindkey [-l | -r KEY | -s KEY STRING | KEY ACTION]")
+}
+
+func listKeyBindings(cmd *Command) error {
+	keyBindingsMu.RLock()
+	lines := make([]string, 0, len(keyBindings))
+	for key, binding := range keyBindings {
+		if binding.action == "" {
+			lines = append(lines, fmt.Sprintf("bindkey -s %s %s", formatKey(key), shellQuote(binding.text)))
+		} else {
+			lines = append(lines, fmt.Sprintf("bindkey %s %s", formatKey(key), binding.action))
+		}
+	}
+	keyBindingsMu.RUnlock()
+
+	sort.Strings(lines)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(cmd.Stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package gosh
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected rune
+	}{
+		{"^G", 7},
+		{"^g", 7},
+		{"^?", 127},
+		{"^[", 27},
+		{"x", 'x'},
+	}
+	for _, tt := range tests {
+		key, err := parseKey(tt.input)
+		if err != nil || key != tt.expected {
+			t.Errorf("parseKey(%q) = (%d, %v), want %d", tt.input, key, err, tt.expected)
+		}
+		if formatted := formatKey(key); tt.input != "^g" && formatted != tt.input {
+			t.Errorf("formatKey(%d) = %q, want %q", key, formatted, tt.input)
+		}
+	}
+	for _, input := range []string{"", "^1", "ab"} {
+		if _, err := parseKey(input); err == nil {
+			t.Errorf("parseKey(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestBindkeyBuiltin(t *testing.T) {
+	defer unbindKey(7)
+	defer unbindKey(24)
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"bindkey ^G insert-last-argument", "", 0},
+		{"bindkey -s ^X 'git status'", "", 0},
+		{"bindkey", "bindkey -s ^X 'git status'\nbindkey ^G insert-last-argument\n", 0},
+		{"bindkey ^G no-such-action", "", 1},
+		{"bindkey ^1 clear-screen", "", 1},
+		{"bindkey -r ^X", "", 0},
+		{"bindkey -r ^X", "", 1},
+		{"bindkey", "bindkey ^G insert-last-argument\n", 0},
+		{"bindkey -s ^X", "", 2},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}
+
+func TestKeyBindingListener(t *testing.T) {
+	defer unbindKey(7)
+	defer unbindKey(24)
+	bindKey(7, keyBinding{action: "insert-last-argument"})
+	bindKey(24, keyBinding{text: "status"})
+	listener := KeyBindingListener(func() string { return "cp notes.txt /tmp/backup" })
+
+	tests := []struct {
+		line     string
+		pos      int
+		key      rune
+		expected string
+		newPos   int
+	}{
+		{"ls ", 3, 7, "ls /tmp/backup", 14},
+		{"git  -s", 4, 24, "git status -s", 10},
+	}
+	for _, tt := range tests {
+		marked, ok := KeyBindingFilter(tt.key)
+		if !ok || marked == tt.key {
+			t.Fatalf("KeyBindingFilter(%d) = (%d, %v), want a marked key", tt.key, marked, ok)
+		}
+		line := []rune(tt.line)
+		line = append(line[:tt.pos:tt.pos], append([]rune{marked}, line[tt.pos:]...)...)
+		edited, pos, ok := listener(line, tt.pos+1, marked)
+		if !ok || string(edited) != tt.expected || pos != tt.newPos {
+			t.Errorf("key %d in %q = (%q, %d, %v), want (%q, %d)", tt.key, tt.line, string(edited), pos, ok, tt.expected, tt.newPos)
+		}
+	}
+
+	if _, _, ok := listener([]rune("ls"), 2, 's'); ok {
+		t.Errorf("listener handled an unbound key")
+	}
+}
@@ -1,6 +1,7 @@
 package m28
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"strings"
@@ -56,6 +57,37 @@ func (i *Interpreter) REPL() {
 	}
 }
 
+// REPLWithIO runs a Read-Eval-Print Loop over the given streams without
+// line editing, so it can be embedded in a shell. It returns on EOF,
+// `exit`, `quit` or `(exit)`.
+func (i *Interpreter) REPLWithIO(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		if _, err := fmt.Fprint(out, "m28> "); err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		input := strings.TrimSpace(scanner.Text())
+		if input == "exit" || input == "quit" || input == "(exit)" {
+			return nil
+		}
+		if input == "" {
+			continue
+		}
+
+		result, err := i.Execute(input)
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+		} else {
+			fmt.Fprintln(out, "=>", result)
+		}
+	}
+}
+
 // RunREPL creates a new interpreter and starts the REPL
 func RunREPL() {
 	interpreter := NewInterpreter()
@@ -0,0 +1,65 @@
+package gosh
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"gosh/m28"
+)
+
+// The M28 interpreter is shared by every m28 invocation so definitions
+// made in one persist into the next. It is created on first use and, since
+// it is not safe for concurrent use, m28Mu is held while it runs.
+var (
+	m28Once        sync.Once
+	m28Mu          sync.Mutex
+	m28Interpreter *m28.Interpreter
+)
+
+// withM28 runs fn with the shared interpreter, creating it if need be.
+// Other m28 commands wait until fn returns, so a REPL holds the
+// interpreter until it ends.
+func withM28(fn func(*m28.Interpreter) error) error {
+	m28Once.Do(func() { m28Interpreter = m28.NewInterpreter() })
+	m28Mu.Lock()
+	defer m28Mu.Unlock()
+	return fn(m28Interpreter)
+}
+
+// runM28 implements `m28 [--repl]`, `m28 -f FILE` and `m28 EXPRESSION`.
+// With no arguments or --repl it starts an M28 REPL on the command's stdio.
+func runM28(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		args = cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	}
+
+	if len(args) == 0 || (len(args) == 1 && args[0] == "--repl") {
+		return withM28(func(interpreter *m28.Interpreter) error {
+			return interpreter.REPLWithIO(cmd.Stdin, cmd.Stdout)
+		})
+	}
+
+	if len(args) == 2 && args[0] == "-f" {
+		path := resolvePath(GetGlobalState().GetCWD(), args[1])
+		return withM28(func(interpreter *m28.Interpreter) error {
+			return interpreter.ExecuteFile(path)
+		})
+	}
+
+	for i, arg := range args {
+		args[i] = unquoteArg(arg)
+	}
+	var result string
+	err := withM28(func(interpreter *m28.Interpreter) error {
+		var err error
+		result, err = interpreter.Execute(strings.Join(args, " "))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(cmd.Stdout, result)
+	return err
+}
@@ -0,0 +1,115 @@
+package gosh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gosh/m28"
+)
+
+func TestM28REPL(t *testing.T) {
+	cmd, err := NewCommand("m28 --repl", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader("(define x 20)\n(+ x 22)\n(undefined-function)\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	if cmd.ReturnCode != 0 || stderr.Len() != 0 {
+		t.Fatalf("m28 --repl = (%d, %q), want success", cmd.ReturnCode, stderr.String())
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "=> 42") {
+		t.Errorf("REPL output = %q, want it to contain %q", output, "=> 42")
+	}
+	if !strings.Contains(output, "Error:") {
+		t.Errorf("REPL output = %q, want the evaluation error reported", output)
+	}
+
+	// The REPL shares the shell's interpreter, so x is still defined.
+	cmd, err = NewCommand("m28", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	stdout.Reset()
+	cmd.Stdin = strings.NewReader("(* x 2)\n")
+	cmd.Stdout = &stdout
+	cmd.Run()
+	if !strings.Contains(stdout.String(), "=> 40") {
+		t.Errorf("second REPL output = %q, want it to contain %q", stdout.String(), "=> 40")
+	}
+}
+
+func TestM28REPLExit(t *testing.T) {
+	cmd, err := NewCommand("m28", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin = strings.NewReader("(exit)\n(+ 1 2)\n")
+	cmd.Stdout = &stdout
+	cmd.Run()
+
+	if strings.Contains(stdout.String(), "=> 3") {
+		t.Errorf("REPL output = %q, want it to stop at (exit)", stdout.String())
+	}
+}
+
+func TestM28StatePersistsAcrossCommands(t *testing.T) {
+	runEcho(t, "m28 (define persisted 5)")
+	if output := runEcho(t, "m28 (+ persisted 1)"); output != "6\n" {
+		t.Errorf("m28 (+ persisted 1) = %q, want %q", output, "6\n")
+	}
+}
+
+func TestM28RunsFile(t *testing.T) {
+	dir := useTempCWD(t)
+	if err := os.WriteFile(filepath.Join(dir, "defs.m28"), []byte("(define m28-file-base 40)\n(define m28-file-value (+ m28-file-base 2))\n"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if output := runEcho(t, "m28 -f defs.m28"); output != "" {
+		t.Errorf("m28 -f defs.m28 = %q, want no output", output)
+	}
+	if output := runEcho(t, "m28 m28-file-value"); output != "42\n" {
+		t.Errorf("m28 m28-file-value = %q, want %q", output, "42\n")
+	}
+}
+
+func TestM28ConcurrentUse(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			define := fmt.Sprintf("(define concurrent-%d %d)", i, i)
+			double := fmt.Sprintf("(* concurrent-%d 2)", i)
+			var result string
+			err := withM28(func(interpreter *m28.Interpreter) error {
+				if _, err := interpreter.Execute(define); err != nil {
+					return err
+				}
+				var err error
+				result, err = interpreter.Execute(double)
+				return err
+			})
+			if expected := fmt.Sprint(2 * i); err != nil || result != expected {
+				t.Errorf("%s = (%q, %v), want %q", double, result, err, expected)
+			}
+		}(i)
+	}
+
+	// Commands share the interpreter with direct users.
+	if output := runEcho(t, "m28 (+ 1 2)"); output != "3\n" {
+		t.Errorf("m28 (+ 1 2) = %q, want %q", output, "3\n")
+	}
+	wg.Wait()
+}
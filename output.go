@@ -0,0 +1,34 @@
+package gosh
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// SyncWriter serializes writes so that each Write reaches the underlying
+// writer whole, even when several goroutines share it.
+type SyncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewSyncWriter(w io.Writer) *SyncWriter {
+	return &SyncWriter{w: w}
+}
+
+func (s *SyncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// flusher is implemented by buffered writers.
+type flusher interface {
+	Flush() error
+}
+
+// ShellOutput is where the shell itself writes to the terminal: the
+// prompt, job notifications and other messages. Child processes write to
+// os.Stdout directly.
+var ShellOutput io.Writer = NewSyncWriter(os.Stdout)
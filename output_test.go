@@ -0,0 +1,85 @@
+package gosh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// byteWriter writes one byte at a time, yielding in between, so writes
+// that are not serialized interleave.
+type byteWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.mu.Lock()
+		w.buf.WriteByte(b)
+		w.mu.Unlock()
+		runtime.Gosched()
+	}
+	return len(p), nil
+}
+
+func TestSyncWriterKeepsWritesWhole(t *testing.T) {
+	underlying := &byteWriter{}
+	writer := NewSyncWriter(underlying)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				fmt.Fprintf(writer, "[%d]+ Done job-%d-%d\n", i, i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(underlying.buf.String(), "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("got %d lines, want 400", len(lines))
+	}
+	for _, line := range lines {
+		var i, j, k int
+		if _, err := fmt.Sscanf(line, "[%d]+ Done job-%d-%d", &i, &j, &k); err != nil || i != j {
+			t.Errorf("line %q was interleaved with another write", line)
+		}
+	}
+}
+
+func TestJobNotificationsUseOutput(t *testing.T) {
+	var output bytes.Buffer
+	jobManager := NewJobManager()
+	jobManager.Output = &output
+	job := jobManager.AddJob("sleep 1 &", nil)
+	close(job.done)
+
+	jobManager.ReapChildren()
+	if output.String() != "[1]+ Done sleep 1 &\n" {
+		t.Errorf("ReapChildren() wrote %q, want %q", output.String(), "[1]+ Done sleep 1 &\n")
+	}
+}
+
+func TestRunFlushesBufferedOutput(t *testing.T) {
+	useTempCWD(t)
+	cmd, err := NewCommand("echo hi; ls -d /", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var output, stderr bytes.Buffer
+	cmd.Stdout = bufio.NewWriterSize(&output, 4096)
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	if output.String() != "hi\n/\n" {
+		t.Errorf("output after Run = %q, want %q; stderr %q", output.String(), "hi\n/\n", stderr.String())
+	}
+}
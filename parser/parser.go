@@ -9,30 +9,139 @@ import (
 	"github.com/alecthomas/participle/v2/lexer"
 )
 
+// substitution matches a $(...) command substitution, whose parentheses
+// may nest two levels deep.
+const substitution = `\$\((?:[^()'"]|'[^']*'|"[^"]*"|\((?:[^()]|\([^()]*\))*\))*\)`
+
+// quote matches a single- or double-quoted string. Within double quotes a
+// backslash keeps the next character, and a command substitution may hold
+// quotes of its own.
+const quote = `'[^']*'|"(?:[^"\\$]|\\.|` + substitution + `|\$)*"`
+
+// wordChar matches one piece of an unquoted word. A $((...)) arithmetic
+// expansion, $(...) command substitution or ${...} parameter expansion is
+// kept inside its word, operators and spaces included. Parentheses may
+// nest two levels deep. A backslash keeps the next character, so find's
+// \; stays one word.
+const wordChar = `\$\{[^}]*\}|\$\(\((?:[^()]|\((?:[^()]|\([^()]*\))*\))*\)\)|` + substitution + `|\\.|[^\s|><&;'"]`
+
+// group matches a parenthesized list, which is either a subshell or a
+// Lisp expression. Parentheses may nest four levels deep.
+const group = `\((?:[^()'"]|'[^']*'|"[^"]*"|\((?:[^()'"]|'[^']*'|"[^"]*"|\((?:[^()'"]|'[^']*'|"[^"]*"|\([^()]*\))*\))*\))*\)`
+
 var shellLexer = lexer.MustSimple([]lexer.SimpleRule{
 	{Name: "Whitespace", Pattern: `\s+`},
 	{Name: "Pipe", Pattern: `\|`},
 	{Name: "And", Pattern: `&&`},
+	{Name: "Background", Pattern: `&`},
 	{Name: "Redirect", Pattern: `>>|>|<`},
-	{Name: "Quote", Pattern: `'[^']*'|"[^"]*"`},
-	{Name: "Word", Pattern: `[^\s|><&'"]+`},
+	{Name: "Semicolon", Pattern: `;`},
+	// Quoted and unquoted pieces with nothing between them form one
+	// word, so X="a b" and a"$x"b each stay whole. A word that starts
+	// with a quote is a Quote.
+	{Name: "Quote", Pattern: `(?:` + quote + `)(?:` + quote + `|` + wordChar + `)*`},
+	{Name: "Group", Pattern: group},
+	{Name: "Word", Pattern: `(?:` + wordChar + `)(?:` + quote + `|` + wordChar + `)*`},
 })
 
+// Command is a list of && chains, each ended by ';', '&' or the end of
+// the input.
 type Command struct {
 	AndCommands []*AndCommand `parser:"@@+"`
 }
 
+// AndCommand is a chain of pipelines joined by &&. A '&' after it runs
+// the whole chain in the background.
 type AndCommand struct {
-	Pipelines []*Pipeline `parser:"@@ ( '&&' @@ )*"`
+	Pipelines  []*Pipeline `parser:"@@ ( '&&' @@ )*"`
+	Background bool        `parser:"( @'&' | ';' )?"`
 }
 
+// A Pipeline is a list of commands joined by pipes.
 type Pipeline struct {
 	Commands []*SimpleCommand `parser:"@@ ( '|' @@ )*"`
 }
 
+// IfClause is `if LIST; then LIST; [elif LIST; then LIST;]... [else LIST;] fi`.
+type IfClause struct {
+	Condition *List         `parser:"'if' @@"`
+	Then      *List         `parser:"'then' @@"`
+	Elifs     []*ElifClause `parser:"@@*"`
+	Else      *List         `parser:"( 'else' @@ )? 'fi'"`
+}
+
+type ElifClause struct {
+	Condition *List `parser:"'elif' @@"`
+	Then      *List `parser:"'then' @@"`
+}
+
+// ForClause is `for NAME [in WORD...]; do LIST; done`. Without `in` the
+// loop runs over the positional parameters.
+type ForClause struct {
+	Variable string   `parser:"'for' @Word"`
+	In       bool     `parser:"( @'in'"`
+	Words    []string `parser:"  @(Word | Quote)* )? ';'"`
+	Body     *List    `parser:"'do' @@ 'done'"`
+}
+
+// WhileClause is `while LIST; do LIST; done` or, when Until is set, the
+// same with `until`.
+type WhileClause struct {
+	Until     bool  `parser:"( 'while' | @'until' )"`
+	Condition *List `parser:"@@"`
+	Body      *List `parser:"'do' @@ 'done'"`
+}
+
+// Subshell is `( LIST )`. A Lisp expression looks the same, so the text is
+// kept for the executor to tell them apart. Body is nil, and Err says why,
+// when the text inside the parentheses is not a valid list.
+type Subshell struct {
+	Text string
+	Body *Command
+	Err  error
+}
+
+// Capture parses the list inside the parentheses of a Group token. It
+// never fails, since the text may be Lisp rather than a list.
+func (s *Subshell) Capture(values []string) error {
+	s.Text = values[0]
+	inner := s.Text[1 : len(s.Text)-1]
+	if strings.TrimSpace(inner) == "" {
+		s.Err = fmt.Errorf("syntax error near unexpected token `)'")
+		return nil
+	}
+	s.Body, s.Err = parser.ParseString("", inner)
+	if s.Err != nil {
+		s.Body = nil
+		s.Err = fmt.Errorf("parse error: %v", s.Err)
+	}
+	return nil
+}
+
+// List is a sequence of commands each terminated by a semicolon or '&',
+// as found in the parts of an if clause.
+type List struct {
+	AndCommands []*AndCommand `parser:"@@+"`
+}
+
+// SimpleCommand is one stage of a pipeline: a command and its arguments,
+// or a compound command or subshell, followed by its redirections. Its
+// words never start with a reserved word, so that the words ending a list
+// inside a compound command are not taken as commands. Parenthesized
+// words after the first are embedded Lisp expressions.
 type SimpleCommand struct {
-	Parts     []string    `parser:"@(Word | Quote)+"`
-	Redirects []*Redirect `parser:"@@*"`
+	If        *IfClause    `parser:"( @@"`
+	For       *ForClause   `parser:"| @@"`
+	While     *WhileClause `parser:"| @@"`
+	Subshell  *Subshell    `parser:"| @Group"`
+	Parts     []string     `parser:"| (?! 'then' | 'elif' | 'else' | 'fi' | 'do' | 'done' ) @(Word | Quote) @(Word | Quote | Group)* )"`
+	Redirects []*Redirect  `parser:"@@*"`
+}
+
+// IsCompound reports whether the stage is a compound command or subshell
+// rather than a simple command.
+func (cmd *SimpleCommand) IsCompound() bool {
+	return cmd.If != nil || cmd.For != nil || cmd.While != nil || cmd.Subshell != nil
 }
 
 type Redirect struct {
@@ -45,10 +154,17 @@ var parser = participle.MustBuild[Command](
 	participle.Elide("Whitespace"),
 )
 
+// MaxInputLength is the longest command line Parse accepts. It is well
+// above the kernel's limit on the arguments of a single exec.
+const MaxInputLength = 4 << 20
+
 func Parse(input string) (*Command, error) {
 	if strings.TrimSpace(input) == "" {
 		return nil, fmt.Errorf("empty input")
 	}
+	if len(input) > MaxInputLength {
+		return nil, fmt.Errorf("input too long: %d bytes exceeds the limit of %d", len(input), MaxInputLength)
+	}
 
 	command, err := parser.ParseString("", input)
 	if err != nil {
@@ -63,33 +179,82 @@ func Parse(input string) (*Command, error) {
 	return command, nil
 }
 
-func ProcessCommand(cmd *SimpleCommand) (string, []string, string, string, string, string) {
+// Redirections describes where a simple command reads its input and
+// writes its output.
+type Redirections struct {
+	// Input is the file named by the last < redirection, if any.
+	Input string
+	// Outputs lists the > and >> redirections in order. Every file is
+	// opened, but only the last one receives the output.
+	Outputs []OutputRedirection
+}
+
+type OutputRedirection struct {
+	File   string
+	Append bool
+}
+
+// ProcessCommand2 splits a simple command into its name, its arguments and
+// its redirections.
+func ProcessCommand2(cmd *SimpleCommand) (string, []string, Redirections) {
 	if len(cmd.Parts) == 0 {
-		return "", nil, "", "", "", ""
+		return "", nil, Redirections{}
+	}
+	return cmd.Parts[0], cmd.Parts[1:], RedirectionsOf(cmd.Redirects)
+}
+
+// RedirectionsOf collects a list of redirects into Redirections.
+func RedirectionsOf(redirects []*Redirect) Redirections {
+	var redirections Redirections
+	for _, redirect := range redirects {
+		switch redirect.Type {
+		case "<":
+			redirections.Input = redirect.File
+		case ">", ">>":
+			redirections.Outputs = append(redirections.Outputs, OutputRedirection{
+				File:   redirect.File,
+				Append: redirect.Type == ">>",
+			})
+		}
 	}
+	return redirections
+}
 
-	command := cmd.Parts[0]
-	args := cmd.Parts[1:]
+// ProcessCommand is the positional form of ProcessCommand2, reporting only
+// the last input and output redirection.
+func ProcessCommand(cmd *SimpleCommand) (string, []string, string, string, string, string) {
+	command, args, redirections := ProcessCommand2(cmd)
 	var inputRedirectType, inputFilename, outputRedirectType, outputFilename string
 
-	for _, redirect := range cmd.Redirects {
-		if redirect.Type == "<" {
-			inputRedirectType = redirect.Type
-			inputFilename = redirect.File
-		} else if redirect.Type == ">" || redirect.Type == ">>" {
-			outputRedirectType = redirect.Type
-			outputFilename = redirect.File
+	if redirections.Input != "" {
+		inputRedirectType = "<"
+		inputFilename = redirections.Input
+	}
+	if n := len(redirections.Outputs); n > 0 {
+		last := redirections.Outputs[n-1]
+		outputRedirectType = ">"
+		if last.Append {
+			outputRedirectType = ">>"
 		}
+		outputFilename = last.File
 	}
 
 	return command, args, inputRedirectType, inputFilename, outputRedirectType, outputFilename
 }
 
 func FormatCommand(cmd *Command) string {
+	return formatList(cmd.AndCommands)
+}
+
+func formatList(andCommands []*AndCommand) string {
 	var result strings.Builder
-	for i, andCmd := range cmd.AndCommands {
+	for i, andCmd := range andCommands {
 		if i > 0 {
-			result.WriteString(" && ")
+			if andCommands[i-1].Background {
+				result.WriteString(" ")
+			} else {
+				result.WriteString("; ")
+			}
 		}
 		for j, pipeline := range andCmd.Pipelines {
 			if j > 0 {
@@ -97,6 +262,9 @@ func FormatCommand(cmd *Command) string {
 			}
 			result.WriteString(formatPipeline(pipeline))
 		}
+		if andCmd.Background {
+			result.WriteString(" &")
+		}
 	}
 	return result.String()
 }
@@ -107,13 +275,77 @@ func formatPipeline(pipeline *Pipeline) string {
 		if j > 0 {
 			result.WriteString(" | ")
 		}
-		result.WriteString(strings.Join(simpleCmd.Parts, " "))
-		for _, redirect := range simpleCmd.Redirects {
-			result.WriteString(" ")
-			result.WriteString(redirect.Type)
-			result.WriteString(" ")
-			result.WriteString(redirect.File)
+		switch {
+		case simpleCmd.If != nil:
+			result.WriteString(formatIf(simpleCmd.If))
+		case simpleCmd.For != nil:
+			result.WriteString(formatFor(simpleCmd.For))
+		case simpleCmd.While != nil:
+			result.WriteString(formatWhile(simpleCmd.While))
+		case simpleCmd.Subshell != nil:
+			result.WriteString(simpleCmd.Subshell.Text)
+		default:
+			result.WriteString(strings.Join(simpleCmd.Parts, " "))
+		}
+		result.WriteString(formatRedirects(simpleCmd.Redirects))
+	}
+	return result.String()
+}
+
+func formatRedirects(redirects []*Redirect) string {
+	var result strings.Builder
+	for _, redirect := range redirects {
+		result.WriteString(" ")
+		result.WriteString(redirect.Type)
+		result.WriteString(" ")
+		result.WriteString(redirect.File)
+	}
+	return result.String()
+}
+
+// formatBody formats a list inside a compound command, ending it with
+// the ';' that must follow it unless it already ends with '&'.
+func formatBody(list *List) string {
+	andCommands := list.AndCommands
+	if andCommands[len(andCommands)-1].Background {
+		return formatList(andCommands)
+	}
+	return formatList(andCommands) + ";"
+}
+
+func formatIf(clause *IfClause) string {
+	var result strings.Builder
+	result.WriteString("if " + formatBody(clause.Condition))
+	result.WriteString(" then " + formatBody(clause.Then))
+	for _, elif := range clause.Elifs {
+		result.WriteString(" elif " + formatBody(elif.Condition))
+		result.WriteString(" then " + formatBody(elif.Then))
+	}
+	if clause.Else != nil {
+		result.WriteString(" else " + formatBody(clause.Else))
+	}
+	result.WriteString(" fi")
+	return result.String()
+}
+
+func formatFor(clause *ForClause) string {
+	var result strings.Builder
+	result.WriteString("for " + clause.Variable)
+	if clause.In {
+		result.WriteString(" in")
+		for _, word := range clause.Words {
+			result.WriteString(" " + word)
 		}
 	}
+	result.WriteString("; do " + formatBody(clause.Body) + " done")
 	return result.String()
 }
+
+func formatWhile(clause *WhileClause) string {
+	keyword := "while"
+	if clause.Until {
+		keyword = "until"
+	}
+	return keyword + " " + formatBody(clause.Condition) +
+		" do " + formatBody(clause.Body) + " done"
+}
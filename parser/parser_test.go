@@ -2,6 +2,7 @@ package parser
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -90,6 +91,207 @@ func TestParseValidInputs(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "Background pipeline",
+			input: "sleep 10 &",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{
+								Commands: []*SimpleCommand{
+									{Parts: []string{"sleep", "10"}},
+								},
+							},
+						},
+						Background: true,
+					},
+				},
+			},
+		},
+		{
+			name:  "Background separator",
+			input: "sleep 1 & echo after",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"sleep", "1"}}}}}, Background: true},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"echo", "after"}}}}}},
+				},
+			},
+		},
+		{
+			name:  "Background chain",
+			input: "sleep 1 && true & date",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{Commands: []*SimpleCommand{{Parts: []string{"sleep", "1"}}}},
+							{Commands: []*SimpleCommand{{Parts: []string{"true"}}}},
+						},
+						Background: true,
+					},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"date"}}}}}},
+				},
+			},
+		},
+		{
+			name:  "Arithmetic expansion",
+			input: "echo $(( (1 << 2) > 3 | 4 )) i=$((i&1))",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{
+								Commands: []*SimpleCommand{
+									{Parts: []string{"echo", "$(( (1 << 2) > 3 | 4 ))", "i=$((i&1))"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:  "Command substitution",
+			input: "echo $(ls | wc -l) x=$(echo (a) 'b)')",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{
+								Commands: []*SimpleCommand{
+									{Parts: []string{"echo", "$(ls | wc -l)", "x=$(echo (a) 'b)')"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:  "Quoted assignment",
+			input: "X=\"a b\"'c' echo=\"x\" --opt=\"y\"",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{
+								Commands: []*SimpleCommand{
+									{Parts: []string{"X=\"a b\"'c'", "echo=\"x\"", "--opt=\"y\""}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:  "Adjacent quotes",
+			input: `echo a"$x"b 'c'd "$(printf 'e"f')"`,
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"echo", `a"$x"b`, "'c'd", `"$(printf 'e"f')"`}}}}}},
+				},
+			},
+		},
+		{
+			name:  "Semicolon separated commands",
+			input: "cd /tmp; ls",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"cd", "/tmp"}}}}}},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"ls"}}}}}},
+				},
+			},
+		},
+		{
+			name:  "If clause",
+			input: "if test -f x; then echo fi; elif false; then echo elif; else echo no; fi && echo done",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{Commands: []*SimpleCommand{{
+								If: &IfClause{
+									Condition: simpleList("test", "-f", "x"),
+									Then:      simpleList("echo", "fi"),
+									Elifs: []*ElifClause{
+										{Condition: simpleList("false"), Then: simpleList("echo", "elif")},
+									},
+									Else: simpleList("echo", "no"),
+								},
+							}}},
+							{Commands: []*SimpleCommand{{Parts: []string{"echo", "done"}}}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:  "For loop",
+			input: "for f in a 'b c' *.txt; do echo $f; done",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{Commands: []*SimpleCommand{{
+								For: &ForClause{
+									Variable: "f",
+									In:       true,
+									Words:    []string{"a", "'b c'", "*.txt"},
+									Body:     simpleList("echo", "$f"),
+								},
+							}}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:  "Subshell",
+			input: "(cd /tmp; ls) | cat > out",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{
+								Commands: []*SimpleCommand{
+									{Subshell: &Subshell{
+										Text: "(cd /tmp; ls)",
+										Body: &Command{AndCommands: []*AndCommand{
+											{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"cd", "/tmp"}}}}}},
+											{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"ls"}}}}}},
+										}},
+									}},
+									{Parts: []string{"cat"}, Redirects: []*Redirect{{Type: ">", File: "out"}}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:  "Until loop with redirection",
+			input: "until read line; do echo $line; done < input.txt",
+			expected: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{Commands: []*SimpleCommand{{
+								While: &WhileClause{
+									Until:     true,
+									Condition: simpleList("read", "line"),
+									Body:      simpleList("echo", "$line"),
+								},
+								Redirects: []*Redirect{{Type: "<", File: "input.txt"}},
+							}}},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -105,6 +307,13 @@ func TestParseValidInputs(t *testing.T) {
 	}
 }
 
+// simpleList returns a List holding a single simple command.
+func simpleList(parts ...string) *List {
+	return &List{AndCommands: []*AndCommand{
+		{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: parts}}}}},
+	}}
+}
+
 func TestParseInvalidInputs(t *testing.T) {
 	testCases := []struct {
 		name  string
@@ -116,6 +325,14 @@ func TestParseInvalidInputs(t *testing.T) {
 		{"Incomplete AND", "ls &&"},
 		{"Invalid redirection", "cat file.txt >"},
 		{"Unmatched quote", "echo 'hello"},
+		{"Unterminated if", "if true; then echo yes;"},
+		{"If without then", "if true; fi"},
+		{"Reserved word as command", "fi"},
+		{"Unterminated for", "for x in a; do echo $x;"},
+		{"For without do", "for x in a; echo $x; done"},
+		{"Background before AND", "sleep 1 & && echo after"},
+		{"Background before semicolon", "echo x & ; echo y"},
+		{"Semicolon after semicolon", "echo x; ; echo y"},
 	}
 
 	for _, tc := range testCases {
@@ -271,6 +488,69 @@ func TestFormatCommand(t *testing.T) {
 			},
 			expected: "mkdir test && cd test",
 		},
+		{
+			name: "Command list",
+			input: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"sleep", "1"}}}}}, Background: true},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"cd", "test"}}}}}},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"ls"}}}}}},
+				},
+			},
+			expected: "sleep 1 & cd test; ls",
+		},
+		{
+			name: "If clause",
+			input: &Command{
+				AndCommands: []*AndCommand{
+					{
+						Pipelines: []*Pipeline{
+							{Commands: []*SimpleCommand{{
+								If: &IfClause{
+									Condition: simpleList("true"),
+									Then:      simpleList("echo", "yes"),
+									Elifs:     []*ElifClause{{Condition: simpleList("false"), Then: simpleList("echo", "maybe")}},
+									Else:      simpleList("echo", "no"),
+								},
+							}}},
+						},
+					},
+				},
+			},
+			expected: "if true; then echo yes; elif false; then echo maybe; else echo no; fi",
+		},
+		{
+			name: "For loop",
+			input: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{For: &ForClause{Variable: "x", In: true, Words: []string{"1", "2"}, Body: simpleList("echo", "$x")}}}}}},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{For: &ForClause{Variable: "y", Body: simpleList("true")}}}}}},
+				},
+			},
+			expected: "for x in 1 2; do echo $x; done; for y; do true; done",
+		},
+		{
+			name: "While loop",
+			input: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{While: &WhileClause{Condition: simpleList("read", "x"), Body: simpleList("echo", "$x")}, Redirects: []*Redirect{{Type: "<", File: "in"}}}}}}},
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{While: &WhileClause{Until: true, Condition: simpleList("false"), Body: simpleList("true")}}}}}},
+				},
+			},
+			expected: "while read x; do echo $x; done < in; until false; do true; done",
+		},
+		{
+			name: "Background in a loop body",
+			input: &Command{
+				AndCommands: []*AndCommand{
+					{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{While: &WhileClause{
+						Condition: simpleList("true"),
+						Body:      &List{AndCommands: []*AndCommand{{Pipelines: []*Pipeline{{Commands: []*SimpleCommand{{Parts: []string{"sleep", "1"}}}}}, Background: true}}},
+					}}}}}},
+				},
+			},
+			expected: "while true; do sleep 1 & done",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -282,3 +562,80 @@ func TestFormatCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessCommand2(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Redirections
+	}{
+		{
+			name:     "No redirection",
+			input:    "echo hello",
+			expected: Redirections{},
+		},
+		{
+			name:     "Input redirection",
+			input:    "cat < input.txt",
+			expected: Redirections{Input: "input.txt"},
+		},
+		{
+			name:     "Output redirection",
+			input:    "echo hello > output.txt",
+			expected: Redirections{Outputs: []OutputRedirection{{File: "output.txt"}}},
+		},
+		{
+			name:     "Append redirection",
+			input:    "echo hello >> output.txt",
+			expected: Redirections{Outputs: []OutputRedirection{{File: "output.txt", Append: true}}},
+		},
+		{
+			name:  "Multiple redirections",
+			input: "sort < in.txt > first.txt >> second.txt",
+			expected: Redirections{
+				Input: "in.txt",
+				Outputs: []OutputRedirection{
+					{File: "first.txt"},
+					{File: "second.txt", Append: true},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := Parse(tc.input)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			_, _, redirections := ProcessCommand2(parsed.AndCommands[0].Pipelines[0].Commands[0])
+			if !reflect.DeepEqual(redirections, tc.expected) {
+				t.Errorf("ProcessCommand2() redirections = %+v, want %+v", redirections, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseLongInput(t *testing.T) {
+	line := "echo " + strings.Repeat(strings.Repeat("a", 99)+" ", 1<<20/100)
+	result, err := Parse(line)
+	if err != nil {
+		t.Fatalf("Parse() of a %d byte line returned error: %v", len(line), err)
+	}
+	if parts := result.AndCommands[0].Pipelines[0].Commands[0].Parts; len(parts) != 1<<20/100+1 {
+		t.Errorf("Parse() of a long line returned %d words, want %d", len(parts), 1<<20/100+1)
+	}
+
+	if _, err := Parse("echo " + strings.Repeat("a", MaxInputLength)); err == nil {
+		t.Errorf("Parse() of a line over MaxInputLength did not return an error")
+	}
+}
+
+func BenchmarkParseLongInput(b *testing.B) {
+	line := "echo " + strings.Repeat("word ", 1<<20/5)
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package gosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// formatSpecifier is one conversion in a printf format string, such as
+// %-5s or %2$d. start and end delimit it within the format.
+type formatSpecifier struct {
+	start, end int
+	position   int // 1-based argument index from N$, or 0 if sequential
+	flags      string
+	width      string
+	precision  string
+	verb       byte
+}
+
+// printfCommand implements `printf [-v NAME] FORMAT [ARGUMENT]...`. As in
+// bash, the format is reused until all arguments are consumed. With -v the
+// output is assigned to the shell variable NAME instead of being printed.
+func printfCommand(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	parts := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	var name string
+	if len(parts) > 0 && parts[0] == "-v" {
+		if len(parts) < 2 {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("-v: option requires an argument")
+		}
+		name, parts = unquoteArg(parts[1]), parts[2:]
+		if !isVariableName(name) {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("`%s': not a valid identifier", name)
+		}
+	}
+	if len(parts) < 1 {
+		cmd.ReturnCode = 2
+		return fmt.Errorf("Usage: printf [-v NAME] FORMAT [ARGUMENT]...")
+	}
+
+	args := make([]string, len(parts)-1)
+	for i, arg := range parts[1:] {
+		args[i] = unquoteArg(arg)
+	}
+
+	output, err := formatPrintf(unquoteArg(parts[0]), args)
+	if name != "" {
+		if setErr := setVariable(name, output); setErr != nil {
+			cmd.ReturnCode = 1
+			return setErr
+		}
+	} else if _, writeErr := fmt.Fprint(cmd.Stdout, output); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		cmd.ReturnCode = 1
+		return err
+	}
+	return nil
+}
+
+// formatPrintf expands format with args. Conversion errors are reported
+// after the whole format has been processed, as bash does.
+func formatPrintf(format string, args []string) (string, error) {
+	specs, err := findFormatSpecifiers(format)
+	if err != nil {
+		return "", err
+	}
+
+	positional, sequential := false, false
+	for _, spec := range specs {
+		if spec.verb == '%' {
+			continue
+		}
+		if spec.position > 0 {
+			positional = true
+		} else {
+			sequential = true
+		}
+	}
+	if positional && sequential {
+		return "", fmt.Errorf("%s: cannot mix positional and sequential arguments", format)
+	}
+
+	var out strings.Builder
+	var firstErr error
+	next := 0
+	for {
+		last := 0
+		consumed := false
+		for _, spec := range specs {
+			text, stop := expandEscapes(format[last:spec.start])
+			out.WriteString(text)
+			if stop {
+				return out.String(), firstErr
+			}
+			last = spec.end
+			if spec.verb == '%' {
+				out.WriteByte('%')
+				continue
+			}
+
+			var arg string
+			index := next
+			if spec.position > 0 {
+				index = spec.position - 1
+			} else {
+				next++
+			}
+			if index < len(args) {
+				arg = args[index]
+				consumed = true
+			}
+			if spec.verb == 'b' {
+				arg, stop = expandEscapes(arg)
+			}
+
+			text, err := formatArgument(spec, arg)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			out.WriteString(text)
+			if stop {
+				return out.String(), firstErr
+			}
+		}
+		text, stop := expandEscapes(format[last:])
+		out.WriteString(text)
+		if stop {
+			break
+		}
+
+		// Positional formats use their arguments once; sequential formats
+		// repeat while unconsumed arguments remain.
+		if positional || !consumed || next >= len(args) {
+			break
+		}
+	}
+	return out.String(), firstErr
+}
+
+// findFormatSpecifiers locates the conversions in a printf format.
+func findFormatSpecifiers(format string) ([]formatSpecifier, error) {
+	var specs []formatSpecifier
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		spec := formatSpecifier{start: i}
+		j := i + 1
+
+		// An N$ prefix selects the argument by position.
+		k := j
+		for k < len(format) && format[k] >= '0' && format[k] <= '9' {
+			k++
+		}
+		if k > j && k < len(format) && format[k] == '$' {
+			position, err := strconv.Atoi(format[j:k])
+			if err != nil || position == 0 {
+				return nil, fmt.Errorf("%s: invalid argument position", format[i:k+1])
+			}
+			spec.position = position
+			j = k + 1
+		}
+
+		k = j
+		for k < len(format) && strings.IndexByte("-+ #0", format[k]) >= 0 {
+			k++
+		}
+		spec.flags = format[j:k]
+		j = k
+		for k < len(format) && format[k] >= '0' && format[k] <= '9' {
+			k++
+		}
+		spec.width = format[j:k]
+		j = k
+		if j < len(format) && format[j] == '.' {
+			k = j + 1
+			for k < len(format) && format[k] >= '0' && format[k] <= '9' {
+				k++
+			}
+			spec.precision = format[j+1 : k]
+			j = k
+		}
+
+		if j >= len(format) {
+			return nil, fmt.Errorf("%s: missing format character", format[i:])
+		}
+		spec.verb = format[j]
+		if strings.IndexByte("%sbcqdiouxXeEfFgG", spec.verb) < 0 {
+			return nil, fmt.Errorf("%%%c: invalid format character", spec.verb)
+		}
+		spec.end = j + 1
+		specs = append(specs, spec)
+		i = j
+	}
+	return specs, nil
+}
+
+func formatArgument(spec formatSpecifier, arg string) (string, error) {
+	layout := "%" + spec.flags + spec.width
+	if spec.precision != "" {
+		layout += "." + spec.precision
+	}
+
+	switch spec.verb {
+	case 's', 'b':
+		// formatPrintf has already expanded the escapes of a %b argument.
+		return fmt.Sprintf(layout+"s", arg), nil
+	case 'c':
+		if arg == "" {
+			return "", nil
+		}
+		return fmt.Sprintf(layout+"c", []rune(arg)[0]), nil
+	case 'q':
+		return fmt.Sprintf(layout+"s", shellQuote(arg)), nil
+	case 'd', 'i':
+		n, err := printfInt(arg)
+		return fmt.Sprintf(layout+"d", n), err
+	case 'o', 'u', 'x', 'X':
+		n, err := printfInt(arg)
+		verb := string(spec.verb)
+		if verb == "u" {
+			verb = "d"
+		}
+		return fmt.Sprintf(layout+verb, uint64(n)), err
+	default:
+		f, err := printfFloat(arg)
+		return fmt.Sprintf(layout+string(spec.verb), f), err
+	}
+}
+
+// shellQuote single-quotes s so that it reads back as one word, closing
+// the quotes around each embedded single quote.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// printfInt converts a numeric argument. A leading quote yields the
+// character code of the next character, as in POSIX printf.
+func printfInt(arg string) (int64, error) {
+	if arg == "" {
+		return 0, nil
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		if len(arg) == 1 {
+			return 0, nil
+		}
+		return int64([]rune(arg[1:])[0]), nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(arg), 0, 64)
+	if err != nil {
+		return n, fmt.Errorf("%s: invalid number", arg)
+	}
+	return n, nil
+}
+
+func printfFloat(arg string) (float64, error) {
+	if arg == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number", arg)
+	}
+	return f, nil
+}
+
+// expandEscapes interprets the backslash escapes printf understands. \c
+// ends the output: what precedes it is returned and stop is true.
+func expandEscapes(s string) (expanded string, stop bool) {
+	if !strings.Contains(s, "\\") {
+		return s, false
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case 'a':
+			out.WriteByte('\a')
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'v':
+			out.WriteByte('\v')
+		case '\\':
+			out.WriteByte('\\')
+		case 'c':
+			return out.String(), true
+		case 'x':
+			// One or two hex digits follow \x; without any it is literal.
+			j := i + 1
+			for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				out.WriteString(`\x`)
+				continue
+			}
+			value, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			out.WriteByte(byte(value))
+			i = j - 1
+		case '0':
+			// Up to three octal digits follow \0.
+			value, j := 0, i+1
+			for ; j < len(s) && j < i+4 && s[j] >= '0' && s[j] <= '7'; j++ {
+				value = value*8 + int(s[j]-'0')
+			}
+			out.WriteByte(byte(value))
+			i = j - 1
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String(), false
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
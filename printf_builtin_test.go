@@ -0,0 +1,77 @@
+package gosh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatPrintf(t *testing.T) {
+	tests := []struct {
+		format   string
+		args     []string
+		expected string
+	}{
+		{`%s\n`, []string{"hello"}, "hello\n"},
+		{`%s-%s\n`, []string{"a", "b", "c"}, "a-b\nc-\n"},
+		{`%d%%\n`, []string{"42"}, "42%\n"},
+		{`%5s|%-5s|\n`, []string{"ab", "cd"}, "   ab|cd   |\n"},
+		{`%05.1f %x %o\n`, []string{"3.14159", "255", "8"}, "003.1 ff 10\n"},
+		{`%c%b`, []string{"xyz", `a\tb`}, "xa\tb"},
+		{`%b|%s\n`, []string{`a\cb`, "c"}, "a"},
+		{`a\cb %s`, []string{"c"}, "a"},
+		{`\x48\x69 %s\n`, []string{"x"}, "Hi x\n"},
+		{`%d`, []string{"'A"}, "65"},
+		{`%2$s %1$s\n`, []string{"world", "hello"}, "hello world\n"},
+		{`%1$s %1$s %2$s`, []string{"again", "done"}, "again again done"},
+		{`%3$s|`, []string{"a"}, "|"},
+		{`no specifiers`, []string{"ignored"}, "no specifiers"},
+		{`%q %q\n`, []string{"two words", "it's"}, `'two words' 'it'"'"'s'` + "\n"},
+		{`%q`, []string{"a;b|c && $(rm x) *"}, `'a;b|c && $(rm x) *'`},
+		{`%q`, []string{""}, "''"},
+	}
+
+	for _, tt := range tests {
+		result, err := formatPrintf(tt.format, tt.args)
+		if err != nil || result != tt.expected {
+			t.Errorf("formatPrintf(%q, %q) = (%q, %v), want %q", tt.format, tt.args, result, err, tt.expected)
+		}
+	}
+}
+
+func TestFormatPrintfErrors(t *testing.T) {
+	tests := []struct {
+		format      string
+		args        []string
+		expectedErr string
+	}{
+		{`%1$s %s`, []string{"a", "b"}, "cannot mix positional and sequential"},
+		{`%0$s`, []string{"a"}, "invalid argument position"},
+		{`%d`, []string{"abc"}, "invalid number"},
+		{`%z`, nil, "invalid format character"},
+		{`100%`, nil, "missing format character"},
+	}
+
+	for _, tt := range tests {
+		if _, err := formatPrintf(tt.format, tt.args); err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+			t.Errorf("formatPrintf(%q, %q) error = %v, want %q", tt.format, tt.args, err, tt.expectedErr)
+		}
+	}
+}
+
+func TestPrintfBuiltinReordersArguments(t *testing.T) {
+	if output := runEcho(t, `printf '%2$s %1$s\n' world hello`); output != "hello world\n" {
+		t.Errorf("printf = %q, want %q", output, "hello world\n")
+	}
+}
+
+func TestPrintfAssignsVariable(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.UnsetVar("padded")
+
+	if output := runEcho(t, `printf -v padded '%05d' 42`); output != "" {
+		t.Errorf("printf -v printed %q, want nothing", output)
+	}
+	if padded, _ := gs.GetVar("padded"); padded != "00042" {
+		t.Errorf("padded = %q, want %q", padded, "00042")
+	}
+}
@@ -0,0 +1,33 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	profileOutput   io.Writer
+	profileOutputMu sync.Mutex
+)
+
+// SetProfileOutput turns on profiling, writing a line to w for each phase
+// of startup and of every command as it finishes. A nil w turns it off.
+func SetProfileOutput(w io.Writer) {
+	profileOutputMu.Lock()
+	defer profileOutputMu.Unlock()
+	profileOutput = w
+}
+
+// ProfilePhase reports how long the phase called label, which began at
+// start, took, when profiling is on. label is a single word, such as
+// "startup.history_db", so that each line splits into three fields.
+func ProfilePhase(label string, start time.Time) {
+	profileOutputMu.Lock()
+	defer profileOutputMu.Unlock()
+	if profileOutput == nil {
+		return
+	}
+	fmt.Fprintf(profileOutput, "profile: %s %v\n", label, time.Since(start).Round(time.Microsecond))
+}
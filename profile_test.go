@@ -0,0 +1,42 @@
+package gosh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfilePhases(t *testing.T) {
+	var profile bytes.Buffer
+	SetProfileOutput(&profile)
+	defer SetProfileOutput(nil)
+
+	stdout, stderr, code := runWithOptions(t, "echo $(echo hi)")
+	if stdout != "hi\n" || code != 0 {
+		t.Fatalf("echo $(echo hi) = (%q, %d), want (%q, 0); stderr %q", stdout, code, "hi\n", stderr)
+	}
+	labels := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(profile.String()), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "profile:" {
+			t.Fatalf("profile line %q, want \"profile: LABEL DURATION\"", line)
+		}
+		if _, err := time.ParseDuration(fields[2]); err != nil {
+			t.Errorf("profile line %q: %v", line, err)
+		}
+		labels[fields[1]] = true
+	}
+	for _, label := range []string{"parse", "expand", "substitution", "lisp", "execute"} {
+		if !labels[label] {
+			t.Errorf("profile %q has no %s phase", profile.String(), label)
+		}
+	}
+
+	profile.Reset()
+	SetProfileOutput(nil)
+	runWithOptions(t, "echo hi")
+	if profile.Len() != 0 {
+		t.Errorf("profile with profiling off = %q, want nothing", profile.String())
+	}
+}
@@ -2,24 +2,106 @@ package gosh
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-var defaultPrompt = "\033[1;36m%u@%h\033[0m:\033[1;34m%w\033[0m$ "
+var (
+	defaultPrompt             = "\033[1;36m%u@%h\033[0m:\033[1;34m%w\033[0m$ "
+	defaultContinuationPrompt = "> "
 
+	promptRenderer   func(PromptContext) string
+	promptRendererMu sync.RWMutex
+)
+
+// PromptContext is the shell state a prompt renderer draws from.
+type PromptContext struct {
+	CWD        string
+	ExitStatus int
+	// Jobs is the number of jobs known to the job manager passed to
+	// RenderPrompt.
+	Jobs     int
+	Username string
+	Hostname string
+	// Template is the prompt the default renderer would expand, from
+	// PS1, GOSH_PROMPT or the default.
+	Template string
+}
+
+// SetPromptRenderer replaces how the primary prompt is built. A nil
+// renderer restores the default, which expands Template.
+func SetPromptRenderer(renderer func(PromptContext) string) {
+	promptRendererMu.Lock()
+	defer promptRendererMu.Unlock()
+	promptRenderer = renderer
+}
+
+// GetPrompt returns the expanded primary prompt, taken from PS1, then
+// GOSH_PROMPT, then the default.
 func GetPrompt() string {
-	customPrompt := os.Getenv("GOSH_PROMPT")
-	if customPrompt == "" {
-		customPrompt = defaultPrompt
+	return RenderPrompt(nil)
+}
+
+// RenderPrompt returns the primary prompt, counting the jobs of
+// jobManager when it is not nil.
+func RenderPrompt(jobManager *JobManager) string {
+	promptRendererMu.RLock()
+	renderer := promptRenderer
+	promptRendererMu.RUnlock()
+	jobs := 0
+	if jobManager != nil {
+		jobs = len(jobManager.ListJobs())
+	}
+	if renderer == nil {
+		return expandPromptVariables(promptTemplate(), jobs)
+	}
+
+	hostname, _ := os.Hostname()
+	ctx := PromptContext{
+		CWD:        GetGlobalState().GetCWD(),
+		ExitStatus: GetGlobalState().GetLastExitStatus(),
+		Username:   os.Getenv("USER"),
+		Hostname:   hostname,
+		Jobs:       jobs,
+		Template:   promptTemplate(),
+	}
+	return renderer(ctx)
+}
+
+// GetContinuationPrompt returns the expanded prompt shown while a command
+// spans several lines, taken from PS2, then GOSH_PROMPT2, then "> ".
+func GetContinuationPrompt() string {
+	for _, name := range []string{"PS2", "GOSH_PROMPT2"} {
+		if prompt := lookupVariable(name); prompt != "" {
+			return expandPromptVariables(prompt, 0)
+		}
+	}
+	return defaultContinuationPrompt
+}
+
+func promptTemplate() string {
+	for _, name := range []string{"PS1", "GOSH_PROMPT"} {
+		if prompt := lookupVariable(name); prompt != "" {
+			return prompt
+		}
 	}
-	return expandPromptVariables(customPrompt)
+	return defaultPrompt
 }
 
-func expandPromptVariables(prompt string) string {
+// expandPromptVariables expands the %X and bash-style \X escapes of a
+// prompt. %E, %C and %J are the exit status, directory and job count
+// segments of a powerline-style prompt, and %P is all three; jobs is the
+// count %J shows.
+func expandPromptVariables(prompt string, jobs int) string {
 	gs := GetGlobalState()
 	username := os.Getenv("USER")
 	hostname, _ := os.Hostname()
+	status := statusSegment(gs.GetLastExitStatus())
+	cwd := cwdSegment(gs.GetCWD())
+	jobCount := jobsSegment(jobs)
+	separator := segmentSeparator()
 
 	replacements := map[string]string{
 		"%u": username,
@@ -29,6 +111,17 @@ func expandPromptVariables(prompt string) string {
 		"%d": time.Now().Format("2006-01-02"),
 		"%t": time.Now().Format("15:04:05"),
 		"%$": "$",
+		"%E": renderSegments([]promptSegment{status}, separator),
+		"%C": renderSegments([]promptSegment{cwd}, separator),
+		"%J": renderSegments([]promptSegment{jobCount}, separator),
+		"%P": renderSegments([]promptSegment{status, cwd, jobCount}, separator),
+		// bash's PS1 escapes
+		"\\u": username,
+		"\\h": hostname,
+		"\\w": shortenPath(gs.GetCWD()),
+		"\\W": filepath.Base(gs.GetCWD()),
+		"\\t": time.Now().Format("15:04:05"),
+		"\\$": "$",
 	}
 
 	for key, value := range replacements {
@@ -47,5 +140,5 @@ func shortenPath(path string) string {
 }
 
 func SetPrompt(newPrompt string) error {
-	return os.Setenv("GOSH_PROMPT", newPrompt)
+	return os.Setenv("PS1", newPrompt)
 }
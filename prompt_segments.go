@@ -0,0 +1,91 @@
+package gosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ANSI colors of the segments of a segmented prompt.
+const (
+	segmentRed    = 1
+	segmentGreen  = 2
+	segmentYellow = 3
+	segmentBlue   = 4
+)
+
+// defaultSegmentSeparator is the powerline arrow drawn between segments
+// unless GOSH_PROMPT_SEPARATOR is set.
+const defaultSegmentSeparator = "\ue0b0"
+
+// promptSegment is one colored part of a segmented prompt.
+type promptSegment struct {
+	text  string
+	color int
+}
+
+// statusSegment shows the exit status of the last command, green when it
+// succeeded and red otherwise.
+func statusSegment(status int) promptSegment {
+	if status == 0 {
+		return promptSegment{text: "0", color: segmentGreen}
+	}
+	return promptSegment{text: strconv.Itoa(status), color: segmentRed}
+}
+
+// cwdSegment shows the working directory with the home directory as ~.
+func cwdSegment(cwd string) promptSegment {
+	return promptSegment{text: shortenPath(cwd), color: segmentBlue}
+}
+
+// jobsSegment shows how many jobs there are, and is empty when there are
+// none.
+func jobsSegment(jobs int) promptSegment {
+	if jobs == 0 {
+		return promptSegment{color: segmentYellow}
+	}
+	return promptSegment{text: fmt.Sprintf("jobs %d", jobs), color: segmentYellow}
+}
+
+// segmentSeparator returns the glyph drawn between prompt segments.
+func segmentSeparator() string {
+	if variableIsSet("GOSH_PROMPT_SEPARATOR") {
+		return lookupVariable("GOSH_PROMPT_SEPARATOR")
+	}
+	return defaultSegmentSeparator
+}
+
+// renderSegments draws segments powerline style: black text on each
+// segment's color, then the separator in that color over the next
+// segment's, so that it reads as an arrow. Empty segments are left out.
+func renderSegments(segments []promptSegment, separator string) string {
+	var shown []promptSegment
+	for _, segment := range segments {
+		if segment.text != "" {
+			shown = append(shown, segment)
+		}
+	}
+
+	var prompt strings.Builder
+	for i, segment := range shown {
+		prompt.WriteString(sgr(fmt.Sprintf("30;4%d", segment.color)))
+		prompt.WriteString(" " + segment.text + " ")
+		if i+1 < len(shown) {
+			prompt.WriteString(sgr(fmt.Sprintf("3%d;4%d", segment.color, shown[i+1].color)))
+		} else {
+			prompt.WriteString(sgr(fmt.Sprintf("0;3%d", segment.color)))
+		}
+		prompt.WriteString(separator)
+	}
+	if len(shown) > 0 {
+		prompt.WriteString(sgr("0"))
+	}
+	return prompt.String()
+}
+
+// sgr returns the escape sequence selecting the ANSI graphic rendition
+// codes. Readline leaves such sequences out when it measures the prompt,
+// so they take up no columns and the cursor stays where it belongs.
+func sgr(codes string) string {
+	return "\033[" + codes + "m"
+}
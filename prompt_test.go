@@ -0,0 +1,121 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestPromptPrecedence(t *testing.T) {
+	t.Setenv("PS1", "")
+	t.Setenv("GOSH_PROMPT", "")
+	t.Setenv("PS2", "")
+	t.Setenv("GOSH_PROMPT2", "")
+	t.Setenv("USER", "tester")
+
+	tests := []struct {
+		ps1, goshPrompt string
+		expected        string
+	}{
+		{"", "", expandPromptVariables(defaultPrompt, 0)},
+		{"", "gosh%$ ", "gosh$ "},
+		{"bash\\$ ", "gosh%$ ", "bash$ "},
+		{"\\u> ", "", "tester> "},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("PS1", tt.ps1)
+		os.Setenv("GOSH_PROMPT", tt.goshPrompt)
+		if prompt := GetPrompt(); prompt != tt.expected {
+			t.Errorf("GetPrompt() with PS1=%q GOSH_PROMPT=%q = %q, want %q", tt.ps1, tt.goshPrompt, prompt, tt.expected)
+		}
+	}
+
+	if prompt := GetContinuationPrompt(); prompt != "> " {
+		t.Errorf("GetContinuationPrompt() = %q, want %q", prompt, "> ")
+	}
+	os.Setenv("PS2", "... ")
+	if prompt := GetContinuationPrompt(); prompt != "... " {
+		t.Errorf("GetContinuationPrompt() with PS2 = %q, want %q", prompt, "... ")
+	}
+}
+
+func TestPromptBuiltinSetsPS1(t *testing.T) {
+	t.Setenv("PS1", "")
+	runEcho(t, "prompt custom%$")
+	if ps1 := os.Getenv("PS1"); ps1 != "custom%$" {
+		t.Errorf("PS1 = %q after prompt, want %q", ps1, "custom%$")
+	}
+}
+
+func TestPromptRenderer(t *testing.T) {
+	dir := useTempCWD(t)
+	t.Setenv("PS1", "template> ")
+	t.Setenv("USER", "tester")
+	defer SetPromptRenderer(nil)
+
+	SetPromptRenderer(func(ctx PromptContext) string {
+		return fmt.Sprintf("%s %s [%d] %d jobs %s", ctx.Username, filepath.Base(ctx.CWD), ctx.ExitStatus, ctx.Jobs, ctx.Template)
+	})
+	GetGlobalState().SetPipeStatus([]int{0, 3})
+	jobManager := NewJobManager()
+	jobManager.AddJob("sleep 10", nil)
+
+	expected := "tester " + filepath.Base(dir) + " [3] 1 jobs template> "
+	if prompt := RenderPrompt(jobManager); prompt != expected {
+		t.Errorf("RenderPrompt() = %q, want %q", prompt, expected)
+	}
+	if prompt := GetPrompt(); prompt != "tester "+filepath.Base(dir)+" [3] 0 jobs template> " {
+		t.Errorf("GetPrompt() = %q, want the renderer with no jobs", prompt)
+	}
+
+	SetPromptRenderer(nil)
+	if prompt := GetPrompt(); prompt != "template> " {
+		t.Errorf("GetPrompt() after removing the renderer = %q, want %q", prompt, "template> ")
+	}
+}
+
+func TestPromptSegments(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	gs := GetGlobalState()
+	previous := gs.GetCWD()
+	defer gs.UpdateCWD(previous)
+	gs.UpdateCWD(filepath.Join(home, "src"))
+	defer gs.SetPipeStatus(nil)
+	defer gs.UnsetVar("GOSH_PROMPT_SEPARATOR")
+
+	gs.SetPipeStatus([]int{1})
+	gs.SetVar("GOSH_PROMPT_SEPARATOR", ">")
+	tests := []struct {
+		template string
+		jobs     int
+		expected string
+	}{
+		{"%E", 0, "\033[30;41m 1 \033[0;31m>\033[0m"},
+		{"%C", 0, "\033[30;44m ~/src \033[0;34m>\033[0m"},
+		{"%J", 0, ""},
+		{"%J", 2, "\033[30;43m jobs 2 \033[0;33m>\033[0m"},
+		{"%P ", 0, "\033[30;41m 1 \033[31;44m>\033[30;44m ~/src \033[0;34m>\033[0m "},
+		{"%P", 2, "\033[30;41m 1 \033[31;44m>\033[30;44m ~/src \033[34;43m>\033[30;43m jobs 2 \033[0;33m>\033[0m"},
+	}
+	for _, tt := range tests {
+		if prompt := expandPromptVariables(tt.template, tt.jobs); prompt != tt.expected {
+			t.Errorf("expandPromptVariables(%q, %d) = %q, want %q", tt.template, tt.jobs, prompt, tt.expected)
+		}
+	}
+
+	gs.SetPipeStatus([]int{0})
+	gs.UnsetVar("GOSH_PROMPT_SEPARATOR")
+	prompt := expandPromptVariables("%E", 0)
+	if prompt != "\033[30;42m 0 \033[0;32m\033[0m" {
+		t.Errorf("expandPromptVariables(%q) after success = %q, want a green segment with the powerline separator", "%E", prompt)
+	}
+	// Readline measures the prompt without its escape sequences.
+	if width := (readline.Runes{}).WidthAll((readline.Runes{}).ColorFilter([]rune(prompt))); width != 4 {
+		t.Errorf("width of %q = %d, want 4", prompt, width)
+	}
+}
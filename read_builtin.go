@@ -0,0 +1,167 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"gosh/parser"
+)
+
+// readCommand implements `read [-r] [-a ARRAY] [-d DELIM] [NAME...]`. It
+// reads a single line from standard input, splits it on IFS and assigns
+// the fields to the named shell variables. The last variable receives the
+// rest of the line with its original separators, so extra fields are
+// discarded by naming a throwaway last variable, as in `read first _`.
+// REPLY is used when no names are given. With -a every field becomes an
+// element of ARRAY instead. With -d the line ends at the first character
+// of DELIM rather than a newline, or at a NUL byte when DELIM is empty.
+//
+// A backslash escapes the next character, which is then never a
+// separator, and a backslash at the end of the line continues it onto
+// the next. -r keeps backslashes as ordinary characters.
+func readCommand(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		_, args, _ = parser.ProcessCommand2(cmd.AndCommands[0].Pipelines[0].Commands[0])
+	}
+
+	var raw bool
+	var array string
+	delim := byte('\n')
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		flags := args[0][1:]
+		args = args[1:]
+		if flags == "-" {
+			break
+		}
+		for i := 0; i < len(flags); i++ {
+			switch flags[i] {
+			case 'r':
+				raw = true
+			case 'a', 'd':
+				// The value is the rest of the flag or the next argument.
+				value, given := flags[i+1:], true
+				if value == "" {
+					if given = len(args) > 0; given {
+						value, args = unquoteArg(args[0]), args[1:]
+					}
+				}
+				if !given || (flags[i] == 'a' && value == "") {
+					cmd.ReturnCode = 2
+					return fmt.Errorf("-%c: option requires an argument", flags[i])
+				}
+				if flags[i] == 'a' {
+					array = value
+				} else if value == "" {
+					delim = 0
+				} else {
+					delim = value[0]
+				}
+				i = len(flags)
+			default:
+				cmd.ReturnCode = 2
+				return fmt.Errorf("-%c: invalid option\nUsage: read [-r] [-a array] [-d delim] [name ...]", flags[i])
+			}
+		}
+	}
+	names := args
+	if array != "" {
+		names = append([]string{array}, names...)
+	}
+	for _, name := range names {
+		if !assignmentPattern.MatchString(name + "=") {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("`%s': not a valid identifier", name)
+		}
+	}
+
+	line, err := readLine(cmd.Stdin, delim)
+	for !raw && err == nil && ContinuesLine(line) {
+		var next string
+		next, err = readLine(cmd.Stdin, delim)
+		// An escaped newline disappears; any other delimiter is kept as
+		// an escaped character.
+		if delim == '\n' {
+			line = line[:len(line)-1] + next
+		} else {
+			line += string(delim) + next
+		}
+	}
+	if err != nil && (err != io.EOF || line == "") {
+		cmd.ReturnCode = 1
+		return nil
+	}
+
+	runes := []rune(line)
+	var escaped []bool
+	if !raw {
+		runes, escaped = unescapeInput(runes)
+	}
+
+	if array != "" {
+		if GetGlobalState().GetVarAttributes(array).ReadOnly {
+			cmd.ReturnCode = 1
+			return fmt.Errorf("%s: readonly variable", array)
+		}
+		GetGlobalState().SetArray(array, splitEscapedFieldsN(runes, escaped, currentIFS(), 0))
+		return nil
+	}
+	if len(names) == 0 {
+		return setVariable("REPLY", string(runes))
+	}
+
+	fields := splitEscapedFieldsN(runes, escaped, currentIFS(), len(names))
+	for i, name := range names {
+		var value string
+		if i < len(fields) {
+			value = fields[i]
+		}
+		if err := setVariable(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unescapeInput removes the backslashes from a line read without -r,
+// marking the characters they escaped. A trailing backslash is dropped.
+func unescapeInput(runes []rune) ([]rune, []bool) {
+	result := make([]rune, 0, len(runes))
+	escaped := make([]bool, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '\\' {
+			result = append(result, runes[i])
+			escaped = append(escaped, false)
+			continue
+		}
+		if i++; i < len(runes) {
+			result = append(result, runes[i])
+			escaped = append(escaped, true)
+		}
+	}
+	return result, escaped
+}
+
+// readLine reads up to the next delim one byte at a time so that input
+// beyond the line is left for whoever reads the stream next. The delimiter
+// is consumed but not returned.
+func readLine(r io.Reader, delim byte) (string, error) {
+	if r == nil {
+		return "", io.EOF
+	}
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == delim {
+				return line.String(), nil
+			}
+			line.WriteByte(buf[0])
+		}
+		if err != nil {
+			return line.String(), err
+		}
+	}
+}
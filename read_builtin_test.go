@@ -0,0 +1,234 @@
+package gosh
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func runWithInput(t *testing.T, input, stdin string) *Command {
+	t.Helper()
+	cmd, err := NewCommand(input, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command %q: %v", input, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	return cmd
+}
+
+func TestReadUsesShellIFS(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.UnsetVar("IFS")
+
+	runWithInput(t, "IFS=:", "")
+	if value, ok := gs.GetVar("IFS"); !ok || value != ":" {
+		t.Fatalf("GetVar(IFS) = (%q, %v), want \":\"", value, ok)
+	}
+	if value, exported := os.LookupEnv("IFS"); exported {
+		t.Errorf("IFS leaked into the environment as %q", value)
+	}
+
+	cmd := runWithInput(t, "read first second", "alpha:beta\n")
+	if cmd.ReturnCode != 0 {
+		t.Fatalf("read ReturnCode = %d, want 0", cmd.ReturnCode)
+	}
+	if first, _ := gs.GetVar("first"); first != "alpha" {
+		t.Errorf("first = %q, want %q", first, "alpha")
+	}
+	if second, _ := gs.GetVar("second"); second != "beta" {
+		t.Errorf("second = %q, want %q", second, "beta")
+	}
+
+	var output bytes.Buffer
+	echo, err := NewCommand("echo $first", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	echo.Stdout = &output
+	echo.Run()
+	if output.String() != "alpha\n" {
+		t.Errorf("echo $first = %q, want %q", output.String(), "alpha\n")
+	}
+}
+
+func TestReadDefaultIFS(t *testing.T) {
+	gs := GetGlobalState()
+	gs.UnsetVar("IFS")
+
+	runWithInput(t, "read a b", "  one   two three  \n")
+	if a, _ := gs.GetVar("a"); a != "one" {
+		t.Errorf("a = %q, want %q", a, "one")
+	}
+	if b, _ := gs.GetVar("b"); b != "two three" {
+		t.Errorf("b = %q, want %q", b, "two three")
+	}
+
+	cmd := runWithInput(t, "read", "whole line\n")
+	if reply, _ := gs.GetVar("REPLY"); reply != "whole line" || cmd.ReturnCode != 0 {
+		t.Errorf("REPLY = %q (ReturnCode %d), want %q", reply, cmd.ReturnCode, "whole line")
+	}
+
+	cmd = runWithInput(t, "read a", "")
+	if cmd.ReturnCode != 1 {
+		t.Errorf("read at EOF ReturnCode = %d, want 1", cmd.ReturnCode)
+	}
+}
+
+func TestSplitByIFS(t *testing.T) {
+	tests := []struct {
+		input    string
+		ifs      string
+		expected []string
+	}{
+		{"a b c", defaultIFS, []string{"a", "b", "c"}},
+		{"  a \t b  ", defaultIFS, []string{"a", "b"}},
+		{"a:b:c", ":", []string{"a", "b", "c"}},
+		{"a::b", ":", []string{"a", "", "b"}},
+		{"a : b", " :", []string{"a", "b"}},
+		{"a b", "", []string{"a b"}},
+		{"", defaultIFS, nil},
+	}
+
+	for _, tt := range tests {
+		if result := splitByIFS(tt.input, tt.ifs); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("splitByIFS(%q, %q) = %q, want %q", tt.input, tt.ifs, result, tt.expected)
+		}
+	}
+}
+
+func TestSplitFieldsN(t *testing.T) {
+	tests := []struct {
+		input    string
+		ifs      string
+		n        int
+		expected []string
+	}{
+		{"a b c", defaultIFS, 2, []string{"a", "b c"}},
+		{"  a   b \t c  ", defaultIFS, 2, []string{"a", "b \t c"}},
+		{"a:b::c", ":", 2, []string{"a", "b::c"}},
+		{"a : b : c", " :", 2, []string{"a", "b : c"}},
+		{"a b", defaultIFS, 3, []string{"a", "b"}},
+		{"a b c", defaultIFS, 1, []string{"a b c"}},
+		{"a b c", defaultIFS, 0, []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		if result := splitFieldsN(tt.input, tt.ifs, tt.n); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("splitFieldsN(%q, %q, %d) = %q, want %q", tt.input, tt.ifs, tt.n, result, tt.expected)
+		}
+	}
+}
+
+func TestReadKeepsSeparatorsInLastVariable(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.UnsetVar("IFS")
+	defer gs.UnsetVar("_")
+
+	gs.UnsetVar("IFS")
+	runWithInput(t, "read a b", "one  two   three\n")
+	if b, _ := gs.GetVar("b"); b != "two   three" {
+		t.Errorf("b = %q, want %q", b, "two   three")
+	}
+
+	runWithInput(t, "read a _", "keep the rest away\n")
+	if a, _ := gs.GetVar("a"); a != "keep" {
+		t.Errorf("a = %q, want %q", a, "keep")
+	}
+
+	gs.SetVar("IFS", ":")
+	runWithInput(t, "read a b", "x:y:z\n")
+	if b, _ := gs.GetVar("b"); b != "y:z" {
+		t.Errorf("b = %q with IFS=:, want %q", b, "y:z")
+	}
+}
+
+func TestReadBackslashes(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.UnsetVar("a")
+	defer gs.UnsetVar("b")
+
+	tests := []struct {
+		input, stdin string
+		a, b         string
+	}{
+		{"read a b", `one\ two three` + "\n", "one two", "three"},
+		{"read -r a b", `one\ two three` + "\n", `one\`, "two three"},
+		{"read a b", `C:\\dir\x` + "\n", `C:\dirx`, ""},
+		{"read -r a b", `C:\\dir\x` + "\n", `C:\\dir\x`, ""},
+		{"read a b", "first \\\nsecond third\n", "first", "second third"},
+		{"read -r a b", "first \\\nsecond third\n", "first", `\`},
+	}
+	for _, tt := range tests {
+		gs.UnsetVar("a")
+		gs.UnsetVar("b")
+		runWithInput(t, tt.input, tt.stdin)
+		a, _ := gs.GetVar("a")
+		b, _ := gs.GetVar("b")
+		if a != tt.a || b != tt.b {
+			t.Errorf("%q with input %q set (%q, %q), want (%q, %q)", tt.input, tt.stdin, a, b, tt.a, tt.b)
+		}
+	}
+}
+
+func TestReadArray(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.UnsetVar("arr")
+
+	runWithInput(t, "read -a arr", "x  y\\ z  w\n")
+	if elements, _ := gs.GetArray("arr"); !reflect.DeepEqual(elements, []string{"x", "y z", "w"}) {
+		t.Errorf("read -a arr set %q, want %q", elements, []string{"x", "y z", "w"})
+	}
+	if output := runEcho(t, "echo ${arr[0]} ${arr[2]} ${#arr[@]} $arr [${arr[@]}]"); output != "x w 3 x [x y z w]\n" {
+		t.Errorf("echo of arr elements = %q, want %q", output, "x w 3 x [x y z w]\n")
+	}
+
+	runWithInput(t, "read -ra arr", `a\b c`+"\n")
+	if elements, _ := gs.GetArray("arr"); !reflect.DeepEqual(elements, []string{`a\b`, "c"}) {
+		t.Errorf("read -ra arr set %q, want %q", elements, []string{`a\b`, "c"})
+	}
+
+	runEcho(t, "arr=scalar")
+	if _, ok := gs.GetArray("arr"); ok {
+		t.Errorf("arr=scalar left arr an array")
+	}
+	if cmd := runWithInput(t, "read -a", "x\n"); cmd.ReturnCode != 2 {
+		t.Errorf("read -a without a name returned %d, want 2", cmd.ReturnCode)
+	}
+}
+
+func TestReadDelimiter(t *testing.T) {
+	gs := GetGlobalState()
+	defer gs.UnsetVar("a")
+	defer gs.UnsetVar("rest")
+
+	tests := []struct {
+		input, stdin string
+		a, rest      string
+	}{
+		{"read -d : a; read rest", "one two:three\n", "one two", "three"},
+		{"read -d '' a; read -d '' rest", "first record\nline\x00second\x00", "first record\nline", "second"},
+		{"read -d: a", "x:y", "x", ""},
+		{"read -d : a", `a\:b:c`, "a:b", ""},
+		{"read -r -d : a", `a\:b:c`, `a\`, ""},
+	}
+	for _, tt := range tests {
+		gs.UnsetVar("a")
+		gs.UnsetVar("rest")
+		runWithInput(t, tt.input, tt.stdin)
+		a, _ := gs.GetVar("a")
+		rest, _ := gs.GetVar("rest")
+		if a != tt.a || rest != tt.rest {
+			t.Errorf("%q with input %q set (%q, %q), want (%q, %q)", tt.input, tt.stdin, a, rest, tt.a, tt.rest)
+		}
+	}
+
+	if cmd := runWithInput(t, "read -d", "x\n"); cmd.ReturnCode != 2 {
+		t.Errorf("read -d without a delimiter returned %d, want 2", cmd.ReturnCode)
+	}
+}
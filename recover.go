@@ -0,0 +1,45 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"gosh/parser"
+)
+
+// ReportPanic writes a message about a recovered panic to w, followed by
+// the stack trace when GOSH_DEBUG is set.
+func ReportPanic(w io.Writer, value interface{}) {
+	fmt.Fprintf(w, "gosh: internal error: %v\n", value)
+	if os.Getenv("GOSH_DEBUG") != "" {
+		w.Write(debug.Stack())
+	}
+}
+
+// runPipeline runs a pipeline, turning a panic in a builtin or the
+// executor into a failure with status 1 so the shell keeps running. $? is
+// set however the pipeline ended: to each stage's status if it ran to the
+// end, otherwise to its return code alone.
+func (cmd *Command) runPipeline(pipeline *parser.Pipeline, background bool) (success bool) {
+	var stageStatus []int
+	defer func() {
+		if r := recover(); r != nil {
+			if exit, ok := r.(subshellExit); ok {
+				panic(exit)
+			}
+			ReportPanic(cmd.Stderr, r)
+			cmd.ReturnCode = 1
+			success = false
+		}
+		if stageStatus == nil {
+			stageStatus = []int{cmd.ReturnCode}
+		}
+		if !cmd.background {
+			GetGlobalState().SetPipeStatus(stageStatus)
+		}
+	}()
+	success, stageStatus = cmd.executePipeline(pipeline, background)
+	return success
+}
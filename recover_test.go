@@ -0,0 +1,46 @@
+package gosh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPanickingBuiltinDoesNotKillShell(t *testing.T) {
+	RegisterBuiltin("gosh-panic-test", func(cmd *Command) error {
+		var missing *Command
+		missing.ReturnCode = 2
+		return nil
+	})
+	defer UnregisterBuiltin("gosh-panic-test")
+
+	stdout, stderr, code := runWithOptions(t, "gosh-panic-test; echo after $?")
+	if stdout != "after 1\n" || code != 0 {
+		t.Errorf("gosh-panic-test; echo after $? = (%q, %d), want (%q, 0)", stdout, code, "after 1\n")
+	}
+	if !strings.Contains(stderr, "gosh: internal error:") {
+		t.Errorf("gosh-panic-test wrote %q to stderr, want an internal error", stderr)
+	}
+
+	stdout, _, code = runWithOptions(t, "gosh-panic-test && echo no")
+	if stdout != "" || code != 1 {
+		t.Errorf("gosh-panic-test && echo no = (%q, %d), want (\"\", 1)", stdout, code)
+	}
+}
+
+func TestStatusAfterFailedPipeline(t *testing.T) {
+	useTempCWD(t)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true; cd /nonexistent; echo $?", "1\n"},
+		{"true; echo ${status_unset:?}; echo $?", "1\n"},
+		{"true; [ 1 -eq ]; echo $?", "2\n"},
+		{"false; true | false; echo ${PIPESTATUS[@]}", "0 1\n"},
+	}
+	for _, tt := range tests {
+		if stdout, _, _ := runWithOptions(t, tt.input); stdout != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, stdout, tt.expected)
+		}
+	}
+}
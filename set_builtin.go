@@ -0,0 +1,107 @@
+package gosh
+
+import (
+	"fmt"
+	"sort"
+)
+
+// shellOptions lists the options understood by `set -o`.
+var shellOptions = map[string]bool{
+	"dotglob":       true,
+	"errexit":       true,
+	"exportaliases": true,
+	"nounset":       true,
+	"pipefail":      true,
+	"posix":         true,
+	"safe-rm":       true,
+	"xtrace":        true,
+}
+
+// shortOptions maps the single-letter flags of set to option names.
+var shortOptions = map[byte]string{
+	'e': "errexit",
+	'u': "nounset",
+	'x': "xtrace",
+}
+
+// xtracePrefix returns what xtrace writes before each command: $PS4, or
+// "+ " when PS4 is unset.
+func xtracePrefix() string {
+	if !variableIsSet("PS4") {
+		return "+ "
+	}
+	return lookupVariable("PS4")
+}
+
+func unquoteOperands(args []string) []string {
+	operands := make([]string, len(args))
+	for i, arg := range args {
+		operands[i] = removeQuotes(arg)
+	}
+	return operands
+}
+
+// setCommand implements `set -o NAME` and `set +o NAME`, and the short
+// forms such as `set -e` and `set +eu`. With no option name, `set -o`
+// lists every option and whether it is enabled. Any arguments after the
+// options, or after `--`, replace the positional parameters; `set --` on
+// its own clears them.
+func setCommand(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	args := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	gs := GetGlobalState()
+
+	if len(args) == 0 || (len(args) == 1 && (args[0] == "-o" || args[0] == "+o")) {
+		names := make([]string, 0, len(shellOptions))
+		for name := range shellOptions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			state := "off"
+			if gs.GetOption(name) {
+				state = "on"
+			}
+			if _, err := fmt.Fprintf(cmd.Stdout, "%-15s %s\n", name, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if flag == "--" {
+			gs.SetPositionalParams(unquoteOperands(args[i+1:]))
+			return nil
+		}
+		if flag == "" || (flag[0] != '-' && flag[0] != '+') {
+			gs.SetPositionalParams(unquoteOperands(args[i:]))
+			return nil
+		}
+		if len(flag) > 1 && (flag[0] == '-' || flag[0] == '+') && flag[1] != 'o' {
+			for j := 1; j < len(flag); j++ {
+				name, ok := shortOptions[flag[j]]
+				if !ok {
+					cmd.ReturnCode = 2
+					return fmt.Errorf("%c%c: invalid option", flag[0], flag[j])
+				}
+				gs.SetOption(name, flag[0] == '-')
+			}
+			continue
+		}
+		if (flag != "-o" && flag != "+o") || i+1 >= len(args) {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("Usage: set [-eux|+eux] [-o|+o option] [--] [arg ...]")
+		}
+		i++
+		if _, ok := shellOptions[args[i]]; !ok {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("%s: invalid option name", args[i])
+		}
+		gs.SetOption(args[i], flag == "-o")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package gosh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// runWithOptions runs input with the given shell options switched off
+// afterwards, returning its stdout, stderr and exit status.
+func runWithOptions(t *testing.T, input string, options ...string) (string, string, int) {
+	t.Helper()
+	for _, name := range options {
+		defer GetGlobalState().SetOption(name, false)
+	}
+	cmd, err := NewCommand(input, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command %q: %v", input, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	return stdout.String(), stderr.String(), cmd.ReturnCode
+}
+
+func TestSetErrexit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"set -e; false; echo should-not-print", "", 1},
+		{"set -e; echo before; true && false; echo should-not-print", "before\n", 1},
+		{"set -e; false && echo no; echo after", "after\n", 0},
+		{"set -e; if false; then echo no; fi; echo after", "after\n", 0},
+		{"set -e; while false; do echo no; done; until true; do echo no; done; echo after", "after\n", 0},
+		{"set -e; for f in a b; do echo $f; false; done; echo should-not-print", "a\n", 1},
+		{"set -e; if true; then false; echo should-not-print; fi", "", 1},
+		{"set -e; set +e; false; echo after", "after\n", 0},
+		{"false; echo after", "after\n", 0},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input, "errexit")
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}
+
+func TestSetPipefail(t *testing.T) {
+	useTempCWD(t)
+	tests := []struct {
+		input string
+		code  int
+	}{
+		{"false | true", 0},
+		{"set -o pipefail; false | true", 1},
+		{"set -o pipefail; ls /gosh-no-such-dir | false | true", 1},
+		{"set -o pipefail; false | ls /gosh-no-such-dir | true", 2},
+		{"set -o pipefail; true | true", 0},
+		{"set -o pipefail; set +o pipefail; false | true", 0},
+	}
+	for _, tt := range tests {
+		if _, stderr, code := runWithOptions(t, tt.input, "pipefail"); code != tt.code {
+			t.Errorf("%q ReturnCode = %d, want %d; stderr %q", tt.input, code, tt.code, stderr)
+		}
+	}
+}
+
+func TestSetXtraceAndNounset(t *testing.T) {
+	GetGlobalState().SetVar("set_test_var", "value")
+	defer GetGlobalState().UnsetVar("set_test_var")
+
+	stdout, stderr, _ := runWithOptions(t, "set -x; echo $set_test_var", "xtrace")
+	if stdout != "value\n" || stderr != "+ echo value\n" {
+		t.Errorf("set -x; echo = (%q, %q), want (%q, %q)", stdout, stderr, "value\n", "+ echo value\n")
+	}
+
+	GetGlobalState().SetVar("PS4", "trace> ")
+	_, stderr, _ = runWithOptions(t, "set -x; echo hi *.none", "xtrace")
+	GetGlobalState().UnsetVar("PS4")
+	if stderr != "trace> echo hi *.none\n" {
+		t.Errorf("set -x with PS4 wrote %q to stderr, want %q", stderr, "trace> echo hi *.none\n")
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"set -u; echo $set_test_unset", "", 1},
+		{"set -u; echo ${set_test_unset}", "", 1},
+		{"set -u; echo ${#set_test_unset}", "", 1},
+		{"set -u; echo ${set_test_unset:-default} $set_test_var", "default value\n", 0},
+		{"set -eu; echo $set_test_unset; echo should-not-print", "", 1},
+		{"echo $set_test_unset", "\n", 0},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input, "nounset", "errexit")
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+		if code != 0 && !strings.Contains(stderr, "set_test_unset: unbound variable") {
+			t.Errorf("%q wrote %q to stderr, want an unbound variable error", tt.input, stderr)
+		}
+	}
+
+	if _, _, code := runWithOptions(t, "set -q"); code != 2 {
+		t.Errorf("set -q returned %d, want 2", code)
+	}
+}
+
+func TestSetPositionalParams(t *testing.T) {
+	defer GetGlobalState().SetPositionalParams(nil)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"set -- a b c; echo $# $1 $3 $@", "3 a c a b c\n"},
+		{"set x y; echo ${#} ${2} $*", "2 y x y\n"},
+		{"set -- a b c; set --; echo $# [$1] [$@]", "0 [] []\n"},
+		{"set -- one two; for p; do echo \"<$p>\"; done", "<one>\n<two>\n"},
+		{"set -- a b c d e f g h i j; echo $10 ${10}", "a0 j\n"},
+		{"set -- outer; echo $(set -- inner; echo $1) $1", "inner outer\n"},
+		{`set -- 'x y' z; echo "$1|$2"`, "x y|z\n"},
+		{`set -- a "b c"; for x in "$@"; do echo "[$x]"; done`, "[a]\n[b c]\n"},
+		{`set -- a "b c"; for x in "$*"; do echo "[$x]"; done`, "[a b c]\n"},
+		{`set -- 'x y' z; printf '[%s]' "$@"`, "[x y][z]"},
+		{`set -- 'x y' z; printf '[%s]' "<$@>" "${@}"`, "[<x y][z>][x y][z]"},
+		{`set --; printf '[%s]' a "$@" b`, "[a][b]"},
+		{`set -- a "b c"; for x; do echo "[$x]"; done`, "[a]\n[b c]\n"},
+	}
+	for _, tt := range tests {
+		if stdout, stderr, _ := runWithOptions(t, tt.input); stdout != tt.expected {
+			t.Errorf("%q printed %q, want %q; stderr %q", tt.input, stdout, tt.expected, stderr)
+		}
+	}
+
+	GetGlobalState().SetPositionalParams(nil)
+	if _, stderr, code := runWithOptions(t, "set -u; echo $1", "nounset"); code != 1 || !strings.Contains(stderr, "1: unbound variable") {
+		t.Errorf("set -u; echo $1 = (%d, %q), want an unbound variable error", code, stderr)
+	}
+}
@@ -0,0 +1,103 @@
+package gosh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// source executes each line of a file in the current shell, so that
+// exported variables and directory changes persist after it returns.
+func source(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts) < 2 {
+		return fmt.Errorf("Usage: source <file>")
+	}
+	return cmd.sourceFile(cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1])
+}
+
+// RCFile returns the startup file of an interactive shell: $GOSHRC when
+// it is set, otherwise ~/.goshrc.
+func RCFile() (string, error) {
+	if path := os.Getenv("GOSHRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".goshrc"), nil
+}
+
+// RunInitFile sources an rc file into the shell before the first prompt,
+// with the terminal as its stdio.
+func RunInitFile(filename string, jobManager *JobManager) error {
+	cmd := &Command{
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		JobManager: jobManager,
+	}
+	return cmd.sourceFile(filename)
+}
+
+func (cmd *Command) sourceFile(filename string) error {
+	file, err := os.Open(resolvePath(GetGlobalState().GetCWD(), filename))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer file.Close()
+
+	// Remember where we were so nested sources report the right location
+	// once they return.
+	gs := GetGlobalState()
+	prevFile, prevLine := gs.GetSourceLocation()
+	defer gs.SetSourceLocation(prevFile, prevLine)
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		startLine := lineNumber
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// A trailing backslash joins the next line onto this one.
+		for ContinuesLine(line) && scanner.Scan() {
+			lineNumber++
+			line = line[:len(line)-1] + strings.TrimSpace(scanner.Text())
+		}
+		if ContinuesLine(line) {
+			line = line[:len(line)-1]
+		}
+
+		gs.SetSourceLocation(filename, startLine)
+		sourced, err := NewCommand(line, cmd.JobManager)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "%s%v\n", sourceLocationPrefix(), err)
+			cmd.ReturnCode = 1
+			continue
+		}
+		sourced.Stdin = cmd.Stdin
+		sourced.Stdout = cmd.Stdout
+		sourced.Stderr = cmd.Stderr
+		sourced.Raw = cmd.Raw
+		sourced.subshell = cmd.subshell
+		sourced.background = cmd.background
+		sourced.Run()
+		cmd.ReturnCode = sourced.ReturnCode
+		if sourced.aborted {
+			break
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ContinuesLine reports whether line ends in an unescaped backslash.
+func ContinuesLine(line string) bool {
+	trailing := len(line) - len(strings.TrimRight(line, "\\"))
+	return trailing%2 == 1
+}
@@ -0,0 +1,135 @@
+package gosh
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceReportsErrorLine(t *testing.T) {
+	tempDir := t.TempDir()
+	script := filepath.Join(tempDir, "broken.sh")
+	content := "echo one\necho two\necho three |\necho four\n"
+	if err := os.WriteFile(script, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	cmd, err := NewCommand("source "+script, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	expected := script + ": line 3: "
+	if !strings.Contains(stderr.String(), expected) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr.String(), expected)
+	}
+	if !strings.Contains(stdout.String(), "four") {
+		t.Errorf("stdout = %q, want lines after the error to still run", stdout.String())
+	}
+
+	file, line := GetGlobalState().GetSourceLocation()
+	if file != "" || line != 0 {
+		t.Errorf("GetSourceLocation() = (%q, %d) after source, want it reset", file, line)
+	}
+}
+
+func TestSourceRunsInCurrentShell(t *testing.T) {
+	dir := useTempCWD(t)
+	content := "# set up the environment\nexport GOSH_SOURCE_TEST=bar\n  # indented comment\necho one \\\n  two \\\n  three\n"
+	if err := os.WriteFile(filepath.Join(dir, "env.sh"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	defer os.Unsetenv("GOSH_SOURCE_TEST")
+
+	for _, input := range []string{"source env.sh", ". env.sh"} {
+		os.Unsetenv("GOSH_SOURCE_TEST")
+		cmd, err := NewCommand(input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command: %v", err)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Run()
+
+		if value := os.Getenv("GOSH_SOURCE_TEST"); value != "bar" {
+			t.Errorf("%q: GOSH_SOURCE_TEST = %q, want %q", input, value, "bar")
+		}
+		if expected := "export GOSH_SOURCE_TEST=bar\none two three\n"; stdout.String() != expected {
+			t.Errorf("%q: stdout = %q, want %q; stderr %q", input, stdout.String(), expected, stderr.String())
+		}
+		if cmd.ReturnCode != 0 {
+			t.Errorf("%q: ReturnCode = %d, want 0", input, cmd.ReturnCode)
+		}
+	}
+
+	cmd, err := NewCommand("source missing.sh", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Stdout = &bytes.Buffer{}
+	cmd.Stderr = &bytes.Buffer{}
+	cmd.Run()
+	if cmd.ReturnCode == 0 {
+		t.Errorf("source missing.sh returned 0, want a failure")
+	}
+}
+
+func TestRCFileDefinesAliases(t *testing.T) {
+	rcFile := filepath.Join(t.TempDir(), "goshrc")
+	content := "alias rc_test_ll='ls -l'\nthis line | does not parse |\nexport GOSH_RC_TEST=loaded\n"
+	if err := os.WriteFile(rcFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write rc file: %v", err)
+	}
+	t.Setenv("GOSHRC", rcFile)
+	defer RemoveAlias("rc_test_ll")
+	defer os.Unsetenv("GOSH_RC_TEST")
+
+	path, err := RCFile()
+	if err != nil || path != rcFile {
+		t.Fatalf("RCFile() = (%q, %v), want %q", path, err, rcFile)
+	}
+	if err := RunInitFile(path, NewJobManager()); err != nil {
+		t.Fatalf("RunInitFile(%q) returned error: %v", path, err)
+	}
+	if command, ok := GetAlias("rc_test_ll"); !ok || command != "ls -l" {
+		t.Errorf("GetAlias(%q) = (%q, %v), want (%q, true)", "rc_test_ll", command, ok, "ls -l")
+	}
+	if value := os.Getenv("GOSH_RC_TEST"); value != "loaded" {
+		t.Errorf("GOSH_RC_TEST = %q, want lines after a parse error to still run", value)
+	}
+
+	t.Setenv("GOSHRC", "")
+	t.Setenv("HOME", "/home/gosh-test")
+	if path, err := RCFile(); err != nil || path != "/home/gosh-test/.goshrc" {
+		t.Errorf("RCFile() without GOSHRC = (%q, %v), want %q", path, err, "/home/gosh-test/.goshrc")
+	}
+}
+
+func TestRunInitFile(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "goshrc")
+	if err := os.WriteFile(script, []byte("init_file_var=loaded\n"), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	defer GetGlobalState().UnsetVar("init_file_var")
+
+	if err := RunInitFile(script, NewJobManager()); err != nil {
+		t.Fatalf("RunInitFile(%q) returned error: %v", script, err)
+	}
+	if value := lookupVariable("init_file_var"); value != "loaded" {
+		t.Errorf("init_file_var = %q, want %q", value, "loaded")
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := RunInitFile(missing, NewJobManager()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("RunInitFile(%q) = %v, want a not-exist error", missing, err)
+	}
+}
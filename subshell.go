@@ -0,0 +1,116 @@
+package gosh
+
+import (
+	"io"
+	"os"
+
+	"gosh/parser"
+)
+
+// subshellState is the shell state a subshell may change but must not
+// leak back to its parent.
+type subshellState struct {
+	cwd         string
+	previousDir string
+	processDir  string
+	params      []string
+	variables   map[string]string
+	arrays      map[string][]string
+	attributes  map[string]VarAttributes
+	options     map[string]bool
+	environ     map[string]string
+}
+
+func saveSubshellState() subshellState {
+	gs := GetGlobalState()
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	state := subshellState{
+		cwd:         gs.CWD,
+		previousDir: gs.PreviousDir,
+		params:      gs.Params,
+		variables:   make(map[string]string, len(gs.Variables)),
+		arrays:      make(map[string][]string, len(gs.Arrays)),
+		attributes:  make(map[string]VarAttributes, len(gs.Attributes)),
+		options:     make(map[string]bool, len(gs.Options)),
+		environ:     EnvSnapshot(),
+	}
+	state.processDir, _ = os.Getwd()
+	for name, value := range gs.Variables {
+		state.variables[name] = value
+	}
+	for name, elements := range gs.Arrays {
+		state.arrays[name] = append([]string(nil), elements...)
+	}
+	for name, attributes := range gs.Attributes {
+		state.attributes[name] = attributes
+	}
+	for name, enabled := range gs.Options {
+		state.options[name] = enabled
+	}
+	return state
+}
+
+func (state subshellState) restore() {
+	gs := GetGlobalState()
+	gs.mu.Lock()
+	gs.CWD = state.cwd
+	gs.PreviousDir = state.previousDir
+	gs.Params = state.params
+	gs.Variables = state.variables
+	gs.Arrays = state.arrays
+	gs.Attributes = state.attributes
+	gs.Options = state.options
+	gs.mu.Unlock()
+
+	if state.processDir != "" {
+		os.Chdir(state.processDir)
+	}
+	EnvRestore(state.environ)
+}
+
+// runSubshell runs the list inside ( ... ) in-process and returns its
+// exit code. Changes it makes to the working directory, variables, options
+// and environment are undone when it finishes.
+func (cmd *Command) runSubshell(body *parser.Command, stdin io.Reader, stdout io.Writer) int {
+	if cmd.background {
+		cmd.printError("gosh: subshells are not supported in the background\n")
+		return 1
+	}
+	subCmd := &Command{
+		Command:    body,
+		Stdin:      stdin,
+		Stdout:     stdout,
+		Stderr:     cmd.Stderr,
+		JobManager: cmd.JobManager,
+		Raw:        cmd.Raw,
+		subshell:   true,
+	}
+
+	state := saveSubshellState()
+	defer state.restore()
+	return subCmd.runUntilExit()
+}
+
+// subshellExit is the panic value with which exit ends a subshell. Since
+// subshells run in-process, exit must not end the shell itself.
+type subshellExit struct {
+	status int
+}
+
+// runUntilExit runs a subshell command and returns its exit code, or the
+// status exit was given if it ran exit.
+func (cmd *Command) runUntilExit() (status int) {
+	defer func() {
+		if r := recover(); r != nil {
+			exit, ok := r.(subshellExit)
+			if !ok {
+				panic(r)
+			}
+			status = exit.status
+		}
+	}()
+	cmd.Run()
+	return cmd.ReturnCode
+}
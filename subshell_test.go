@@ -0,0 +1,123 @@
+package gosh
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParenthesizedShellCommandsAreNotLisp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"(+ 1 2)", true},
+		{"(define x 5)", true},
+		{"((lambda (x) x) 1)", true},
+		{"()", true},
+		{"(echo hi)", false},
+		{"(cd /tmp)", false},
+		{"echo hi", false},
+	}
+
+	for _, tt := range tests {
+		if result := IsLispExpression(tt.input); result != tt.expected {
+			t.Errorf("IsLispExpression(%q) = %v, want %v", tt.input, result, tt.expected)
+		}
+	}
+
+	input := "echo $((1+2)) $(date) (not-lisp) (+ 1 2)"
+	if result, err := evaluateLispInCommand(input); err != nil || result != "echo $((1+2)) $(date) (not-lisp) 3" {
+		t.Errorf("evaluateLispInCommand(%q) = (%q, %v), want only the Lisp evaluated", input, result, err)
+	}
+}
+
+func TestSubshell(t *testing.T) {
+	dir := useTempCWD(t)
+	defer GetGlobalState().UnsetVar("SUBSHELL_VAR")
+	defer os.Unsetenv("GOSH_SUBSHELL_EXPORT")
+
+	if output := runEcho(t, "(echo hi)"); output != "hi\n" {
+		t.Errorf("(echo hi) = %q, want %q", output, "hi\n")
+	}
+	if output := runEcho(t, "(echo piped) | cat"); output != "piped\n" {
+		t.Errorf("(echo piped) | cat = %q, want %q", output, "piped\n")
+	}
+
+	chdirForTest(t, dir)
+	runEcho(t, "(cd /)")
+	runEcho(t, "(SUBSHELL_VAR=inside)")
+	runEcho(t, "(export GOSH_SUBSHELL_EXPORT=inside)")
+
+	if cwd := GetGlobalState().GetCWD(); cwd != dir {
+		t.Errorf("CWD after (cd /) = %q, want %q", cwd, dir)
+	}
+	if _, ok := GetGlobalState().GetVar("SUBSHELL_VAR"); ok {
+		t.Errorf("variable set in a subshell leaked into the shell")
+	}
+	if _, ok := os.LookupEnv("GOSH_SUBSHELL_EXPORT"); ok {
+		t.Errorf("variable exported in a subshell leaked into the shell")
+	}
+
+	cmd, err := NewCommand("(false)", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Run()
+	if cmd.ReturnCode != 1 {
+		t.Errorf("(false) ReturnCode = %d, want 1", cmd.ReturnCode)
+	}
+}
+
+func TestSubshellExit(t *testing.T) {
+	exited := false
+	exitProcess = func(int) { exited = true }
+	defer func() { exitProcess = os.Exit }()
+	defer func() { traps = make(map[string]string) }()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo before; (exit 3); echo $? after", "before\n3 after\n"},
+		{"(eval exit 4); echo $?", "4\n"},
+		{"echo x$(exit 1)y; echo after", "xy\nafter\n"},
+		{"trap 'echo trapped' EXIT; (exit 5); echo $?", "5\n"},
+	}
+	for _, tt := range tests {
+		stdout, stderr, _ := runWithOptions(t, tt.input)
+		if stdout != tt.expected {
+			t.Errorf("%q = %q, want %q; stderr %q", tt.input, stdout, tt.expected, stderr)
+		}
+	}
+	if exited {
+		t.Errorf("exit in a subshell ended the shell")
+	}
+}
+
+func TestSubshellLists(t *testing.T) {
+	dir := useTempCWD(t)
+	exited := false
+	exitProcess = func(int) { exited = true }
+	defer func() { exitProcess = os.Exit }()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(echo a; echo b) | cat", "a\nb\n"},
+		{"(true && echo yes) && echo after", "yes\nafter\n"},
+		{"(false && echo no); echo $?", "1\n"},
+		{"(true; exit 2); echo $?", "2\n"},
+		{"(cd /tmp; exit 2); echo $?; pwd", "2\n" + dir + "\n"},
+		{"(echo a; (echo b; echo c) | tr a-z A-Z)", "a\nB\nC\n"},
+	}
+	for _, tt := range tests {
+		stdout, stderr, _ := runWithOptions(t, tt.input)
+		if stdout != tt.expected {
+			t.Errorf("%q = %q, want %q; stderr %q", tt.input, stdout, tt.expected, stderr)
+		}
+	}
+	if exited {
+		t.Errorf("exit in a subshell ended the shell")
+	}
+}
@@ -0,0 +1,300 @@
+package gosh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gosh/parser"
+)
+
+// testCommand implements `test EXPR` and `[ EXPR ]`. The return code is 0
+// when the expression is true, 1 when it is false and 2 on errors.
+func testCommand(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		cmd.ReturnCode = 1
+		return nil
+	}
+	name, args, _ := parser.ProcessCommand2(cmd.AndCommands[0].Pipelines[0].Commands[0])
+
+	if name == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			cmd.ReturnCode = 2
+			return fmt.Errorf("missing ']'")
+		}
+		args = args[:len(args)-1]
+	}
+
+	for i, arg := range args {
+		args[i] = unquoteArg(arg)
+		// \( and \) keep the parentheses from the shell.
+		if len(args[i]) == 2 && args[i][0] == '\\' {
+			args[i] = args[i][1:]
+		}
+	}
+
+	result, err := evaluateTest(args)
+	if err != nil {
+		cmd.ReturnCode = 2
+		return err
+	}
+	if result {
+		cmd.ReturnCode = 0
+	} else {
+		cmd.ReturnCode = 1
+	}
+	return nil
+}
+
+func unquoteArg(arg string) string {
+	if len(arg) >= 2 && (arg[0] == '\'' || arg[0] == '"') && arg[len(arg)-1] == arg[0] {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
+
+// evaluateTest evaluates a test expression. Up to four arguments are read
+// by their number first, as POSIX specifies, so that `[ ! = ! ]` is a
+// comparison. Anything else is parsed by recursive descent: -o binds
+// looser than -a, which binds looser than !, and ( ) groups.
+func evaluateTest(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			return args[1] == "", nil
+		}
+	case 3:
+		if testBinaryOperators[args[1]] {
+			return evaluateBinary(args[0], args[1], args[2])
+		}
+		switch {
+		case args[1] == "-a":
+			return args[0] != "" && args[2] != "", nil
+		case args[1] == "-o":
+			return args[0] != "" || args[2] != "", nil
+		case args[0] == "!":
+			result, err := evaluateTest(args[1:])
+			return !result, err
+		case args[0] == "(" && args[2] == ")":
+			return args[1] != "", nil
+		}
+	case 4:
+		switch {
+		case args[0] == "!":
+			result, err := evaluateTest(args[1:])
+			return !result, err
+		case args[0] == "(" && args[3] == ")":
+			return evaluateTest(args[1:3])
+		}
+	}
+	p := &testParser{args: args}
+	result, err := p.or()
+	if err == nil && p.pos < len(args) {
+		err = fmt.Errorf("too many arguments")
+	}
+	return result, err
+}
+
+type testParser struct {
+	args []string
+	pos  int
+}
+
+func (p *testParser) peek(offset int) string {
+	if p.pos+offset < len(p.args) {
+		return p.args[p.pos+offset]
+	}
+	return ""
+}
+
+func (p *testParser) remaining() int {
+	return len(p.args) - p.pos
+}
+
+func (p *testParser) or() (bool, error) {
+	result, err := p.and()
+	for err == nil && p.peek(0) == "-o" && p.remaining() > 1 {
+		p.pos++
+		var right bool
+		right, err = p.and()
+		result = result || right
+	}
+	return result, err
+}
+
+func (p *testParser) and() (bool, error) {
+	result, err := p.not()
+	for err == nil && p.peek(0) == "-a" && p.remaining() > 1 {
+		p.pos++
+		var right bool
+		right, err = p.not()
+		result = result && right
+	}
+	return result, err
+}
+
+func (p *testParser) not() (bool, error) {
+	if p.peek(0) == "!" && p.remaining() > 1 {
+		p.pos++
+		result, err := p.not()
+		return !result, err
+	}
+	return p.primary()
+}
+
+// primary parses a grouped expression, a binary or unary test, or a lone
+// string, which is true when it is not empty.
+func (p *testParser) primary() (bool, error) {
+	if p.remaining() == 0 {
+		return false, fmt.Errorf("argument expected")
+	}
+	word := p.peek(0)
+
+	if p.remaining() >= 3 && testBinaryOperators[p.peek(1)] {
+		op, right := p.peek(1), p.peek(2)
+		p.pos += 3
+		return evaluateBinary(word, op, right)
+	}
+	if word == "(" {
+		p.pos++
+		if p.remaining() == 0 {
+			return false, fmt.Errorf("`)' expected")
+		}
+		result, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		if p.peek(0) != ")" {
+			return false, fmt.Errorf("`)' expected")
+		}
+		p.pos++
+		return result, nil
+	}
+	if len(word) > 1 && word[0] == '-' && p.remaining() >= 2 {
+		if next := p.peek(1); p.remaining() == 2 || next != "-a" && next != "-o" && next != ")" {
+			p.pos += 2
+			return evaluateUnary(word, next)
+		}
+	}
+	p.pos++
+	return word != "", nil
+}
+
+var testBinaryOperators = map[string]bool{
+	"=": true, "==": true, "!=": true, "<": true, ">": true,
+	"-eq": true, "-ne": true, "-lt": true, "-le": true, "-gt": true, "-ge": true,
+	"-nt": true, "-ot": true, "-ef": true,
+}
+
+func evaluateUnary(op, operand string) (bool, error) {
+	switch op {
+	case "-z":
+		return operand == "", nil
+	case "-n":
+		return operand != "", nil
+	}
+
+	path := resolvePath(GetGlobalState().GetCWD(), operand)
+	switch op {
+	case "-L", "-h":
+		info, err := os.Lstat(path)
+		return err == nil && info.Mode()&os.ModeSymlink != 0, nil
+	}
+
+	info, err := os.Stat(path)
+	switch op {
+	case "-e":
+		return err == nil, nil
+	case "-f":
+		return err == nil && info.Mode().IsRegular(), nil
+	case "-d":
+		return err == nil && info.IsDir(), nil
+	case "-s":
+		return err == nil && info.Size() > 0, nil
+	case "-r":
+		return err == nil && info.Mode().Perm()&0444 != 0, nil
+	case "-w":
+		return err == nil && info.Mode().Perm()&0222 != 0, nil
+	case "-x":
+		return err == nil && info.Mode().Perm()&0111 != 0, nil
+	default:
+		return false, fmt.Errorf("%s: unary operator expected", op)
+	}
+}
+
+func evaluateBinary(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	case "-nt", "-ot", "-ef":
+		return compareFiles(left, op, right), nil
+	}
+
+	a, err := parseShellInt(left)
+	if err != nil {
+		return false, err
+	}
+	b, err := parseShellInt(right)
+	if err != nil {
+		return false, err
+	}
+
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	case "-ge":
+		return a >= b, nil
+	default:
+		return false, fmt.Errorf("%s: binary operator expected", op)
+	}
+}
+
+// compareFiles evaluates the -nt, -ot and -ef operators. As in bash, an
+// existing file is newer than a missing one, and -ef is true when both
+// names lead to the same device and inode.
+func compareFiles(left, op, right string) bool {
+	cwd := GetGlobalState().GetCWD()
+	a, errA := os.Stat(resolvePath(cwd, left))
+	b, errB := os.Stat(resolvePath(cwd, right))
+	switch op {
+	case "-nt":
+		return errA == nil && (errB != nil || a.ModTime().After(b.ModTime()))
+	case "-ot":
+		return errB == nil && (errA != nil || a.ModTime().Before(b.ModTime()))
+	default:
+		return errA == nil && errB == nil && os.SameFile(a, b)
+	}
+}
+
+// parseShellInt parses a decimal integer operand, reporting values that do
+// not fit in an int64 instead of silently clamping them.
+func parseShellInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("%s: value too large", s)
+		}
+		return 0, fmt.Errorf("%s: integer expression expected", s)
+	}
+	return n, nil
+}
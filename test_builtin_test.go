@@ -0,0 +1,204 @@
+package gosh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runTestBuiltin(t *testing.T, input string) (int, string) {
+	t.Helper()
+	cmd, err := NewCommand(input, NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command %q: %v", input, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	return cmd.ReturnCode, stderr.String()
+}
+
+func TestTestBuiltin(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "file.txt")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"test 1 -lt 2", 0},
+		{"test 2 -lt 1", 1},
+		{"[ 5 -eq 5 ]", 0},
+		{"[ 5 -ne 5 ]", 1},
+		{"[ -9223372036854775808 -lt 9223372036854775807 ]", 0},
+		{"test abc = abc", 0},
+		{"test abc != abc", 1},
+		{"test -z ''", 0},
+		{"test -n ''", 1},
+		{"test -f " + file, 0},
+		{"test -d " + file, 1},
+		{"test -d " + tempDir, 0},
+		{"test ! -e " + filepath.Join(tempDir, "missing"), 0},
+		{"test 1 -eq 1 -a 2 -eq 3", 1},
+		{"test 1 -eq 2 -o 2 -eq 2", 0},
+		{"test 1 -eq 2 -o 2 -eq 2 -a 3 -eq 4", 1},
+		{"test", 1},
+	}
+
+	for _, tt := range tests {
+		code, stderr := runTestBuiltin(t, tt.input)
+		if code != tt.expected {
+			t.Errorf("%q ReturnCode = %d, want %d (stderr: %q)", tt.input, code, tt.expected, stderr)
+		}
+	}
+}
+
+func TestTestGrouping(t *testing.T) {
+	dir := useTempCWD(t)
+	for _, name := range []string{"a", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{`[ \( -f a -o -f b \) -a -r c ]`, 0},
+		{`[ \( -f b -o -f d \) -a -r c ]`, 1},
+		{"test '(' -f b -o -f a ')' -a -f c", 0},
+		{"test 1 -eq 2 -o 2 -eq 2 -a 3 -eq 4", 1},
+		{`test \( 1 -eq 2 -o 2 -eq 2 \) -a 3 -eq 4`, 1},
+		{`test \( 1 -eq 2 -o 2 -eq 2 \) -a 3 -eq 3`, 0},
+		{`test ! \( -f a -a -f c \)`, 1},
+		{`test ! \( -f a -a -f b \)`, 0},
+		{`test \( ! -f a \) -o -f c`, 0},
+		{`test ! -f a -o -f c`, 0},
+		{`test ! \( -f a -o -f c \)`, 1},
+		{`test \( \( -f a \) \)`, 0},
+		{`test \( -f a`, 2},
+		{`test -f a \)`, 2},
+		{"test a b", 2},
+		{"test -q a", 2},
+		{"test -n -a", 0},
+		{"test (", 0},
+		{`[ ! = ! ]`, 0},
+		{`[ ! != ! ]`, 1},
+		{`[ ! ! = ! ]`, 1},
+		{`[ ! ]`, 0},
+		{`[ ! -n "" ]`, 0},
+		{`[ "" -a x ]`, 1},
+		{`[ "" -o x ]`, 0},
+		{`[ \( x \) ]`, 0},
+		{`[ \( -f a \) ]`, 0},
+		{`[ -f x -a \( ]`, 2},
+	}
+	for _, tt := range tests {
+		code, stderr := runTestBuiltin(t, tt.input)
+		if code != tt.expected {
+			t.Errorf("%q ReturnCode = %d, want %d (stderr: %q)", tt.input, code, tt.expected, stderr)
+		}
+	}
+}
+
+func TestTestFileComparisons(t *testing.T) {
+	tempDir := t.TempDir()
+	older := filepath.Join(tempDir, "older")
+	newer := filepath.Join(tempDir, "newer")
+	link := filepath.Join(tempDir, "link")
+	missing := filepath.Join(tempDir, "missing")
+	for _, file := range []string{older, newer} {
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+	now := time.Now()
+	if err := os.Chtimes(older, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to set times of %s: %v", older, err)
+	}
+	if err := os.Chtimes(newer, now, now); err != nil {
+		t.Fatalf("Failed to set times of %s: %v", newer, err)
+	}
+	if err := os.Link(older, link); err != nil {
+		t.Fatalf("Failed to link %s: %v", older, err)
+	}
+
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"test " + newer + " -nt " + older, 0},
+		{"test " + older + " -nt " + newer, 1},
+		{"test " + older + " -ot " + newer, 0},
+		{"test " + newer + " -ot " + older, 1},
+		{"test " + newer + " -nt " + missing, 0},
+		{"test " + missing + " -nt " + newer, 1},
+		{"test " + missing + " -ot " + newer, 0},
+		{"test " + newer + " -ot " + missing, 1},
+		{"test " + older + " -ef " + link, 0},
+		{"test " + older + " -ef " + newer, 1},
+		{"test " + missing + " -ef " + missing, 1},
+	}
+	for _, tt := range tests {
+		code, stderr := runTestBuiltin(t, tt.input)
+		if code != tt.expected {
+			t.Errorf("%q ReturnCode = %d, want %d (stderr: %q)", tt.input, code, tt.expected, stderr)
+		}
+	}
+}
+
+func TestTestBuiltinRejectsHugeNumbers(t *testing.T) {
+	huge := "1" + strings.Repeat("0", 99)
+
+	tests := []string{
+		"test " + huge + " -gt 1",
+		"[ 1 -lt " + huge + " ]",
+		"test -" + huge + " -eq 0",
+	}
+
+	for _, input := range tests {
+		code, stderr := runTestBuiltin(t, input)
+		if code != 2 {
+			t.Errorf("%q ReturnCode = %d, want 2", input, code)
+		}
+		if !strings.Contains(stderr, "value too large") {
+			t.Errorf("%q stderr = %q, want it to mention %q", input, stderr, "value too large")
+		}
+	}
+}
+
+func TestParseShellInt(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectedErr string
+	}{
+		{"42", 42, ""},
+		{"-7", -7, ""},
+		{"9223372036854775807", 9223372036854775807, ""},
+		{"9223372036854775808", 0, "value too large"},
+		{strings.Repeat("9", 100), 0, "value too large"},
+		{"abc", 0, "integer expression expected"},
+	}
+
+	for _, tt := range tests {
+		result, err := parseShellInt(tt.input)
+		if tt.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("parseShellInt(%q) error = %v, want %q", tt.input, err, tt.expectedErr)
+			}
+			continue
+		}
+		if err != nil || result != tt.expected {
+			t.Errorf("parseShellInt(%q) = (%d, %v), want %d", tt.input, result, err, tt.expected)
+		}
+	}
+}
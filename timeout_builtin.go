@@ -0,0 +1,122 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// timeoutStatus is returned when the command ran out of time, as in
+// coreutils timeout.
+const timeoutStatus = 124
+
+// timeoutKillDelay is how long a command gets to exit after SIGTERM
+// before it is killed.
+const timeoutKillDelay = 5 * time.Second
+
+// timeoutCommand implements `timeout DURATION COMMAND [ARG]...`.
+func timeoutCommand(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	parts := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts
+	if len(parts) < 3 {
+		cmd.ReturnCode = 125
+		return fmt.Errorf("Usage: timeout DURATION COMMAND [ARG]...")
+	}
+
+	duration, err := parseTimeoutDuration(parts[1])
+	if err != nil {
+		cmd.ReturnCode = 125
+		return err
+	}
+
+	execCmd, ctx, cancel := commandWithTimeout(duration, parts[2], parts[3:]...)
+	defer cancel()
+	execCmd.Dir = GetGlobalState().GetCWD()
+	execCmd.Stdin = cmd.Stdin
+	execCmd.Stdout = cmd.Stdout
+	execCmd.Stderr = cmd.Stderr
+
+	err = execCmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		cmd.ReturnCode = timeoutStatus
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		cmd.ReturnCode = exitErr.ExitCode()
+		return nil
+	}
+	if err != nil {
+		cmd.ReturnCode = 127
+		return err
+	}
+	return nil
+}
+
+// commandWithTimeout returns a command that is sent SIGTERM once duration
+// has passed, and killed if it is still running timeoutKillDelay later.
+// The context reports whether that happened. As in coreutils, a zero
+// duration disables the timeout.
+func commandWithTimeout(duration time.Duration, name string, args ...string) (*exec.Cmd, context.Context, context.CancelFunc) {
+	if duration <= 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		return exec.Command(name, args...), ctx, cancel
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	execCmd := exec.CommandContext(ctx, name, args...)
+	execCmd.Cancel = func() error {
+		return execCmd.Process.Signal(syscall.SIGTERM)
+	}
+	execCmd.WaitDelay = timeoutKillDelay
+	return execCmd, ctx, cancel
+}
+
+// commandTimeout returns the limit GOSH_COMMAND_TIMEOUT places on every
+// external command, or 0 when it is unset or invalid.
+func commandTimeout() time.Duration {
+	value := os.Getenv("GOSH_COMMAND_TIMEOUT")
+	if value == "" {
+		return 0
+	}
+	duration, err := parseTimeoutDuration(value)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+// parseTimeoutDuration parses a duration such as 5, 1.5s, 2m, 1h or 1d.
+// A number without a suffix is in seconds. Durations too long for a
+// time.Duration, about 292 years, are rejected.
+func parseTimeoutDuration(s string) (time.Duration, error) {
+	unit := time.Second
+	number := s
+	if s != "" {
+		switch s[len(s)-1] {
+		case 's':
+			number = s[:len(s)-1]
+		case 'm':
+			unit, number = time.Minute, s[:len(s)-1]
+		case 'h':
+			unit, number = time.Hour, s[:len(s)-1]
+		case 'd':
+			unit, number = 24*time.Hour, s[:len(s)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 || math.IsNaN(value) || value*float64(unit) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid time interval '%s'", s)
+	}
+	return time.Duration(value * float64(unit)), nil
+}
@@ -0,0 +1,106 @@
+package gosh
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeoutBuiltin(t *testing.T) {
+	useTempCWD(t)
+
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"timeout 0.1 sleep 5", 124},
+		{"timeout 5s true", 0},
+		{"timeout 1m false", 1},
+		{"timeout 0 true", 0},
+		{"timeout 1x sleep 1", 125},
+		{"timeout 1", 125},
+		{"timeout 1 gosh-no-such-command", 127},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		cmd.Stdout = io.Discard
+		cmd.Stderr = io.Discard
+		start := time.Now()
+		cmd.Run()
+		if cmd.ReturnCode != tt.expected {
+			t.Errorf("%q ReturnCode = %d, want %d", tt.input, cmd.ReturnCode, tt.expected)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%q took %v", tt.input, elapsed)
+		}
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	useTempCWD(t)
+	t.Setenv("GOSH_COMMAND_TIMEOUT", "0.2")
+
+	tests := []struct {
+		input    string
+		expected []int
+	}{
+		{"sleep 5", []int{124}},
+		{"true", []int{0}},
+		{"sleep 5 | true", []int{124, 0}},
+		{"echo fast | sleep 5", []int{0, 124}},
+	}
+
+	for _, tt := range tests {
+		cmd, err := NewCommand(tt.input, NewJobManager())
+		if err != nil {
+			t.Fatalf("Failed to create command %q: %v", tt.input, err)
+		}
+		cmd.Stdout = io.Discard
+		cmd.Stderr = io.Discard
+		start := time.Now()
+		cmd.Run()
+		if status := GetGlobalState().GetPipeStatus(); !reflect.DeepEqual(status, tt.expected) {
+			t.Errorf("%q PIPESTATUS = %v, want %v", tt.input, status, tt.expected)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%q took %v", tt.input, elapsed)
+		}
+	}
+
+	t.Setenv("GOSH_COMMAND_TIMEOUT", "bogus")
+	if timeout := commandTimeout(); timeout != 0 {
+		t.Errorf("commandTimeout() with an invalid value = %v, want 0", timeout)
+	}
+}
+
+func TestParseTimeoutDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"5", 5 * time.Second},
+		{"5s", 5 * time.Second},
+		{"1.5s", 1500 * time.Millisecond},
+		{"2m", 2 * time.Minute},
+		{"1h", time.Hour},
+		{"1d", 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		result, err := parseTimeoutDuration(tt.input)
+		if err != nil || result != tt.expected {
+			t.Errorf("parseTimeoutDuration(%q) = (%v, %v), want %v", tt.input, result, err, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"", "s", "-1", "abc", "inf", "1e20", "1e8d", "9223372036854775807"} {
+		if _, err := parseTimeoutDuration(input); err == nil {
+			t.Errorf("parseTimeoutDuration(%q) succeeded, want an error", input)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// trapSignals are the signals trap accepts besides EXIT, by name without
+// the SIG prefix. The shell's main loop catches each of them and runs its
+// handler.
+var trapSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+var (
+	traps   = make(map[string]string)
+	trapsMu sync.Mutex
+)
+
+// parseSigspec returns the name trap stores a handler under for a signal
+// given by name, with or without the SIG prefix, or by number. EXIT is 0.
+func parseSigspec(spec string) (string, error) {
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	if name == "EXIT" || name == "0" {
+		return "EXIT", nil
+	}
+	if _, ok := trapSignals[name]; ok {
+		return name, nil
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		for name, sig := range trapSignals {
+			if int(sig) == n {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s: invalid signal specification", spec)
+}
+
+func signalName(sig os.Signal) string {
+	for name, s := range trapSignals {
+		if s == sig {
+			return name
+		}
+	}
+	return ""
+}
+
+// runTrap runs the handler set for name, if there is one, and reports
+// whether there was. An EXIT handler is removed first, so that an exit
+// inside it does not run it again.
+func runTrap(name string, jobManager *JobManager, stdin io.Reader, stdout, stderr io.Writer) bool {
+	trapsMu.Lock()
+	handler, ok := traps[name]
+	if name == "EXIT" {
+		delete(traps, name)
+	}
+	trapsMu.Unlock()
+	if !ok {
+		return false
+	}
+	if strings.TrimSpace(handler) == "" {
+		return true
+	}
+
+	cmd, err := NewCommand(handler, jobManager)
+	if err != nil {
+		fmt.Fprintf(stderr, "trap %s: %v\n", name, err)
+		return true
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Run()
+	return true
+}
+
+// RunExitTrap runs the EXIT handler, if any, before the shell terminates.
+func RunExitTrap(jobManager *JobManager) {
+	runTrap("EXIT", jobManager, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// RunSignalTrap runs the handler set for sig, if any, and reports whether
+// there was one.
+func RunSignalTrap(sig os.Signal, jobManager *JobManager) bool {
+	name := signalName(sig)
+	if name == "" {
+		return false
+	}
+	return runTrap(name, jobManager, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// trap implements `trap 'COMMANDS' SIGSPEC...` to set handlers,
+// `trap - SIGSPEC...` to remove them, `trap -l` to list the signals and
+// `trap` to list the handlers. An empty COMMANDS ignores the signal.
+func trap(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		for _, arg := range cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:] {
+			args = append(args, unquoteArg(arg))
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	cmd.ReturnCode = 0
+
+	switch {
+	case len(args) == 0:
+		return listTraps(cmd)
+	case args[0] == "-l":
+		return listTrapSignals(cmd)
+	case len(args) == 1:
+		cmd.ReturnCode = 2
+		return fmt.Errorf("Usage: trap [-l] [[COMMANDS | -] SIGSPEC...]")
+	}
+
+	handler, remove := args[0], args[0] == "-"
+	for _, spec := range args[1:] {
+		name, err := parseSigspec(spec)
+		if err != nil {
+			cmd.printError("trap: %v\n", err)
+			cmd.ReturnCode = 1
+			continue
+		}
+		trapsMu.Lock()
+		if remove {
+			delete(traps, name)
+		} else {
+			traps[name] = handler
+		}
+		trapsMu.Unlock()
+	}
+	return nil
+}
+
+func listTraps(cmd *Command) error {
+	trapsMu.Lock()
+	names := make([]string, 0, len(traps))
+	for name := range traps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		signal := name
+		if name != "EXIT" {
+			signal = "SIG" + name
+		}
+		lines[i] = fmt.Sprintf("trap -- %s %s", shellQuote(traps[name]), signal)
+	}
+	trapsMu.Unlock()
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(cmd.Stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listTrapSignals(cmd *Command) error {
+	names := make([]string, 0, len(trapSignals))
+	for name := range trapSignals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return trapSignals[names[i]] < trapSignals[names[j]] })
+	for _, name := range names {
+		if _, err := fmt.Fprintf(cmd.Stdout, "%d) SIG%s\n", int(trapSignals[name]), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
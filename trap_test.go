@@ -0,0 +1,62 @@
+package gosh
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestTrapBuiltin(t *testing.T) {
+	defer func() {
+		trapsMu.Lock()
+		traps = make(map[string]string)
+		trapsMu.Unlock()
+	}()
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"trap 'echo interrupted' INT SIGTERM", "", 0},
+		{"trap 'echo bye' 0", "", 0},
+		{"trap", "trap -- 'echo bye' EXIT\ntrap -- 'echo interrupted' SIGINT\ntrap -- 'echo interrupted' SIGTERM\n", 0},
+		{"trap - TERM", "", 0},
+		{"trap", "trap -- 'echo bye' EXIT\ntrap -- 'echo interrupted' SIGINT\n", 0},
+		{"trap 'echo x' NOPE", "", 1},
+		{"trap 'echo x'", "", 2},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+
+	if stdout, _, _ := runWithOptions(t, "trap -l"); stdout == "" || stdout[:9] != "1) SIGHUP" {
+		t.Errorf("trap -l = %q, want the signals starting with 1) SIGHUP", stdout)
+	}
+	if !RunSignalTrap(syscall.SIGINT, NewJobManager()) || RunSignalTrap(syscall.SIGUSR1, NewJobManager()) {
+		t.Errorf("RunSignalTrap ran the wrong handlers")
+	}
+}
+
+func TestExitRunsExitTrap(t *testing.T) {
+	var exited []int
+	exitProcess = func(code int) { exited = append(exited, code) }
+	defer func() { exitProcess = os.Exit }()
+
+	stdout, _, _ := runWithOptions(t, "trap 'echo cleaning up; exit 9' EXIT; exit 3")
+	if stdout != "cleaning up\n" {
+		t.Errorf("exit trap output = %q, want %q", stdout, "cleaning up\n")
+	}
+	// The exit inside the trap does not run it again.
+	if len(exited) != 2 || exited[0] != 9 || exited[1] != 3 {
+		t.Errorf("exited with %v, want [9 3]", exited)
+	}
+
+	exited = nil
+	if stdout, _, _ := runWithOptions(t, "exit 0"); stdout != "" || len(exited) != 1 {
+		t.Errorf("exit after the trap ran = (%q, %v), want no output and one exit", stdout, exited)
+	}
+}
@@ -0,0 +1,122 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// typeCommand implements `type [-tp] NAME...`, reporting for each name
+// whether it is an alias, a builtin or a file found in PATH. -t prints
+// only the kind of name and -p only the path of a file. The status is 1
+// if any name is not found.
+func typeCommand(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	args := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+
+	var kindOnly, pathOnly bool
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 't':
+				kindOnly = true
+			case 'p':
+				pathOnly = true
+			default:
+				cmd.ReturnCode = 2
+				return fmt.Errorf("-%c: invalid option\nUsage: type [-tp] NAME...", flag)
+			}
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		cmd.ReturnCode = 2
+		return fmt.Errorf("Usage: type [-tp] NAME...")
+	}
+
+	cmd.ReturnCode = 0
+	for _, name := range args {
+		kind, description := resolveName(name)
+		var line string
+		switch {
+		case kind == "":
+			cmd.ReturnCode = 1
+			if !kindOnly && !pathOnly {
+				cmd.printError("type: %s: not found\n", name)
+			}
+			continue
+		case kindOnly:
+			line = kind
+		case pathOnly:
+			if kind != "file" {
+				continue
+			}
+			line = description
+		case kind == "alias":
+			line = fmt.Sprintf("%s is aliased to '%s'", name, description)
+		case kind == "builtin":
+			line = fmt.Sprintf("%s is a shell builtin", name)
+		default:
+			line = fmt.Sprintf("%s is %s", name, description)
+		}
+		if _, err := fmt.Fprintln(cmd.Stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveName reports how the shell would run name: as an "alias" with
+// its replacement text, a "builtin", or a "file" with its path. The kind
+// is "" if the name is not found.
+func resolveName(name string) (kind, description string) {
+	if command, ok := GetAlias(name); ok {
+		return "alias", command
+	}
+	if _, ok := lookupBuiltin(name); ok {
+		return "builtin", ""
+	}
+	if path, ok := findExecutable(name); ok {
+		return "file", path
+	}
+	return "", ""
+}
+
+// findExecutable searches PATH for an executable named name. A name
+// containing a slash is only checked relative to the shell's directory.
+func findExecutable(name string) (string, bool) {
+	if strings.Contains(name, "/") {
+		return name, isExecutable(resolvePath(GetGlobalState().GetCWD(), name))
+	}
+	for _, dir := range filepath.SplitList(lookupVariable("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		path := filepath.Join(dir, name)
+		if isExecutable(resolvePath(GetGlobalState().GetCWD(), path)) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// findAbsoluteExecutable is findExecutable with the path made absolute.
+func findAbsoluteExecutable(name string) (string, bool) {
+	path, ok := findExecutable(name)
+	if !ok {
+		return "", false
+	}
+	return resolvePath(GetGlobalState().GetCWD(), path), true
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
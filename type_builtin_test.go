@@ -0,0 +1,47 @@
+package gosh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTypeBuiltin(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "gosh-type-tool")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write %s: %v", tool, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gosh-type-data"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write data file: %v", err)
+	}
+	GetGlobalState().SetVar("PATH", dir)
+	defer GetGlobalState().UnsetVar("PATH")
+	SetAlias("gosh_ll", "ls -la")
+	defer RemoveAlias("gosh_ll")
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"type gosh_ll", "gosh_ll is aliased to 'ls -la'\n", 0},
+		{"type cd", "cd is a shell builtin\n", 0},
+		{"type gosh-type-tool", "gosh-type-tool is " + tool + "\n", 0},
+		{"type gosh_ll cd gosh-type-tool", "gosh_ll is aliased to 'ls -la'\ncd is a shell builtin\ngosh-type-tool is " + tool + "\n", 0},
+		{"type -t gosh_ll cd gosh-type-tool", "alias\nbuiltin\nfile\n", 0},
+		{"type -p gosh_ll cd gosh-type-tool", tool + "\n", 0},
+		{"type gosh-type-data", "", 1},
+		{"type -t gosh-type-missing cd", "builtin\n", 1},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+
+	if _, stderr, _ := runWithOptions(t, "type gosh-type-missing"); stderr != "type: gosh-type-missing: not found\n" {
+		t.Errorf("type gosh-type-missing wrote %q to stderr, want a not found error", stderr)
+	}
+}
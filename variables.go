@@ -0,0 +1,572 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultIFS is the field separator used when IFS is unset.
+const defaultIFS = " \t\n"
+
+// shellPID is the PID of the shell, which $$ reports. $GOSHPID and
+// $BASHPID report the PID of the process running the command, which is
+// the same, since gosh runs subshells and command substitutions in its own
+// process rather than forking.
+var shellPID = os.Getpid()
+
+var (
+	assignmentPattern   = regexp.MustCompile(`(?s)^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+	variableNamePrefix  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// lookupVariable returns the value of a shell variable. Special variables
+// maintained by the shell take precedence over shell variables, which in
+// turn take precedence over the process environment.
+func lookupVariable(name string) string {
+	switch name {
+	case "PIPESTATUS":
+		return strings.Join(arrayElements(name), " ")
+	case "$":
+		return strconv.Itoa(shellPID)
+	case "?":
+		return strconv.Itoa(GetGlobalState().GetLastExitStatus())
+	case "!":
+		if pid := GetGlobalState().GetLastBackgroundPID(); pid != 0 {
+			return strconv.Itoa(pid)
+		}
+		return ""
+	case "@", "*":
+		return strings.Join(GetGlobalState().GetPositionalParams(), " ")
+	case "#":
+		return strconv.Itoa(len(GetGlobalState().GetPositionalParams()))
+	case "GOSHPID", "BASHPID":
+		return strconv.Itoa(os.Getpid())
+	}
+	if index, ok := positionalIndex(name); ok {
+		if params := GetGlobalState().GetPositionalParams(); index <= len(params) {
+			return params[index-1]
+		}
+		return ""
+	}
+	if value, ok := GetGlobalState().GetVar(name); ok {
+		return value
+	}
+	if elements, ok := GetGlobalState().GetArray(name); ok {
+		if len(elements) == 0 {
+			return ""
+		}
+		return elements[0]
+	}
+	return os.Getenv(name)
+}
+
+// variableIsSet reports whether a variable has a value, even an empty one.
+func variableIsSet(name string) bool {
+	switch name {
+	case "PIPESTATUS", "$", "?", "@", "*", "#", "GOSHPID", "BASHPID":
+		return true
+	case "!":
+		return GetGlobalState().GetLastBackgroundPID() != 0
+	}
+	if index, ok := positionalIndex(name); ok {
+		return index <= len(GetGlobalState().GetPositionalParams())
+	}
+	if _, ok := GetGlobalState().GetVar(name); ok {
+		return true
+	}
+	if _, ok := GetGlobalState().GetArray(name); ok {
+		return true
+	}
+	_, ok := os.LookupEnv(name)
+	return ok
+}
+
+// positionalIndex reports whether name is a positional parameter such as
+// 1 or 10, and which.
+func positionalIndex(name string) (int, bool) {
+	index, err := strconv.Atoi(name)
+	if err != nil || index < 1 || name[0] == '+' {
+		return 0, false
+	}
+	return index, true
+}
+
+// expandParameters replaces $NAME, the special parameters $$, $?, $!, $#,
+// $@ and $*, the positional parameters $1 to $9 and every ${...} in s with
+// its value. ${...} supports the POSIX modifiers -, =, + and ?, with or
+// without a colon, as well as ${#VAR}, ${VAR:offset:length} and array
+// subscripts such as ${PIPESTATUS[1]}.
+// Single-quoted text and a $ escaped with a backslash are left alone.
+func expandParameters(s string) (string, error) {
+	return expandParametersAt(s, 0)
+}
+
+// maxExpansionDepth bounds how deeply ${...} expansions may nest inside
+// the words of one another.
+const maxExpansionDepth = 64
+
+func expandParametersAt(s string, depth int) (string, error) {
+	if depth > maxExpansionDepth {
+		return "", fmt.Errorf("parameter expansion nested too deeply")
+	}
+	var result strings.Builder
+	var quotes quoteState
+	for i := 0; i < len(s); i++ {
+		if quotes.literal(s[i]) || s[i] != '$' || (i > 0 && s[i-1] == '\\') {
+			result.WriteByte(s[i])
+			continue
+		}
+
+		rest := s[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "{"):
+			end := parameterEnd(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("%s: bad substitution", s[i:])
+			}
+			value, err := expandParameter(s[i+2:end], depth)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			i = end
+		case strings.HasPrefix(rest, "(") && !strings.HasPrefix(rest, "(("):
+			// A command substitution expands its own parameters when
+			// it runs.
+			end := substitutionEnd(s, i+2)
+			if end < 0 {
+				result.WriteByte(s[i])
+				continue
+			}
+			result.WriteString(s[i : end+1])
+			i = end
+		case rest != "" && strings.IndexByte("$?!#@*", rest[0]) >= 0:
+			result.WriteString(lookupVariable(rest[:1]))
+			i++
+		case rest != "" && '1' <= rest[0] && rest[0] <= '9':
+			if err := checkBound(rest[:1]); err != nil {
+				return "", err
+			}
+			result.WriteString(lookupVariable(rest[:1]))
+			i++
+		default:
+			name := variableNamePrefix.FindString(rest)
+			if name == "" {
+				result.WriteByte(s[i])
+				continue
+			}
+			if err := checkBound(name); err != nil {
+				return "", err
+			}
+			result.WriteString(lookupVariable(name))
+			i += len(name)
+		}
+	}
+	return result.String(), nil
+}
+
+// arrayElements returns the elements of an array variable. PIPESTATUS and
+// arrays made by `read -a` are real arrays; any other set variable is an
+// array of one element, as in bash.
+func arrayElements(name string) []string {
+	if name == "PIPESTATUS" {
+		codes := GetGlobalState().GetPipeStatus()
+		elements := make([]string, len(codes))
+		for i, code := range codes {
+			elements[i] = strconv.Itoa(code)
+		}
+		return elements
+	}
+	if elements, ok := GetGlobalState().GetArray(name); ok {
+		return elements
+	}
+	if !variableIsSet(name) {
+		return nil
+	}
+	return []string{lookupVariable(name)}
+}
+
+// arrayElement expands ${NAME[subscript]}. The subscript @ or * joins
+// every element with spaces; anything else is an arithmetic index, which
+// counts from the end when negative. Indexes out of range expand to "".
+func arrayElement(name, subscript string) (string, error) {
+	elements := arrayElements(name)
+	if subscript == "@" || subscript == "*" {
+		return strings.Join(elements, " "), nil
+	}
+	index, err := EvalArithmetic(subscript)
+	if err != nil {
+		return "", err
+	}
+	if index < 0 {
+		index += int64(len(elements))
+	}
+	if index < 0 || index >= int64(len(elements)) {
+		return "", nil
+	}
+	return elements[index], nil
+}
+
+// checkBound fails for an unset variable when the nounset option is on.
+func checkBound(name string) error {
+	if GetGlobalState().GetOption("nounset") && !variableIsSet(name) {
+		return fmt.Errorf("%s: unbound variable", name)
+	}
+	return nil
+}
+
+// quoteState follows the quoting of a command line one byte at a time.
+type quoteState struct {
+	single, double bool
+}
+
+// literal updates the state for c and reports whether c is quoted by
+// single quotes, or is one of them, and so must not be expanded.
+func (q *quoteState) literal(c byte) bool {
+	switch {
+	case c == '\'' && !q.double:
+		q.single = !q.single
+		return true
+	case c == '"' && !q.single:
+		q.double = !q.double
+	}
+	return q.single
+}
+
+// parameterEnd returns the index of the brace closing a parameter
+// expansion whose body starts at start, or -1 if there is none.
+func parameterEnd(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// expandParameter expands the body of a ${...} expansion. The word of a
+// modifier is only expanded when it is used.
+func expandParameter(body string, depth int) (string, error) {
+	if body == "#" || body == "#@" || body == "#*" {
+		return lookupVariable("#"), nil
+	}
+	if body == "@" || body == "*" {
+		return lookupVariable(body), nil
+	}
+	if name, subscript, ok := strings.Cut(strings.TrimPrefix(body, "#"), "["); ok && strings.HasPrefix(body, "#") && isVariableName(name) && (subscript == "@]" || subscript == "*]") {
+		return strconv.Itoa(len(arrayElements(name))), nil
+	}
+	if strings.HasPrefix(body, "#") && isVariableName(body[1:]) {
+		if err := checkBound(body[1:]); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(utf8.RuneCountInString(lookupVariable(body[1:]))), nil
+	}
+
+	name := body
+	if i := strings.IndexFunc(body, func(r rune) bool {
+		return r != '_' && !('0' <= r && r <= '9') && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z')
+	}); i >= 0 {
+		name = body[:i]
+	}
+	modifier := body[len(name):]
+	if name == "" {
+		return "", fmt.Errorf("${%s}: bad substitution", body)
+	}
+
+	value := lookupVariable(name)
+	if strings.HasPrefix(modifier, "[") {
+		end := strings.IndexByte(modifier, ']')
+		if end < 0 {
+			return "", fmt.Errorf("${%s}: bad substitution", body)
+		}
+		element, err := arrayElement(name, modifier[1:end])
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %v", body, err)
+		}
+		value, modifier = element, modifier[end+1:]
+	}
+	if modifier == "" {
+		if err := checkBound(name); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+
+	colon := strings.HasPrefix(modifier, ":")
+	modifier = strings.TrimPrefix(modifier, ":")
+	if colon && (modifier == "" || !strings.ContainsRune("-=+?", rune(modifier[0]))) {
+		result, err := substring(value, modifier)
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %v", body, err)
+		}
+		return result, nil
+	}
+	if modifier == "" || !strings.ContainsRune("-=+?", rune(modifier[0])) {
+		return "", fmt.Errorf("${%s}: bad substitution", body)
+	}
+	op, word := modifier[0], modifier[1:]
+	missing := !variableIsSet(name) || (colon && value == "")
+
+	switch op {
+	case '-':
+		if missing {
+			return expandParameterWord(word, depth)
+		}
+	case '=':
+		if missing {
+			expanded, err := expandParameterWord(word, depth)
+			if err != nil {
+				return "", err
+			}
+			if err := setVariable(name, expanded); err != nil {
+				return "", err
+			}
+			return lookupVariable(name), nil
+		}
+	case '+':
+		if missing {
+			return "", nil
+		}
+		return expandParameterWord(word, depth)
+	case '?':
+		if missing {
+			message, err := expandParameterWord(word, depth)
+			if err != nil {
+				return "", err
+			}
+			if message == "" {
+				message = "parameter null or not set"
+			}
+			return "", fmt.Errorf("%s: %s", name, message)
+		}
+	}
+	return value, nil
+}
+
+// substring implements ${VAR:offset:length}. Both are arithmetic
+// expressions counted in characters; a negative offset counts from the end
+// of value and a negative length stops that many characters from the end.
+func substring(value, spec string) (string, error) {
+	offsetExpr, lengthExpr, hasLength := strings.Cut(spec, ":")
+	runes := []rune(value)
+
+	offset, err := EvalArithmetic(offsetExpr)
+	if err != nil {
+		return "", err
+	}
+	if offset < 0 {
+		offset += int64(len(runes))
+	}
+	if offset < 0 || offset > int64(len(runes)) {
+		return "", nil
+	}
+	if !hasLength {
+		return string(runes[offset:]), nil
+	}
+
+	length, err := EvalArithmetic(lengthExpr)
+	if err != nil {
+		return "", err
+	}
+	end := offset + length
+	if length < 0 {
+		end = int64(len(runes)) + length
+	}
+	if end > int64(len(runes)) {
+		end = int64(len(runes))
+	}
+	if end < offset {
+		return "", nil
+	}
+	return string(runes[offset:end]), nil
+}
+
+func isVariableName(name string) bool {
+	return variableNamePattern.MatchString(name)
+}
+
+// expandParameterWord expands the word of a modifier, which may itself
+// refer to variables as $NAME or ${...}.
+func expandParameterWord(word string, depth int) (string, error) {
+	return expandParametersAt(word, depth+1)
+}
+
+// setVariable assigns a variable the way NAME=value does: variables that
+// are already exported are updated in the environment, anything else is
+// kept as a shell variable.
+func setVariable(name, value string) error {
+	value, err := attributeValue(name, value)
+	if err != nil {
+		return err
+	}
+	if _, exported := os.LookupEnv(name); exported {
+		return os.Setenv(name, value)
+	}
+	GetGlobalState().SetVar(name, value)
+	return nil
+}
+
+// attributeValue checks an assignment against the attributes declare gave
+// the variable: a read-only variable cannot be assigned, and the value of
+// an integer variable is evaluated as arithmetic.
+func attributeValue(name, value string) (string, error) {
+	attributes := GetGlobalState().GetVarAttributes(name)
+	if attributes.ReadOnly {
+		return "", fmt.Errorf("%s: readonly variable", name)
+	}
+	if attributes.Integer {
+		n, err := EvalArithmetic(value)
+		if err != nil {
+			return "", err
+		}
+		value = strconv.FormatInt(n, 10)
+	}
+	return value, nil
+}
+
+// parseAssignment reports whether word has the form NAME=value.
+func parseAssignment(word string) (string, string, bool) {
+	match := assignmentPattern.FindStringSubmatch(word)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], removeQuotes(match[2]), true
+}
+
+// assignmentBuiltins take NAME=value operands and remove their quotes
+// themselves.
+var assignmentBuiltins = map[string]bool{
+	"declare": true,
+	"export":  true,
+	"typeset": true,
+}
+
+// isAssignmentWord reports whether words[i] is an assignment: a NAME=value
+// word before the command name, or an operand of export or declare. Other
+// words that look like NAME=value, such as echo's arguments, are not.
+func isAssignmentWord(words []string, i int) bool {
+	if !assignmentPattern.MatchString(words[i]) {
+		return false
+	}
+	for _, word := range words[:i] {
+		if !assignmentPattern.MatchString(word) {
+			return assignmentBuiltins[word]
+		}
+	}
+	return true
+}
+
+// removeQuotes strips the quotes from a word, keeping the text inside
+// them, and the backslashes escaping a character: any character outside
+// quotes, or one of $ ` " \ inside double quotes.
+func removeQuotes(word string) string {
+	var result strings.Builder
+	var quote byte
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(word) && (quote == 0 || strings.IndexByte("$`\"\\", word[i+1]) >= 0):
+			i++
+			result.WriteByte(word[i])
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return result.String()
+}
+
+// currentIFS returns the shell's field separators, falling back to the
+// default when IFS has never been set.
+func currentIFS() string {
+	if value, ok := GetGlobalState().GetVar("IFS"); ok {
+		return value
+	}
+	if value, ok := os.LookupEnv("IFS"); ok {
+		return value
+	}
+	return defaultIFS
+}
+
+// splitByIFS splits s into fields. Runs of IFS whitespace separate fields
+// and are trimmed from both ends; every other IFS character delimits a
+// field on its own.
+func splitByIFS(s, ifs string) []string {
+	return splitFieldsN(s, ifs, 0)
+}
+
+// splitFieldsN splits s like splitByIFS but into at most n fields, the
+// last of which holds the rest of s with its separators kept as they
+// are, as read assigns it to its last variable. n <= 0 means no limit.
+func splitFieldsN(s, ifs string, n int) []string {
+	return splitEscapedFieldsN([]rune(s), nil, ifs, n)
+}
+
+// splitEscapedFieldsN splits runes like splitFieldsN, except that a rune
+// marked in escaped is never a separator, as after a backslash in the
+// input of read.
+func splitEscapedFieldsN(runes []rune, escaped []bool, ifs string, n int) []string {
+	if ifs == "" {
+		if len(runes) == 0 {
+			return nil
+		}
+		return []string{string(runes)}
+	}
+
+	isIFS := func(i int) bool { return (escaped == nil || !escaped[i]) && strings.ContainsRune(ifs, runes[i]) }
+	isIFSSpace := func(i int) bool { return isIFS(i) && strings.ContainsRune(defaultIFS, runes[i]) }
+
+	start, end := 0, len(runes)
+	for start < end && isIFSSpace(start) {
+		start++
+	}
+	for end > start && isIFSSpace(end-1) {
+		end--
+	}
+	var fields []string
+	var current strings.Builder
+	for i := start; i < end; i++ {
+		if n > 0 && len(fields) == n-1 {
+			return append(fields, string(runes[i:end]))
+		}
+		if !isIFS(i) {
+			current.WriteRune(runes[i])
+			continue
+		}
+
+		fields = append(fields, current.String())
+		current.Reset()
+
+		// Whitespace around a separator belongs to that separator.
+		separatorIsSpace := isIFSSpace(i)
+		for i+1 < end && isIFSSpace(i+1) {
+			i++
+		}
+		if separatorIsSpace && i+1 < end && isIFS(i+1) {
+			i++
+			for i+1 < end && isIFSSpace(i+1) {
+				i++
+			}
+		}
+	}
+	if end > start {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
@@ -0,0 +1,306 @@
+package gosh
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDVariables(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	// Subshells run in the shell's own process, so every PID is the same
+	// inside them too.
+	for _, input := range []string{"echo $$", "echo $GOSHPID", "echo $BASHPID", "(echo $BASHPID)", "echo $(echo $GOSHPID)"} {
+		if output := runEcho(t, input); output != pid+"\n" {
+			t.Errorf("%s = %q, want %q", input, output, pid+"\n")
+		}
+	}
+}
+
+func TestParameterExpansion(t *testing.T) {
+	gs := GetGlobalState()
+	gs.SetVar("param_set", "value")
+	gs.SetVar("param_empty", "")
+	gs.SetVar("param_other", "other")
+	defer func() {
+		for _, name := range []string{"param_set", "param_empty", "param_other"} {
+			gs.UnsetVar(name)
+		}
+	}()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"${param_set}", "value"},
+		{"${param_set:-word}", "value"},
+		{"${param_empty:-word}", "word"},
+		{"${param_unset:-word}", "word"},
+		{"${param_empty-word}", ""},
+		{"${param_unset-word}", "word"},
+		{"${param_set:+word}", "word"},
+		{"${param_empty:+word}", ""},
+		{"${param_unset:+word}", ""},
+		{"${param_empty+word}", "word"},
+		{"${param_set:?missing}", "value"},
+		{"${param_unset:-$param_other}", "other"},
+		{"${param_unset:-${param_empty:-nested}}", "nested"},
+		{"a-${param_set}-b", "a-value-b"},
+		{"'${param_set}' ${param_set}", "'${param_set}' value"},
+	}
+
+	for _, tt := range tests {
+		result, err := expandParameters(tt.input)
+		if err != nil {
+			t.Errorf("expandParameters(%q) returned error: %v", tt.input, err)
+		} else if result != tt.expected {
+			t.Errorf("expandParameters(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestParameterExpansionAssigns(t *testing.T) {
+	t.Setenv("param_assign_empty", "")
+	defer GetGlobalState().UnsetVar("param_assign_unset")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"${param_assign_unset:=first}", "first"},
+		{"${param_assign_unset:=second}", "first"},
+		{"${param_assign_empty:=filled}", "filled"},
+	}
+
+	for _, tt := range tests {
+		if result, err := expandParameters(tt.input); err != nil || result != tt.expected {
+			t.Errorf("expandParameters(%q) = %q, %v, want %q", tt.input, result, err, tt.expected)
+		}
+	}
+	if value := lookupVariable("param_assign_unset"); value != "first" {
+		t.Errorf("param_assign_unset = %q, want %q", value, "first")
+	}
+	if _, exported := os.LookupEnv("param_assign_unset"); exported {
+		t.Errorf("${param_assign_unset:=first} exported the variable")
+	}
+	if value := os.Getenv("param_assign_empty"); value != "filled" {
+		t.Errorf("param_assign_empty = %q, want %q", value, "filled")
+	}
+}
+
+func TestParameterExpansionAssignsThroughAttributes(t *testing.T) {
+	for _, name := range []string{"param_assign_a", "param_assign_ro", "param_assign_int"} {
+		defer GetGlobalState().UnsetVar(name)
+		defer GetGlobalState().SetVarAttributes(name, VarAttributes{})
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"param_assign_a=; echo ${param_assign_a:=filled}; echo [$param_assign_a]", "filled\n[filled]\n"},
+		{"declare -i param_assign_int; echo ${param_assign_int:=1+2}", "3\n"},
+		{"declare -r param_assign_ro=; echo ${param_assign_ro:=x}; echo $?", "1\n"},
+	}
+	for _, tt := range tests {
+		if stdout, _, _ := runWithOptions(t, tt.input); stdout != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, stdout, tt.expected)
+		}
+	}
+	if _, stderr, code := runWithOptions(t, "declare -r param_assign_ro=; : ${param_assign_ro:=x}"); code != 1 || !strings.Contains(stderr, "param_assign_ro: readonly variable") {
+		t.Errorf(": ${param_assign_ro:=x} = (%q, %d), want a readonly error", stderr, code)
+	}
+}
+
+func TestParameterExpansionErrors(t *testing.T) {
+	GetGlobalState().SetVar("param_empty", "")
+	defer GetGlobalState().UnsetVar("param_empty")
+
+	tests := []struct {
+		input   string
+		message string
+	}{
+		{"${param_unset:?is required}", "param_unset: is required"},
+		{"${param_empty:?}", "param_empty: parameter null or not set"},
+		{"${param_unset?}", "param_unset: parameter null or not set"},
+		{"${param_unset", "${param_unset: bad substitution"},
+		{"${:-word}", "${:-word}: bad substitution"},
+		{"${param_unset%x}", "${param_unset%x}: bad substitution"},
+	}
+
+	for _, tt := range tests {
+		if _, err := expandParameters(tt.input); err == nil || err.Error() != tt.message {
+			t.Errorf("expandParameters(%q) error = %v, want %q", tt.input, err, tt.message)
+		}
+	}
+	if _, err := expandParameters("${param_empty?}"); err != nil {
+		t.Errorf("expandParameters(%q) returned error for an empty but set variable: %v", "${param_empty?}", err)
+	}
+
+	cmd, err := NewCommand("echo ${param_unset:?must be set}", NewJobManager())
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if cmd.ReturnCode == 0 || stdout.Len() != 0 || !strings.Contains(stderr.String(), "param_unset: must be set") {
+		t.Errorf("${param_unset:?must be set} = (%d, %q, %q), want an error", cmd.ReturnCode, stdout.String(), stderr.String())
+	}
+}
+
+func TestParameterLengthAndSubstring(t *testing.T) {
+	gs := GetGlobalState()
+	gs.SetVar("param_ascii", "abcdefgh")
+	gs.SetVar("param_utf8", "héllo wörld")
+	defer gs.UnsetVar("param_ascii")
+	defer gs.UnsetVar("param_utf8")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"${#param_ascii}", "8"},
+		{"${#param_utf8}", "11"},
+		{"${#param_unset}", "0"},
+		{"${#@}", "0"},
+		{"${param_ascii:2}", "cdefgh"},
+		{"${param_ascii:2:3}", "cde"},
+		{"${param_ascii: -3}", "fgh"},
+		{"${param_ascii: -3:2}", "fg"},
+		{"${param_ascii:(-2)}", "gh"},
+		{"${param_ascii:1:-2}", "bcdef"},
+		{"${param_ascii:6:-3}", ""},
+		{"${param_ascii:2:100}", "cdefgh"},
+		{"${param_ascii:8}", ""},
+		{"${param_ascii:20}", ""},
+		{"${param_ascii: -20}", ""},
+		{"${param_ascii:1+1:2*2}", "cdef"},
+		{"${param_utf8:1:4}", "éllo"},
+		{"${param_utf8: -5}", "wörld"},
+	}
+
+	for _, tt := range tests {
+		result, err := expandParameters(tt.input)
+		if err != nil {
+			t.Errorf("expandParameters(%q) returned error: %v", tt.input, err)
+		} else if result != tt.expected {
+			t.Errorf("expandParameters(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"${param_ascii:}", "${param_ascii:x y}", "${param_ascii:1:}"} {
+		if result, err := expandParameters(input); err == nil {
+			t.Errorf("expandParameters(%q) = %q, want error", input, result)
+		}
+	}
+}
+
+func TestArraySubscripts(t *testing.T) {
+	if output := runEcho(t, "true | false | true; echo ${PIPESTATUS[1]}"); output != "1\n" {
+		t.Errorf("${PIPESTATUS[1]} after true | false | true = %q, want %q", output, "1\n")
+	}
+
+	gs := GetGlobalState()
+	gs.SetPipeStatus([]int{0, 1, 141})
+	gs.SetVar("param_scalar", "value")
+	defer gs.UnsetVar("param_scalar")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"${PIPESTATUS[0]}", "0"},
+		{"${PIPESTATUS[2]}", "141"},
+		{"${PIPESTATUS[1+1]}", "141"},
+		{"${PIPESTATUS[-1]}", "141"},
+		{"${PIPESTATUS[3]}", ""},
+		{"${PIPESTATUS[3]:-none}", "none"},
+		{"${PIPESTATUS[@]}", "0 1 141"},
+		{"${#PIPESTATUS[@]}", "3"},
+		{"${param_scalar[0]}", "value"},
+		{"${param_scalar[1]}", ""},
+		{"${#param_scalar[*]}", "1"},
+		{"${#param_unset[@]}", "0"},
+	}
+	for _, tt := range tests {
+		result, err := expandParameters(tt.input)
+		if err != nil {
+			t.Errorf("expandParameters(%q) returned error: %v", tt.input, err)
+		} else if result != tt.expected {
+			t.Errorf("expandParameters(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"${PIPESTATUS[0}", "${PIPESTATUS[x y]}"} {
+		if result, err := expandParameters(input); err == nil {
+			t.Errorf("expandParameters(%q) = %q, want error", input, result)
+		}
+	}
+}
+
+func TestPlainVariableExpansion(t *testing.T) {
+	GetGlobalState().SetVar("plain_var", "value")
+	defer GetGlobalState().UnsetVar("plain_var")
+	defer GetGlobalState().SetPipeStatus(nil)
+	GetGlobalState().SetPipeStatus([]int{0, 3})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo $plain_var", "echo value"},
+		{"echo $plain_var/dir", "echo value/dir"},
+		{"echo \"$plain_var\"", "echo \"value\""},
+		{"echo '$plain_var'", "echo '$plain_var'"},
+		{"echo \"it's $plain_var\"", "echo \"it's value\""},
+		{"echo \\$plain_var", "echo \\$plain_var"},
+		{"echo $? $unset_plain_var.", "echo 3 ."},
+		{"echo $ $1 $((1))", "echo $  $((1))"},
+	}
+
+	for _, tt := range tests {
+		if result, err := expandParameters(tt.input); err != nil || result != tt.expected {
+			t.Errorf("expandParameters(%q) = %q, %v, want %q", tt.input, result, err, tt.expected)
+		}
+	}
+}
+
+func TestExpandedValuesAreData(t *testing.T) {
+	for _, name := range []string{"data_a", "data_b", "data_c", "data_d"} {
+		defer GetGlobalState().UnsetVar(name)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"data_a='a;echo SEMI'; echo [$data_a]", "[a;echo SEMI]\n"},
+		{"data_b='x|tr x y'; echo $data_b", "x|tr x y\n"},
+		{`printf -v data_c '%q' "a'b"; echo $data_c`, "'a'\"'\"'b'\n"},
+		{"data_d='$((6*7))'; echo $data_d \"$data_d\"", "$((6*7)) $((6*7))\n"},
+		{"data_a='a;echo SEMI'; for w in $data_a x; do echo \"<$w>\"; done", "<a;echo>\n<SEMI>\n<x>\n"},
+		{"for w in {1..3}$data_d; do echo $w; done", "1$((6*7))\n2$((6*7))\n3$((6*7))\n"},
+	}
+	for _, tt := range tests {
+		if output := runEcho(t, tt.input); output != tt.expected {
+			t.Errorf("%q printed %q, want %q", tt.input, output, tt.expected)
+		}
+	}
+}
+
+func TestParameterExpansionDepthLimit(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("${depth_unset:-", depth) + "x" + strings.Repeat("}", depth)
+	}
+	if result, err := expandParameters(nested(maxExpansionDepth)); err != nil || result != "x" {
+		t.Errorf("expandParameters() nested %d deep = %q, %v, want %q", maxExpansionDepth, result, err, "x")
+	}
+	if _, err := expandParameters(nested(maxExpansionDepth + 2)); err == nil {
+		t.Errorf("expandParameters() nested %d deep did not return an error", maxExpansionDepth+2)
+	}
+}
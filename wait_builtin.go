@@ -0,0 +1,63 @@
+package gosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// wait implements `wait [JOBID|PID]...`. Without arguments it waits for
+// every background job and returns 0. Otherwise it waits for each job,
+// given as %N, %string, a job number or the PID of its last process, and
+// returns the exit status of the last one, or 127 if it is not a job of
+// this shell.
+func wait(cmd *Command) error {
+	var args []string
+	if len(cmd.AndCommands) > 0 && len(cmd.AndCommands[0].Pipelines) > 0 && len(cmd.AndCommands[0].Pipelines[0].Commands) > 0 {
+		for _, arg := range cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:] {
+			args = append(args, unquoteArg(arg))
+		}
+	}
+	cmd.ReturnCode = 0
+
+	if len(args) == 0 {
+		for _, job := range cmd.JobManager.ListJobs() {
+			cmd.JobManager.WaitJob(job)
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		job, err := findJobArg(cmd.JobManager, arg)
+		if err != nil {
+			cmd.printError("wait: %v\n", err)
+			cmd.ReturnCode = 127
+			continue
+		}
+		cmd.ReturnCode = cmd.JobManager.WaitJob(job)
+	}
+	return nil
+}
+
+// findJobArg returns the job that spec names. A plain number is taken as
+// a PID first and a job number otherwise.
+func findJobArg(jm *JobManager, spec string) (*Job, error) {
+	if !strings.HasPrefix(spec, "%") {
+		pid, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil, fmt.Errorf("`%s': not a pid or valid job spec", spec)
+		}
+		if job, ok := jm.FindJobByPID(pid); ok {
+			return job, nil
+		}
+	}
+	id, err := jm.ResolveJobSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	job, ok := jm.GetJob(id)
+	if !ok {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return job, nil
+}
@@ -0,0 +1,56 @@
+package gosh
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func runInJobManager(t *testing.T, jobManager *JobManager, input string) (string, int) {
+	t.Helper()
+	cmd, err := NewCommand(input, jobManager)
+	if err != nil {
+		t.Fatalf("Failed to create command %q: %v", input, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = NewSyncWriter(&stdout)
+	cmd.Stderr = NewSyncWriter(&stderr)
+	cmd.Run()
+	return stderr.String(), cmd.ReturnCode
+}
+
+func TestWaitBuiltin(t *testing.T) {
+	useTempCWD(t)
+	jobManager := NewJobManager()
+
+	start := time.Now()
+	if stderr, code := runInJobManager(t, jobManager, "sleep 0.2 & wait"); code != 0 {
+		t.Errorf("wait for all jobs returned %d, want 0; stderr %q", code, stderr)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("wait returned after %v, before the sleep finished", elapsed)
+	}
+	if jobs := jobManager.ListJobs(); len(jobs) != 0 {
+		t.Errorf("ListJobs() after wait = %v, want none", jobs)
+	}
+
+	if stderr, code := runInJobManager(t, jobManager, "grep -q gosh /dev/null & wait %2"); code != 1 {
+		t.Errorf("wait %%2 for grep returned %d, want 1; stderr %q", code, stderr)
+	}
+
+	stderr, _ := runInJobManager(t, jobManager, "grep -q gosh /dev/null &")
+	var id, pid int
+	if _, err := fmt.Sscanf(stderr, "[%d] %d", &id, &pid); err != nil {
+		t.Fatalf("background job report %q: %v", stderr, err)
+	}
+	if stderr, code := runInJobManager(t, jobManager, fmt.Sprintf("wait %d", pid)); code != 1 {
+		t.Errorf("wait PID for grep returned %d, want 1; stderr %q", code, stderr)
+	}
+
+	for _, input := range []string{"wait %9", "wait 999999", "wait abc"} {
+		if stderr, code := runInJobManager(t, jobManager, input); code != 127 || stderr == "" {
+			t.Errorf("%q = (%q, %d), want an error and 127", input, stderr, code)
+		}
+	}
+}
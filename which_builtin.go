@@ -0,0 +1,38 @@
+package gosh
+
+import "fmt"
+
+// which implements `which NAME...`, printing the absolute path of the
+// executable each name runs. Lookups use the completer's index of PATH
+// when there is one. The status is 1 if any name is not found.
+func which(cmd *Command) error {
+	if len(cmd.AndCommands) == 0 || len(cmd.AndCommands[0].Pipelines) == 0 || len(cmd.AndCommands[0].Pipelines[0].Commands) == 0 {
+		return nil
+	}
+	names := cmd.AndCommands[0].Pipelines[0].Commands[0].Parts[1:]
+	if len(names) == 0 {
+		cmd.ReturnCode = 1
+		return fmt.Errorf("Usage: which NAME...")
+	}
+
+	commandIndexMu.RLock()
+	index := commandIndex
+	commandIndexMu.RUnlock()
+
+	cmd.ReturnCode = 0
+	for _, name := range names {
+		resolve := findAbsoluteExecutable
+		if index != nil {
+			resolve = index.ResolvePath
+		}
+		path, ok := resolve(name)
+		if !ok {
+			cmd.ReturnCode = 1
+			continue
+		}
+		if _, err := fmt.Fprintln(cmd.Stdout, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package gosh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWhichBuiltin(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "gosh-which-tool")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write %s: %v", tool, err)
+	}
+	t.Setenv("PATH", dir)
+	<-NewCompleter(nil).loaded
+
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{"which gosh-which-tool", tool + "\n", 0},
+		{"which gosh-which-missing", "", 1},
+		{"which gosh-which-missing gosh-which-tool", tool + "\n", 1},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runWithOptions(t, tt.input)
+		if stdout != tt.expected || code != tt.code {
+			t.Errorf("%q = (%q, %d), want (%q, %d); stderr %q", tt.input, stdout, code, tt.expected, tt.code, stderr)
+		}
+	}
+}